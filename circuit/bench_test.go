@@ -0,0 +1,33 @@
+package circuit
+
+import (
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/scs"
+)
+
+// BenchmarkCompileBatch compiles a batch with every user slot set and reports its constraints. slots are
+// CuratedUsrNum with CuratedBatch, so running it with and without the tag compares a curated batch to a full one
+func BenchmarkCompileBatch(b *testing.B) {
+	cfg := testConfig()
+	for n := range MaxUsrNum {
+		cfg.Users = append(cfg.Users, user(n))
+	}
+	c, err := cfg.NewCircuit()
+	if err != nil {
+		b.Fatal(err)
+	}
+	var constraints int
+	for range b.N {
+		ccs, err := frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, sdk.DefaultHostCircuit(c))
+		if err != nil {
+			b.Fatal(err)
+		}
+		constraints = ccs.GetNbConstraints()
+	}
+	b.ReportMetric(float64(constraints), "constraints")
+	b.ReportMetric(float64(MaxUsrNum), "slots")
+}
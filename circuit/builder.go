@@ -0,0 +1,137 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Builder assembles a Config step by step, eg.
+// NewBuilder().Pool(addr, id).Hook(addr).Blocks(start, end).Tier(min, disc).Users(usrs...).Build()
+// each step is validated right away, the first error stops further steps and is returned by Build
+type Builder struct {
+	cfg Config
+	err error
+}
+
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+func (b *Builder) Epoch(epoch uint32) *Builder {
+	if b.err == nil {
+		b.cfg.Epoch = epoch
+	}
+	return b
+}
+
+// EpochLabel sets the label output instead of the epoch number with the EpochLabel option
+func (b *Builder) EpochLabel(label common.Hash) *Builder {
+	if b.err == nil {
+		b.cfg.EpochLabel = label
+	}
+	return b
+}
+
+func (b *Builder) Pool(addr common.Address, id common.Hash) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if addr == (common.Address{}) || id == (common.Hash{}) {
+		b.err = fmt.Errorf("pool addr and id must be set")
+		return b
+	}
+	b.cfg.PoolAddr, b.cfg.PoolId = addr, id
+	return b
+}
+
+// Hook sets the hook. it may only be zero with NoHookLog, whose pools need no hook
+func (b *Builder) Hook(addr common.Address) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if addr == (common.Address{}) && !NoHookLog {
+		b.err = fmt.Errorf("hook addr must be set")
+		return b
+	}
+	b.cfg.HookAddr = addr
+	return b
+}
+
+func (b *Builder) Blocks(start, end uint64) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if err := validateBlocks(start, end); err != nil {
+		b.err = err
+		return b
+	}
+	b.cfg.BlockStart, b.cfg.BlockEnd = start, end
+	return b
+}
+
+// Tier appends a tier, tiers must be added from LOWEST to HIGHEST
+func (b *Builder) Tier(minAmount *big.Int, discount uint16) *Builder {
+	return b.tier(TierConfig{MinAmount: minAmount, Discount: discount})
+}
+
+// InclusiveTier appends a tier reached at volume equal to minAmount, needs TierInclusive
+func (b *Builder) InclusiveTier(minAmount *big.Int, discount uint16) *Builder {
+	return b.tier(TierConfig{MinAmount: minAmount, Discount: discount, Inclusive: true})
+}
+
+func (b *Builder) tier(t TierConfig) *Builder {
+	if b.err != nil {
+		return b
+	}
+	if len(b.cfg.Tiers) == TierNum {
+		b.err = fmt.Errorf("more than TierNum %d tiers", TierNum)
+		return b
+	}
+	var prev *TierConfig
+	if n := len(b.cfg.Tiers); n > 0 {
+		prev = &b.cfg.Tiers[n-1]
+	}
+	if err := validateTier(prev, t); err != nil {
+		b.err = fmt.Errorf("tier %d: %w", len(b.cfg.Tiers), err)
+		return b
+	}
+	b.cfg.Tiers = append(b.cfg.Tiers, t)
+	return b
+}
+
+// Users appends user slots, may be called multiple times
+func (b *Builder) Users(addrs ...common.Address) *Builder {
+	if b.err != nil {
+		return b
+	}
+	users := append(append([]common.Address{}, b.cfg.Users...), addrs...)
+	if err := validateUsers(users); err != nil {
+		b.err = err
+		return b
+	}
+	b.cfg.Users = users
+	return b
+}
+
+// Config returns the assembled config, or the first error
+func (b *Builder) Config() (*Config, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if b.cfg.BlockEnd == 0 {
+		return nil, fmt.Errorf("blocks not set")
+	}
+	cfg := b.cfg
+	return &cfg, nil
+}
+
+// Build returns the circuit for the assembled config
+func (b *Builder) Build() (*UniVipHookCircuit, error) {
+	cfg, err := b.Config()
+	if err != nil {
+		return nil, err
+	}
+	return cfg.NewCircuit()
+}
@@ -0,0 +1,60 @@
+package circuit
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBuilderFullConfig(t *testing.T) {
+	requireValidConfig(t)
+	label := common.HexToHash("0x2024")
+	want := testConfig()
+	b := NewBuilder().Epoch(7).EpochLabel(label).Pool(testPoolManager, testPoolId).Hook(testHook).
+		Blocks(want.BlockStart, want.BlockEnd).Tier(big.NewInt(1_000), 100).Tier(big.NewInt(10_000), 300)
+	want.EpochLabel = label
+	want.Users = []common.Address{user(1), user(2), user(2)}
+	if TierInclusive {
+		b.InclusiveTier(big.NewInt(100_000), 500)
+		want.Tiers[2].Inclusive = true
+	} else {
+		b.Tier(big.NewInt(100_000), 500)
+	}
+	cfg, err := b.Users(user(1)).Users(user(2), user(2)).Config()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Fatalf("built %+v\nwant %+v", cfg, want)
+	}
+	if _, err := b.Build(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBuilderStopsAtFirstError(t *testing.T) {
+	_, err := NewBuilder().Pool(testPoolManager, testPoolId).Blocks(200, 100).Tier(big.NewInt(1), 1).Config()
+	if err == nil || err.Error() != validateBlocks(200, 100).Error() {
+		t.Fatalf("got %v, want the block range error", err)
+	}
+	if _, err := NewBuilder().Tier(big.NewInt(10), 1).Tier(big.NewInt(10), 2).Config(); err == nil {
+		t.Fatal("tier not above the previous accepted")
+	}
+	if !TierInclusive {
+		if _, err := NewBuilder().InclusiveTier(big.NewInt(10), 1).Config(); err == nil {
+			t.Fatal("inclusive tier accepted without TierInclusive")
+		}
+	}
+}
+
+func TestBuilderZeroHook(t *testing.T) {
+	_, err := NewBuilder().Hook(common.Address{}).Blocks(100, 200).Config()
+	if NoHookLog && err != nil {
+		t.Fatalf("NoHookLog pool without a hook: %v", err)
+	}
+	if !NoHookLog && err == nil {
+		t.Fatal("zero hook accepted")
+	}
+}
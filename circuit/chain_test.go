@@ -0,0 +1,424 @@
+package circuit
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+const (
+	testChainId = 1
+	// hashes of an empty tx list and uncle list, which a node reports for blocks without them
+	emptyTxsHash   = "0x56e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622fb5e363b421"
+	emptyUncleHash = "0x1dcc4de8dec75d7aab85b567b6ccd41ad312451b948a7413f0a142fd40d49347"
+)
+
+// chain is an in-memory chain of txs and their logs for tests. It's a Client for FetchReceipts, and serve runs a
+// JSON-RPC node of it for BrevisApp, which queries receipts, blocks, txs and storage to build circuit input
+type chain struct {
+	blocks   map[uint64][]common.Hash
+	receipts map[common.Hash]*types.Receipt
+	senders  map[common.Hash]common.Address
+	storage  map[common.Address]map[common.Hash][]storageChange
+	txs      uint64
+}
+
+func newChain() *chain {
+	return &chain{
+		blocks:   make(map[uint64][]common.Hash),
+		receipts: make(map[common.Hash]*types.Receipt),
+		senders:  make(map[common.Hash]common.Address),
+		storage:  make(map[common.Address]map[common.Hash][]storageChange),
+	}
+}
+
+// tx adds a tx sent by from to block with logs in order, and returns its hash. logs get their block wide index
+func (ch *chain) tx(block uint64, from common.Address, logs ...*types.Log) common.Hash {
+	ch.txs++
+	h := crypto.Keccak256Hash([]byte("tx"), binary.BigEndian.AppendUint64(nil, ch.txs))
+	first := uint(0)
+	for _, prev := range ch.blocks[block] {
+		first += uint(len(ch.receipts[prev].Logs))
+	}
+	txIndex := uint(len(ch.blocks[block]))
+	for i, l := range logs {
+		l.BlockNumber, l.BlockHash, l.TxHash, l.TxIndex, l.Index = block, blockHash(block), h, txIndex, first+uint(i)
+	}
+	ch.blocks[block] = append(ch.blocks[block], h)
+	ch.receipts[h] = &types.Receipt{
+		Status: 1, Logs: logs, TxHash: h, BlockHash: blockHash(block),
+		BlockNumber: new(big.Int).SetUint64(block), TransactionIndex: txIndex,
+	}
+	ch.senders[h] = from
+	return h
+}
+
+// swap adds a tx of user swapping amount of currency0 in cfg's pool, with the hook's tx.origin log carrying
+// hookData words unless NoHookLog. it returns the Receipt FetchReceipts would
+func (ch *chain) swap(cfg *Config, block uint64, user common.Address, amount int64, hookData ...common.Hash) Receipt {
+	var logs []*types.Log
+	if !NoHookLog {
+		logs = append(logs, hookLog(cfg.HookAddr, TxOriginEv, user, hookData...))
+	}
+	swapPos := uint(len(logs))
+	logs = append(logs, swapLog(cfg.PoolAddr, cfg.PoolId, user, big.NewInt(amount), 0))
+	h := ch.tx(block, user, logs...)
+	return Receipt{
+		TxHash: h, BlockNum: block, User: user, SwapLogPos: swapPos, Amount: big.NewInt(amount),
+		SwapContract: cfg.PoolAddr, HookContract: cfg.HookAddr, PoolId: cfg.PoolId,
+	}
+}
+
+// poolSwap is swap in pool m of cfg, PoolId for 0 and ExtraPools[m-1] after
+func (ch *chain) poolSwap(cfg *Config, m int, block uint64, user common.Address, amount int64) Receipt {
+	pc := *cfg
+	if m > 0 {
+		pc.PoolId, pc.HookAddr = cfg.ExtraPools[m-1].PoolId, cfg.ExtraPools[m-1].HookAddr
+	}
+	r := ch.swap(&pc, block, user, amount)
+	r.Pool = m
+	return r
+}
+
+// v3Swap adds a tx of user swapping amount0 in v3 pool V3PoolAddrs[pool], as its recipient
+func (ch *chain) v3Swap(cfg *Config, pool int, block uint64, user common.Address, amount int64) Receipt {
+	addr := cfg.V3PoolAddrs[pool]
+	l := &types.Log{
+		Address: addr,
+		// Swap(sender, recipient, amount0, amount1, sqrtPriceX96, liquidity, tick)
+		Topics: []common.Hash{common.HexToHash(UniSwapV3Ev), common.BytesToHash(user.Bytes()), common.BytesToHash(user.Bytes())},
+		Data: slices.Concat(word(big.NewInt(amount)), word(big.NewInt(-amount)), word(new(big.Int).Lsh(big.NewInt(1), 96)),
+			word(big.NewInt(1e18)), word(big.NewInt(0))),
+	}
+	h := ch.tx(block, user, l)
+	return Receipt{TxHash: h, BlockNum: block, User: user, Pool: pool, V3: true, Amount: big.NewInt(amount), SwapContract: addr}
+}
+
+// storageChange is a slot's value from block on
+type storageChange struct {
+	block uint64
+	value common.Hash
+}
+
+// setStorage sets slot of addr, it holds value at every block
+func (ch *chain) setStorage(addr common.Address, slot, value common.Hash) {
+	ch.setStorageFrom(addr, slot, 0, value)
+}
+
+// setStorageFrom sets slot of addr to value from block on, earlier blocks keep what they had. changes must be set
+// in block order
+func (ch *chain) setStorageFrom(addr common.Address, slot common.Hash, block uint64, value common.Hash) {
+	if ch.storage[addr] == nil {
+		ch.storage[addr] = make(map[common.Hash][]storageChange)
+	}
+	ch.storage[addr][slot] = append(ch.storage[addr][slot], storageChange{block, value})
+}
+
+// storageAt is slot of addr at the end of block
+func (ch *chain) storageAt(addr common.Address, slot common.Hash, block uint64) common.Hash {
+	var value common.Hash
+	for _, c := range ch.storage[addr][slot] {
+		if c.block <= block {
+			value = c.value
+		}
+	}
+	return value
+}
+
+// swapLog is a PoolManager Swap(id, sender, amount0, amount1, sqrtPriceX96, liquidity, tick, fee) log, amount1
+// is -amount0
+func swapLog(manager common.Address, id common.Hash, sender common.Address, amount0 *big.Int, tick int32) *types.Log {
+	return &types.Log{
+		Address: manager,
+		Topics:  []common.Hash{common.HexToHash(UniSwapEv), id, common.BytesToHash(sender.Bytes())},
+		Data: slices.Concat(word(amount0), word(new(big.Int).Neg(amount0)), word(new(big.Int).Lsh(big.NewInt(1), 96)),
+			word(big.NewInt(1e18)), word(big.NewInt(int64(tick))), word(big.NewInt(3000))),
+	}
+}
+
+// hookLog is a log of hook with topics event and user, and data words
+func hookLog(hook common.Address, event string, user common.Address, data ...common.Hash) *types.Log {
+	l := &types.Log{Address: hook, Topics: []common.Hash{common.HexToHash(event), common.BytesToHash(user.Bytes())}}
+	for _, d := range data {
+		l.Data = append(l.Data, d.Bytes()...)
+	}
+	return l
+}
+
+// word is v as a 32 byte two's complement word
+func word(v *big.Int) []byte {
+	if v.Sign() < 0 {
+		v = new(big.Int).Add(v, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return common.BigToHash(v).Bytes()
+}
+
+func blockHash(block uint64) common.Hash {
+	return crypto.Keccak256Hash([]byte("block"), binary.BigEndian.AppendUint64(nil, block))
+}
+
+// FilterLogs returns logs in q's block range matching its addresses and topics, in chain order
+func (ch *chain) FilterLogs(_ context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	var logs []types.Log
+	for _, block := range ch.blockNums() {
+		if q.FromBlock != nil && block < q.FromBlock.Uint64() || q.ToBlock != nil && block > q.ToBlock.Uint64() {
+			continue
+		}
+		for _, h := range ch.blocks[block] {
+			for _, l := range ch.receipts[h].Logs {
+				if logMatches(*l, q) {
+					logs = append(logs, *l)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+func logMatches(l types.Log, q ethereum.FilterQuery) bool {
+	if len(q.Addresses) > 0 && !slices.Contains(q.Addresses, l.Address) {
+		return false
+	}
+	for i, want := range q.Topics {
+		if len(want) == 0 {
+			continue
+		}
+		if i >= len(l.Topics) || !slices.Contains(want, l.Topics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ch *chain) TransactionReceipt(_ context.Context, h common.Hash) (*types.Receipt, error) {
+	rc, ok := ch.receipts[h]
+	if !ok {
+		return nil, fmt.Errorf("no receipt of %s", h.Hex())
+	}
+	return rc, nil
+}
+
+func (ch *chain) blockNums() []uint64 {
+	var nums []uint64
+	for n := range ch.blocks {
+		nums = append(nums, n)
+	}
+	slices.Sort(nums)
+	return nums
+}
+
+// serve runs a JSON-RPC node of ch until t ends and returns its url
+func (ch *chain) serve(t *testing.T) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		type request struct {
+			ID     json.RawMessage   `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		var reqs []request
+		batch := strings.HasPrefix(strings.TrimSpace(string(body)), "[")
+		if batch {
+			err = json.Unmarshal(body, &reqs)
+		} else {
+			reqs = make([]request, 1)
+			err = json.Unmarshal(body, &reqs[0])
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var resps []map[string]any
+		for _, req := range reqs {
+			resp := map[string]any{"jsonrpc": "2.0", "id": req.ID}
+			if result, err := ch.call(req.Method, req.Params); err != nil {
+				resp["error"] = map[string]any{"code": -32601, "message": err.Error()}
+			} else {
+				resp["result"] = result
+			}
+			resps = append(resps, resp)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if batch {
+			err = json.NewEncoder(w).Encode(resps)
+		} else {
+			err = json.NewEncoder(w).Encode(resps[0])
+		}
+		if err != nil {
+			t.Errorf("test node: %v", err)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+// call answers the JSON-RPC methods BrevisApp uses, anything else is an error naming the method so a new SDK
+// query shows up as a clear test failure
+func (ch *chain) call(method string, params []json.RawMessage) (any, error) {
+	arg := func(i int, v any) error {
+		if i >= len(params) {
+			return fmt.Errorf("%s: missing param %d", method, i)
+		}
+		return json.Unmarshal(params[i], v)
+	}
+	switch method {
+	case "eth_chainId":
+		return hexUint(testChainId), nil
+	case "net_version":
+		return strconv.Itoa(testChainId), nil
+	case "eth_blockNumber":
+		nums := ch.blockNums()
+		if len(nums) == 0 {
+			return hexUint(0), nil
+		}
+		return hexUint(nums[len(nums)-1]), nil
+	case "eth_getTransactionReceipt", "eth_getTransactionByHash":
+		var h common.Hash
+		if err := arg(0, &h); err != nil {
+			return nil, err
+		}
+		rc, ok := ch.receipts[h]
+		if !ok {
+			return nil, nil
+		}
+		if method == "eth_getTransactionReceipt" {
+			return receiptJSON(rc), nil
+		}
+		return ch.txJSON(rc), nil
+	case "eth_getBlockByNumber", "eth_getBlockByHash":
+		var full bool
+		if err := arg(1, &full); err != nil {
+			return nil, err
+		}
+		block, err := ch.blockArg(method, params[0])
+		if err != nil {
+			return nil, err
+		}
+		return ch.blockJSON(block, full), nil
+	case "eth_getStorageAt":
+		var addr common.Address
+		var slot common.Hash
+		if err := arg(0, &addr); err != nil {
+			return nil, err
+		}
+		if err := arg(1, &slot); err != nil {
+			return nil, err
+		}
+		if len(params) < 3 {
+			return nil, fmt.Errorf("%s: missing block", method)
+		}
+		block, err := ch.blockArg("eth_getBlockByNumber", params[2])
+		if err != nil {
+			return nil, err
+		}
+		return ch.storageAt(addr, slot, block).Hex(), nil
+	}
+	return nil, fmt.Errorf("test node has no method %s", method)
+}
+
+// blockArg returns the block number param of a by number or by hash query
+func (ch *chain) blockArg(method string, param json.RawMessage) (uint64, error) {
+	var s string
+	if err := json.Unmarshal(param, &s); err != nil {
+		return 0, err
+	}
+	if method == "eth_getBlockByHash" {
+		for n := range ch.blocks {
+			if blockHash(n) == common.HexToHash(s) {
+				return n, nil
+			}
+		}
+		return 0, fmt.Errorf("no block %s", s)
+	}
+	if s == "latest" || s == "finalized" || s == "safe" {
+		nums := ch.blockNums()
+		if len(nums) == 0 {
+			return 0, nil
+		}
+		return nums[len(nums)-1], nil
+	}
+	return strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+}
+
+func (ch *chain) blockJSON(block uint64, full bool) map[string]any {
+	txs := []any{}
+	txRoot := emptyTxsHash
+	for _, h := range ch.blocks[block] {
+		if full {
+			txs = append(txs, ch.txJSON(ch.receipts[h]))
+		} else {
+			txs = append(txs, h.Hex())
+		}
+		txRoot = crypto.Keccak256Hash([]byte("txs"), blockHash(block).Bytes()).Hex()
+	}
+	var parent common.Hash
+	if block > 0 {
+		parent = blockHash(block - 1)
+	}
+	return map[string]any{
+		"hash": blockHash(block).Hex(), "parentHash": parent.Hex(), "number": hexUint(block),
+		"sha3Uncles": emptyUncleHash, "uncles": []any{}, "miner": common.Address{}.Hex(),
+		"stateRoot": common.Hash{}.Hex(), "transactionsRoot": txRoot, "receiptsRoot": common.Hash{}.Hex(),
+		"logsBloom": hexBytes(make([]byte, 256)), "difficulty": hexUint(0), "gasLimit": hexUint(30_000_000),
+		"gasUsed": hexUint(uint64(len(txs)) * 21000), "timestamp": hexUint(block * 12), "extraData": "0x",
+		"mixHash": common.Hash{}.Hex(), "nonce": "0x0000000000000000", "baseFeePerGas": hexUint(1e9),
+		"transactions": txs,
+	}
+}
+
+// txJSON is a legacy tx of rc's sender. its signature values are only well formed, the node reports the sender
+func (ch *chain) txJSON(rc *types.Receipt) map[string]any {
+	return map[string]any{
+		"type": "0x0", "hash": rc.TxHash.Hex(), "from": ch.senders[rc.TxHash].Hex(), "to": common.Address{}.Hex(),
+		"nonce": hexUint(0), "gasPrice": hexUint(1e9), "gas": hexUint(21000), "value": hexUint(0), "input": "0x",
+		"v": hexUint(testChainId*2 + 35), "r": hexUint(1), "s": hexUint(1), "chainId": hexUint(testChainId),
+		"blockHash": rc.BlockHash.Hex(), "blockNumber": hexUint(rc.BlockNumber.Uint64()),
+		"transactionIndex": hexUint(uint64(rc.TransactionIndex)),
+	}
+}
+
+func receiptJSON(rc *types.Receipt) map[string]any {
+	logs := []any{}
+	for _, l := range rc.Logs {
+		topics := []string{}
+		for _, tp := range l.Topics {
+			topics = append(topics, tp.Hex())
+		}
+		logs = append(logs, map[string]any{
+			"address": l.Address.Hex(), "topics": topics, "data": hexBytes(l.Data),
+			"blockNumber": hexUint(l.BlockNumber), "blockHash": l.BlockHash.Hex(), "transactionHash": l.TxHash.Hex(),
+			"transactionIndex": hexUint(uint64(l.TxIndex)), "logIndex": hexUint(uint64(l.Index)), "removed": false,
+		})
+	}
+	return map[string]any{
+		"type": "0x0", "status": hexUint(rc.Status), "transactionHash": rc.TxHash.Hex(), "logs": logs,
+		"blockHash": rc.BlockHash.Hex(), "blockNumber": hexUint(rc.BlockNumber.Uint64()),
+		"transactionIndex": hexUint(uint64(rc.TransactionIndex)), "cumulativeGasUsed": hexUint(21000),
+		"gasUsed": hexUint(21000), "effectiveGasPrice": hexUint(1e9), "logsBloom": hexBytes(make([]byte, 256)),
+		"contractAddress": nil,
+	}
+}
+
+func hexUint(v uint64) string { return "0x" + strconv.FormatUint(v, 16) }
+
+func hexBytes(b []byte) string { return "0x" + hex.EncodeToString(b) }
@@ -0,0 +1,31 @@
+package circuit
+
+import (
+	"encoding/binary"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// ClaimHash computes the claim message output for addr when OutputClaimHash is on, discount is its output discount.
+// it's not prefixed, a contract verifying a personal_sign signature applies MessageHashUtils.toEthSignedMessageHash
+func ClaimHash(addr common.Address, discount uint16, epoch uint32, nonce uint64) common.Hash {
+	var buf []byte
+	buf = binary.BigEndian.AppendUint16(buf, discount)
+	buf = binary.BigEndian.AppendUint32(buf, epoch)
+	buf = binary.BigEndian.AppendUint64(buf, nonce)
+	return crypto.Keccak256Hash(addr.Bytes(), buf)
+}
+
+// LabeledClaimHash is ClaimHash with EpochLabel on, label is the bytes32 epoch the header outputs instead of epoch
+func LabeledClaimHash(addr common.Address, discount uint16, label common.Hash, nonce uint64) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), binary.BigEndian.AppendUint16(nil, discount), label.Bytes(),
+		binary.BigEndian.AppendUint64(nil, nonce))
+}
+
+// UserCommitment computes the commitment output for addr when OutputUserCommitment is on.
+// salt must fit 31 bytes. a user opens their commitment by publishing salt, anyone can recompute it
+func UserCommitment(addr common.Address, salt *big.Int) common.Hash {
+	return crypto.Keccak256Hash(addr.Bytes(), common.LeftPadBytes(salt.Bytes(), 31))
+}
@@ -0,0 +1,69 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestClaimHashRecomputed(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputClaimHash")
+	cfg.ClaimNonces = map[common.Address]uint64{user(2): 3}
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 50_000)}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	if len(rs) != 2 {
+		t.Fatalf("%d results, want 2", len(rs))
+	}
+	// what a claimer signs, from the row alone and its known nonce
+	for _, r := range rs {
+		want := ClaimHash(r.Address, uint16(r.Values["discount"].Uint64()), cfg.Epoch, cfg.ClaimNonces[r.Address])
+		if got := common.BigToHash(r.Values["claimHash"]); got != want {
+			t.Errorf("%s claim hash %s, recomputed %s", r.Address.Hex(), got.Hex(), want.Hex())
+		}
+	}
+}
+
+func TestClaimHashBindsEpochLabel(t *testing.T) {
+	cfg, ch := optionTest(t, "EpochLabel", "OutputClaimHash")
+	cfg.EpochLabel = common.HexToHash("0x2024")
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	if got := decodeHeader(t, out)["epoch"]; got.Cmp(cfg.EpochLabel.Big()) != 0 {
+		t.Fatalf("header epoch %x, want the label", got)
+	}
+	r := resultOf(t, decodeResults(t, out), user(1))
+	want := LabeledClaimHash(user(1), uint16(r.Values["discount"].Uint64()), cfg.EpochLabel, 0)
+	if got := common.BigToHash(r.Values["claimHash"]); got != want {
+		t.Errorf("claim hash %s, want %s of the label", got.Hex(), want.Hex())
+	}
+}
+
+func TestUserCommitmentOpens(t *testing.T) {
+	requireOptions(t, "OutputUserCommitment")
+	if OutputRequestedUsers {
+		t.Skip("requested user rows have plain addresses")
+	}
+	cfg := testConfig()
+	cfg.Salt = big.NewInt(0x5a17)
+	ch := newChain()
+	out := proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 50_000)})
+	l := DefaultOutputLayout()
+	row := l.Bytes(1) - l.Bytes(0)
+	// the commitment is the first field of a row, rows are in slot order
+	commitment := func(slot int) common.Hash {
+		pos := l.Bytes(0) + slot*row
+		return common.BytesToHash(out[pos : pos+32])
+	}
+	for slot, u := range []common.Address{user(1), user(2)} {
+		if got := commitment(slot); got != UserCommitment(u, cfg.Salt) {
+			t.Errorf("slot %d commitment %x doesn't open to user with the salt", slot, got)
+		}
+		if commitment(slot) == UserCommitment(u, big.NewInt(0x5a18)) {
+			t.Errorf("slot %d commitment opens with another salt", slot)
+		}
+	}
+	if got := commitment(2); got != (common.Hash{}) {
+		t.Errorf("padding commitment %x, want 0", got)
+	}
+}
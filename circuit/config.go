@@ -0,0 +1,990 @@
+package circuit
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// MaxDiscount is 100% discount, same as VipDiscountMap.MAX_DISCOUNT
+const MaxDiscount = 10000
+
+// unused tier slots use this min amount, no volume is greater than it
+var maxUint248 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 248), big.NewInt(1))
+
+// tick bounds of v4-core TickMath
+const (
+	minTick = -887272
+	maxTick = 887272
+)
+
+// CountTierConfig is one count tier with CountTiers, users with more than MinSwaps counted swaps get MultiplierBps
+type CountTierConfig struct {
+	MinSwaps      uint32
+	MultiplierBps uint16
+}
+
+// TxRef is one tx of AllowedTxs with TxAllowlist, FetchTxRefs looks up block and index of tx hashes
+type TxRef struct {
+	TxHash   common.Hash
+	BlockNum uint64
+	TxIndex  uint
+}
+
+// TierConfig is one VIP tier, users with volume greater than MinAmount get Discount (percentage*100)
+type TierConfig struct {
+	MinAmount *big.Int
+	Discount  uint16
+	// with TierInclusive, volume equal to MinAmount reaches the tier. MinAmount must then be non-zero
+	Inclusive bool
+}
+
+// Config is the go side config of one batch, NewCircuit converts it to circuit inputs
+type Config struct {
+	Epoch uint32
+	// with EpochLabel, output instead of Epoch, eg. keccak256 of "2024-W05"
+	EpochLabel         common.Hash
+	PoolAddr, HookAddr common.Address
+	PoolId             common.Hash
+	// receipts must be in (BlockStart, BlockEnd), or [BlockStart, BlockEnd) with HalfOpenBlockRange
+	BlockStart, BlockEnd uint64
+	// block of snapshot state proofs, in (BlockStart, BlockEnd], 0 means BlockEnd
+	StateRefBlock uint64
+	// sorted from LOWEST to HIGHEST, at most TierNum
+	Tiers []TierConfig
+	// with CountTiers, tiers on swap count, sorted and at most TierNum like Tiers
+	CountTiers []CountTierConfig
+	// with TxAllowlist, the only txs whose receipts count, at most MaxAllowedTxs
+	AllowedTxs []TxRef
+	// unit of tier discounts, 0 means MaxDiscount
+	DiscountDenom uint16
+	// with Sharded, users must all have address % ShardCount == ShardIndex. ShardCount 0 means 1
+	ShardIndex, ShardCount uint16
+	// largest discount increase from one tier to the next, tier 0 from 0. 0 means no limit
+	MaxDiscountStep uint16
+	// users below this tier level (1 is Tiers[0]) are output as padding
+	MinOutputTier uint8
+	// at most MaxUsrNum, same addr must be adjacent
+	Users []common.Address
+
+	// options, only used if the matching constant is on
+	SelfTradeAddrs []common.Address
+	BatchVolumeCap *big.Int
+	Salt           *big.Int
+	// 0 means BpsDenom
+	ConcentrationBps, ConcentrationPenaltyBps uint64
+	// with CapRewardShare, 0 means BpsDenom
+	MaxRewardShareBps uint64
+	MinLiquidity      *big.Int
+	HookImpl          common.Address
+	// primary and secondary swap log weights, 0 means BpsDenom
+	SwapLogWeightBps [2]uint64
+	// pools besides PoolId, at most MaxPoolNum-1
+	ExtraPools []PoolConfig
+	// tier level needing MinPools distinct pools, 0 disables
+	MultiPoolTier, MinPools uint8
+	// with CanonicalVolumeToken, volume is counted in VolumeToken using each pool's currencies.
+	// TokenAliases maps tokens to their canonical equivalent, eg. WETH to native ETH (zero addr)
+	VolumeToken          common.Address
+	TokenAliases         map[common.Address]common.Address
+	Currency0, Currency1 common.Address
+	MinUsers             uint8
+	// with PenalizeFreshUsers, AgeProofTxs has a tx sent by each established user before AgeCutoffBlock.
+	// FreshPenaltyBps 0 means BpsDenom
+	AgeCutoffBlock  uint64
+	FreshPenaltyBps uint64
+	AgeProofTxs     map[common.Address]common.Hash
+	// with EOAUsersOnly, any tx sent by each user. users without one count nothing
+	EOAProofTxs map[common.Address]common.Hash
+	// with CheckHookFlags, 0 means AfterInitializeFlag|BeforeSwapFlag
+	HookFlags uint16
+	// with AggregateEntities, maps sub-accounts to a non-zero entity id
+	Entities map[common.Address]uint64
+	// with RoundOutputVolume, nil or 0 means 1, ie. no rounding
+	VolumePrecision *big.Int
+	// with PerHookConfig, layout of HookAddr's tx.origin event. extra pools set their own layout and tiers
+	HookLayout
+	// with FilterDustSwaps, nil means 0, ie. only swaps with both amounts 0 are dropped
+	DustThreshold *big.Int
+	// with CapSwapContribution, nil means no cap
+	MaxSwapContribution *big.Int
+	// with AssertMaxSwapAmount, nil means no ceiling
+	MaxSwapAmount *big.Int
+	// with RequireMinBatchVolume, nil means 0, ie. only batches with no volume fail
+	MinBatchVolume *big.Int
+	// with RequireOptIn, registry holding each user's opt-in block in a mapping(address => uint256) at
+	// OptInMappingSlot. the circuit reads the storage proofs, Simulate drops receipts at or before a user's
+	// OptInBlocks entry
+	OptInRegistry    common.Address
+	OptInMappingSlot uint64
+	OptInBlocks      map[common.Address]uint64
+	// with CapTierJump, each user's tier level last epoch, eg. from its output discount. missing users are new, level 0
+	PriorTiers map[common.Address]uint8
+	// with RequireTag, the hook log tag a swap needs to count
+	RequiredTag common.Hash
+	// with OutputClaimHash, each user's claim nonce, eg. its count of claims so far. missing users are 0
+	ClaimNonces map[common.Address]uint64
+	// with OutputFlowRate, fee in pips the discount applies to, and the epoch's length. EpochSeconds 0 means
+	// (BlockEnd - BlockStart) * SecondsPerBlock
+	RebateFeePips uint32
+	EpochSeconds  uint64
+	// with ReputationBoost, registry holding each user's reputation in a mapping(address => uint256) at
+	// ReputationMappingSlot, and the tier volume one point adds, nil means 0. Reputation is the scores Simulate
+	// adds in place of the proofs, a user missing from it gets no boost
+	ReputationRegistry    common.Address
+	ReputationMappingSlot uint64
+	ReputationScale       *big.Int
+	Reputation            map[common.Address]uint64
+	// with NumeraireVolume, the oracle slot whose value at StateRefBlock is the price, see NumeraireShift.
+	// NumerairePrice is the price Simulate converts with, nil leaves its amounts unconverted
+	NumeraireOracle common.Address
+	NumeraireSlot   common.Hash
+	NumerairePrice  *big.Int
+	// with TickRange, inclusive band of int24 ticks a swap must end in
+	TickLower, TickUpper int32
+	// with CapUserSwaps, 0 means MaxReceipts, ie. no cap
+	MaxUserSwaps uint32
+	// with V3Pools, v3 pools whose swaps also count, see Receipt.V3
+	V3PoolAddrs []common.Address
+	// with NoHookLog, swap senders that route for other accounts, eg. the universal router, at most MaxRouterNum.
+	// their swaps don't count for anyone
+	Routers []common.Address
+	// with StreakBonus, each user's consecutive active epochs including this one, eg. from previous epochs' outputs
+	Streaks                           map[common.Address]uint64
+	StreakBonusBps, MaxStreakBonusBps uint64
+	// with BlendedMetric, weights used as given, eg. 0 volume weight tiers on swap count only. nil scale means 0
+	VolumeWeightBps, CountWeightBps uint64
+	SwapCountScale                  *big.Int
+	// with PoolWeights, weight of PoolId's swaps, extra pools set their own. 0 means BpsDenom
+	PoolWeightBps uint64
+	// with OutputRequestedUsers, at most MaxRequestedUsers addresses to output, in this order
+	RequestedUsers []common.Address
+}
+
+// PoolConfig is one more pool of the same PoolManager, with its own hook
+type PoolConfig struct {
+	PoolId               common.Hash
+	HookAddr             common.Address
+	Currency0, Currency1 common.Address
+	// with PerHookConfig, same as the Config fields of the same name
+	HookLayout
+	Tiers []TierConfig
+	// with PoolWeights, same as Config.PoolWeightBps
+	WeightBps uint64
+}
+
+// HookLayout is where a hook emits tx.origin, zero values mean the TxOrigin event at OriginTopicIndex
+type HookLayout struct {
+	HookEventId      common.Hash
+	OriginTopicIndex uint8
+}
+
+func (l HookLayout) eventId() sdk.Uint248 {
+	if l.HookEventId == (common.Hash{}) {
+		return EventIdHook
+	}
+	return sdk.ParseEventID(l.HookEventId.Bytes())
+}
+
+func (l HookLayout) originIndex() uint {
+	if l.OriginTopicIndex == 0 {
+		return OriginTopicIndex
+	}
+	return uint(l.OriginTopicIndex)
+}
+
+// canonical returns token after TokenAliases
+func (cfg *Config) canonical(token common.Address) common.Address {
+	if alias, ok := cfg.TokenAliases[token]; ok {
+		return alias
+	}
+	return token
+}
+
+// amountIndex returns data index of the swap amount in VolumeToken for a pool with currency0 and currency1
+func (cfg *Config) amountIndex(currency0, currency1 common.Address) (uint64, error) {
+	token := cfg.canonical(cfg.VolumeToken)
+	switch token {
+	case cfg.canonical(currency0):
+		return AmountDataIndex, nil
+	case cfg.canonical(currency1):
+		return Amount1DataIndex, nil
+	}
+	return 0, fmt.Errorf("pool %s/%s has no currency equivalent to volume token %s", currency0.Hex(), currency1.Hex(), cfg.VolumeToken.Hex())
+}
+
+// Validate checks cfg fits circuit constants and follows tier and user ordering rules
+func (cfg *Config) Validate() error {
+	if err := validateBlocks(cfg.BlockStart, cfg.BlockEnd); err != nil {
+		return err
+	}
+	if AssertEpochLength && cfg.BlockEnd-cfg.BlockStart != EpochBlocks {
+		return fmt.Errorf("epoch is %d blocks, not EpochBlocks %d", cfg.BlockEnd-cfg.BlockStart, EpochBlocks)
+	}
+	if ref := cfg.stateRefBlock(); ref <= cfg.BlockStart || ref > cfg.BlockEnd {
+		return fmt.Errorf("state ref block %d outside (%d, %d]", ref, cfg.BlockStart, cfg.BlockEnd)
+	}
+	if len(cfg.Tiers) > TierNum {
+		return fmt.Errorf("%d tiers exceeds TierNum %d", len(cfg.Tiers), TierNum)
+	}
+	for i, t := range cfg.Tiers {
+		var prev *TierConfig
+		if i > 0 {
+			prev = &cfg.Tiers[i-1]
+		}
+		if err := validateTier(prev, t); err != nil {
+			return fmt.Errorf("tier %d: %w", i, err)
+		}
+		if cfg.DiscountDenom != 0 && t.Discount > cfg.DiscountDenom {
+			return fmt.Errorf("tier %d: discount %d greater than denom %d", i, t.Discount, cfg.DiscountDenom)
+		}
+	}
+	if len(cfg.CountTiers) > TierNum {
+		return fmt.Errorf("%d count tiers exceeds TierNum %d", len(cfg.CountTiers), TierNum)
+	}
+	for i := 1; i < len(cfg.CountTiers); i++ {
+		if cfg.CountTiers[i].MinSwaps <= cfg.CountTiers[i-1].MinSwaps {
+			return fmt.Errorf("count tier %d: min swaps must be greater than previous tier's", i)
+		}
+	}
+	if cfg.MaxDiscountStep != 0 {
+		prev := uint16(0)
+		for i, t := range cfg.Tiers {
+			if t.Discount > prev && t.Discount-prev > cfg.MaxDiscountStep {
+				return fmt.Errorf("tier %d: discount %d jumps more than %d from %d", i, t.Discount, cfg.MaxDiscountStep, prev)
+			}
+			prev = t.Discount
+		}
+	}
+	if int(cfg.MinOutputTier) > len(cfg.Tiers) {
+		return fmt.Errorf("min output tier %d above number of tiers %d", cfg.MinOutputTier, len(cfg.Tiers))
+	}
+	if err := validateUsers(cfg.Users); err != nil {
+		return err
+	}
+	if UnsignedAmounts && (NetSwapLogs || OutputMatchedVolume) {
+		return fmt.Errorf("unsigned amounts have no sign for NetSwapLogs or OutputMatchedVolume")
+	}
+	if NoHookLog && (CheckHookImpl || CheckHookFlags || PerHookConfig) {
+		return fmt.Errorf("NoHookLog has no hook to check")
+	}
+	if GateLowestTier && DeltaAddresses {
+		return fmt.Errorf("GateLowestTier output rows can't be delta encoded")
+	}
+	if TopTierOnly && (DeltaAddresses || OutputRequestedUsers) {
+		return fmt.Errorf("TopTierOnly packs rows, it can't be combined with DeltaAddresses or OutputRequestedUsers")
+	}
+	if TopTierOnly && len(cfg.Tiers) != TierNum {
+		return fmt.Errorf("TopTierOnly needs all %d tiers configured, padded tiers are unreachable", TierNum)
+	}
+	if PerHookConfig && (FilterMinOutputTier || GateLowestTier || TopTierOnly || CapTierJump || OutputTierHistogram ||
+		OutputQualifiedTiers || OutputNextTierGap) {
+		// tier levels are of PoolId's table, the discount is the best of each pool's own
+		return fmt.Errorf("options using tier levels can't be combined with PerHookConfig's per pool tier tables")
+	}
+	if OutputResultCount && (DeltaAddresses || OutputRequestedUsers) {
+		return fmt.Errorf("OutputResultCount packs rows, it can't be combined with DeltaAddresses or OutputRequestedUsers")
+	}
+	if DeltaAddresses {
+		if err := validateDeltaUsers(cfg.Users); err != nil {
+			return err
+		}
+	}
+	if AssertUsersNotProtocol {
+		protocol := []common.Address{cfg.PoolAddr, cfg.HookAddr}
+		for _, p := range cfg.ExtraPools {
+			protocol = append(protocol, p.HookAddr)
+		}
+		for i, u := range cfg.Users {
+			if slices.Contains(protocol, u) {
+				return fmt.Errorf("user %d %s is the pool manager or a hook", i, u.Hex())
+			}
+		}
+	}
+	if Sharded {
+		if err := cfg.validateShard(); err != nil {
+			return err
+		}
+	}
+	if RequireMinUsers && distinctUsers(cfg.Users) < int(cfg.MinUsers) {
+		return fmt.Errorf("%d distinct users, need at least %d", distinctUsers(cfg.Users), cfg.MinUsers)
+	}
+	if len(cfg.SelfTradeAddrs) > MaxSelfTradeAddrs {
+		return fmt.Errorf("%d self trade addrs exceeds MaxSelfTradeAddrs %d", len(cfg.SelfTradeAddrs), MaxSelfTradeAddrs)
+	}
+	if len(cfg.RequestedUsers) > MaxRequestedUsers {
+		return fmt.Errorf("%d requested users exceeds MaxRequestedUsers %d", len(cfg.RequestedUsers), MaxRequestedUsers)
+	}
+	for k, u := range cfg.RequestedUsers {
+		if u == (common.Address{}) {
+			return fmt.Errorf("requested user %d is zero address", k)
+		}
+	}
+	if len(cfg.Routers) > MaxRouterNum {
+		return fmt.Errorf("%d routers exceeds MaxRouterNum %d", len(cfg.Routers), MaxRouterNum)
+	}
+	if NoHookLog {
+		for i, u := range cfg.Users {
+			if slices.Contains(cfg.Routers, u) {
+				return fmt.Errorf("user %d %s is a router, NoHookLog can't credit its swaps", i, u.Hex())
+			}
+		}
+	}
+	if len(cfg.V3PoolAddrs) > MaxV3PoolNum {
+		return fmt.Errorf("%d v3 pools exceeds MaxV3PoolNum %d", len(cfg.V3PoolAddrs), MaxV3PoolNum)
+	}
+	if len(cfg.ExtraPools) > MaxPoolNum-1 {
+		return fmt.Errorf("%d extra pools exceeds MaxPoolNum-1 %d", len(cfg.ExtraPools), MaxPoolNum-1)
+	}
+	for m, p := range cfg.ExtraPools {
+		if !PerHookConfig && len(p.Tiers) > 0 {
+			return fmt.Errorf("extra pool %d: tiers need PerHookConfig", m)
+		}
+		if len(p.Tiers) > TierNum {
+			return fmt.Errorf("extra pool %d: %d tiers exceeds TierNum %d", m, len(p.Tiers), TierNum)
+		}
+		for i, t := range p.Tiers {
+			var prev *TierConfig
+			if i > 0 {
+				prev = &p.Tiers[i-1]
+			}
+			if err := validateTier(prev, t); err != nil {
+				return fmt.Errorf("extra pool %d tier %d: %w", m, i, err)
+			}
+			if t.Inclusive {
+				return fmt.Errorf("extra pool %d tier %d: only PoolId tiers may be inclusive", m, i)
+			}
+		}
+	}
+	if int(cfg.MultiPoolTier) > len(cfg.Tiers) || int(cfg.MinPools) > 1+len(cfg.ExtraPools) {
+		return fmt.Errorf("multi pool tier %d or min pools %d out of range", cfg.MultiPoolTier, cfg.MinPools)
+	}
+	if NetSwapLogs && !WeightedSwapLogs {
+		return fmt.Errorf("NetSwapLogs needs WeightedSwapLogs for the second swap log")
+	}
+	if FilterDustSwaps && WeightedSwapLogs {
+		return fmt.Errorf("FilterDustSwaps and WeightedSwapLogs both need Fields[3]")
+	}
+	if TickRange && (FilterDustSwaps || WeightedSwapLogs) {
+		return fmt.Errorf("TickRange needs Fields[3], also used by FilterDustSwaps and WeightedSwapLogs")
+	}
+	if OutputGasWeightedVolume && (FilterDustSwaps || WeightedSwapLogs || TickRange) {
+		return fmt.Errorf("OutputGasWeightedVolume needs Fields[3], also used by FilterDustSwaps, WeightedSwapLogs and TickRange")
+	}
+	if OutputGasWeightedVolume && (NoHookLog || V3Pools) {
+		return fmt.Errorf("OutputGasWeightedVolume reads gas from the hook log, NoHookLog and V3Pools swaps have none")
+	}
+	if RequireTag && (FilterDustSwaps || WeightedSwapLogs || TickRange || OutputGasWeightedVolume) {
+		return fmt.Errorf("RequireTag needs Fields[3], also used by FilterDustSwaps, WeightedSwapLogs, TickRange and OutputGasWeightedVolume")
+	}
+	if RequireTag && (NoHookLog || V3Pools) {
+		return fmt.Errorf("RequireTag reads the tag from the hook log, NoHookLog and V3Pools swaps have none")
+	}
+	if TickRange && (cfg.TickLower > cfg.TickUpper || cfg.TickLower < minTick || cfg.TickUpper > maxTick) {
+		return fmt.Errorf("tick range [%d, %d] invalid", cfg.TickLower, cfg.TickUpper)
+	}
+	if cfg.DustThreshold != nil && (cfg.DustThreshold.Sign() < 0 || cfg.DustThreshold.Cmp(maxUint248) >= 0) {
+		return fmt.Errorf("dust threshold %s out of range", cfg.DustThreshold)
+	}
+	if cfg.MaxSwapContribution != nil && (cfg.MaxSwapContribution.Sign() < 0 || cfg.MaxSwapContribution.Cmp(maxUint248) > 0) {
+		return fmt.Errorf("max swap contribution %s out of range", cfg.MaxSwapContribution)
+	}
+	if cfg.MaxSwapAmount != nil && (cfg.MaxSwapAmount.Sign() < 0 || cfg.MaxSwapAmount.Cmp(maxUint248) > 0) {
+		return fmt.Errorf("max swap amount %s out of range", cfg.MaxSwapAmount)
+	}
+	if len(cfg.AllowedTxs) > MaxAllowedTxs || TxAllowlist && len(cfg.AllowedTxs) == 0 {
+		return fmt.Errorf("%d allowed txs, TxAllowlist needs 1 to MaxAllowedTxs %d", len(cfg.AllowedTxs), MaxAllowedTxs)
+	}
+	for _, tx := range cfg.AllowedTxs {
+		if tx.BlockNum == 0 || tx.BlockNum > math.MaxUint32 || tx.TxIndex >= 1<<24 {
+			return fmt.Errorf("allowed tx %s at block %d index %d out of range", tx.TxHash.Hex(), tx.BlockNum, tx.TxIndex)
+		}
+	}
+	if OutputEffectiveDiscount && !CountTiers {
+		return fmt.Errorf("OutputEffectiveDiscount needs CountTiers, without it discount is already final")
+	}
+	if OutputOutOfRangeCount && OutputBlockRange {
+		return fmt.Errorf("OutputBlockRange would include out of range receipts of OutputOutOfRangeCount")
+	}
+	for u, h := range cfg.EOAProofTxs {
+		if EOAUsersOnly && PenalizeFreshUsers && cfg.AgeProofTxs[u] == h {
+			return fmt.Errorf("user %s uses tx %s for both AgeProofTxs and EOAProofTxs, inputs must be unique", u.Hex(), h.Hex())
+		}
+	}
+	for u, t := range cfg.PriorTiers {
+		if int(t) > len(cfg.Tiers) {
+			return fmt.Errorf("user %s prior tier %d above %d tiers", u.Hex(), t, len(cfg.Tiers))
+		}
+	}
+	if cfg.RebateFeePips > FeePipsDenom {
+		return fmt.Errorf("rebate fee %d pips above %d", cfg.RebateFeePips, FeePipsDenom)
+	}
+	if ReputationBoost && cfg.ReputationRegistry == (common.Address{}) {
+		return fmt.Errorf("ReputationBoost needs ReputationRegistry")
+	}
+	if cfg.ReputationScale != nil && (cfg.ReputationScale.Sign() < 0 || cfg.ReputationScale.BitLen() > 248-ReputationBits) {
+		return fmt.Errorf("reputation scale %s out of range", cfg.ReputationScale)
+	}
+	if NumeraireVolume && cfg.NumeraireOracle == (common.Address{}) {
+		return fmt.Errorf("NumeraireVolume needs NumeraireOracle")
+	}
+	if V3Pools && CheckPoolLiquidity {
+		return fmt.Errorf("CheckPoolLiquidity proves v4 pool state, v3 receipts have none")
+	}
+	if NumeraireVolume && (V3Pools || MultiPool && !CanonicalVolumeToken) {
+		return fmt.Errorf("NumeraireVolume needs all pools to count the same token, MultiPool with CanonicalVolumeToken and no V3Pools")
+	}
+	if RequireOptIn && cfg.OptInRegistry == (common.Address{}) {
+		return fmt.Errorf("RequireOptIn needs OptInRegistry")
+	}
+	if cfg.MinBatchVolume != nil && (cfg.MinBatchVolume.Sign() < 0 || cfg.MinBatchVolume.Cmp(maxUint248) >= 0) {
+		return fmt.Errorf("min batch volume %s out of range", cfg.MinBatchVolume)
+	}
+	if cfg.VolumePrecision != nil && (cfg.VolumePrecision.Sign() < 0 || cfg.VolumePrecision.Cmp(maxUint248) >= 0) {
+		return fmt.Errorf("volume precision %s out of range", cfg.VolumePrecision)
+	}
+	if cfg.HookFlags > AllHookMask {
+		return fmt.Errorf("hook flags %#x outside AllHookMask", cfg.HookFlags)
+	}
+	if CheckHookFlags {
+		flags := cfg.HookFlags
+		if flags == 0 {
+			flags = AfterInitializeFlag | BeforeSwapFlag
+		}
+		hooks := []common.Address{cfg.HookAddr}
+		for _, p := range cfg.ExtraPools {
+			hooks = append(hooks, p.HookAddr)
+		}
+		for _, h := range hooks {
+			if uint16(h.Big().Uint64())&flags != flags {
+				return fmt.Errorf("hook %s lacks flags %#x", h.Hex(), flags)
+			}
+		}
+	}
+	if cfg.AgeCutoffBlock > math.MaxUint32 {
+		return fmt.Errorf("age cutoff block %d exceeds uint32", cfg.AgeCutoffBlock)
+	}
+	for m, w := range cfg.poolWeights() {
+		if w > math.MaxUint32 {
+			return fmt.Errorf("pool %d weight %d exceeds uint32", m, w)
+		}
+	}
+	if cfg.VolumeWeightBps > BpsDenom || cfg.CountWeightBps > BpsDenom {
+		return fmt.Errorf("metric weight bps must be at most %d", BpsDenom)
+	}
+	if cfg.SwapCountScale != nil && (cfg.SwapCountScale.Sign() < 0 || cfg.SwapCountScale.BitLen() > 128) {
+		return fmt.Errorf("swap count scale %s out of range", cfg.SwapCountScale)
+	}
+	if cfg.StreakBonusBps > BpsDenom || cfg.MaxStreakBonusBps > BpsDenom {
+		return fmt.Errorf("streak bonus bps must be at most %d", BpsDenom)
+	}
+	for u, n := range cfg.Streaks {
+		if n > math.MaxUint32 {
+			return fmt.Errorf("streak %d of %s exceeds uint32", n, u.Hex())
+		}
+	}
+	if cfg.ConcentrationBps > BpsDenom || cfg.ConcentrationPenaltyBps > BpsDenom {
+		return fmt.Errorf("concentration bps must be at most %d", BpsDenom)
+	}
+	if cfg.FreshPenaltyBps > BpsDenom {
+		return fmt.Errorf("fresh penalty bps must be at most %d", BpsDenom)
+	}
+	if cfg.MaxRewardShareBps > BpsDenom {
+		return fmt.Errorf("max reward share bps must be at most %d", BpsDenom)
+	}
+	if cfg.Salt != nil && (cfg.Salt.Sign() < 0 || cfg.Salt.BitLen() > 248) {
+		return fmt.Errorf("salt must fit 31 bytes")
+	}
+	return nil
+}
+
+// NewCircuit validates cfg and returns circuit with all inputs set, unused slots are padded
+func (cfg *Config) NewCircuit() (*UniVipHookCircuit, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	c := DefaultUniCircuit()
+	c.Epoch = sdk.ConstUint32(cfg.Epoch)
+	c.EpochLabel = sdk.ConstFromBigEndianBytes(cfg.EpochLabel.Bytes())
+	c.PoolAddr = sdk.ConstUint248(cfg.PoolAddr.Big())
+	c.HookAddr = sdk.ConstUint248(cfg.HookAddr.Big())
+	c.PoolId = sdk.ConstFromBigEndianBytes(cfg.PoolId.Bytes())
+	c.BlockStart = sdk.ConstUint32(uint32(cfg.BlockStart))
+	c.BlockEnd = sdk.ConstUint32(uint32(cfg.BlockEnd))
+	c.StateRefBlock = sdk.ConstUint32(uint32(cfg.stateRefBlock()))
+	// pad unused highest tiers with unreachable min amount, so tier levels match Tiers index
+	for i := range TierNum {
+		if i < len(cfg.Tiers) {
+			c.TierMinAmount[i] = sdk.ConstUint248(cfg.Tiers[i].MinAmount)
+			c.TierDiscount[i] = sdk.ConstUint248(uint64(cfg.Tiers[i].Discount))
+			c.TierInclusive[i] = boolConst(cfg.Tiers[i].Inclusive)
+		} else {
+			c.TierMinAmount[i] = sdk.ConstUint248(maxUint248)
+		}
+	}
+	for j, t := range cfg.CountTiers {
+		c.CountTierMinSwaps[j] = sdk.ConstUint248(uint64(t.MinSwaps))
+		c.CountTierMultiplier[j] = sdk.ConstUint248(uint64(t.MultiplierBps))
+	}
+	for t, tx := range cfg.AllowedTxs {
+		c.AllowedTxBlock[t] = sdk.ConstUint32(tx.BlockNum)
+		c.AllowedTxKey[t] = sdk.ConstUint32(receiptKeyPath(tx.TxIndex))
+	}
+	c.MinOutputTier = sdk.ConstUint248(uint64(cfg.MinOutputTier))
+	c.ShardIndex = sdk.ConstUint248(uint64(cfg.ShardIndex))
+	c.ShardCount = sdk.ConstUint248(uint64(cfg.shardCount()))
+	if cfg.MaxDiscountStep != 0 {
+		c.MaxDiscountStep = sdk.ConstUint248(uint64(cfg.MaxDiscountStep))
+	}
+	if cfg.DiscountDenom != 0 {
+		c.DiscountDenom = sdk.ConstUint248(uint64(cfg.DiscountDenom))
+	}
+	for i, u := range cfg.Users {
+		c.Users[i] = sdk.ConstUint248(u.Big())
+	}
+	for i, u := range cfg.Users {
+		c.EntityIds[i] = sdk.ConstUint248(cfg.Entities[u])
+		c.StreakLength[i] = sdk.ConstUint248(cfg.Streaks[u])
+		c.PriorTier[i] = sdk.ConstUint248(uint64(cfg.PriorTiers[u]))
+		c.ClaimNonce[i] = sdk.ConstUint248(cfg.ClaimNonces[u])
+	}
+	if BlendedMetric {
+		c.VolumeWeightBps = sdk.ConstUint248(cfg.VolumeWeightBps)
+		c.CountWeightBps = sdk.ConstUint248(cfg.CountWeightBps)
+		if cfg.SwapCountScale != nil {
+			c.SwapCountScale = sdk.ConstUint248(cfg.SwapCountScale)
+		}
+	}
+	c.StreakBonusBps = sdk.ConstUint248(cfg.StreakBonusBps)
+	c.MaxStreakBonusBps = sdk.ConstUint248(cfg.MaxStreakBonusBps)
+	for i, a := range cfg.SelfTradeAddrs {
+		c.SelfTradeAddrs[i] = sdk.ConstUint248(a.Big())
+	}
+	if cfg.BatchVolumeCap != nil {
+		c.BatchVolumeCap = sdk.ConstUint248(cfg.BatchVolumeCap)
+	}
+	if cfg.Salt != nil {
+		c.Salt = sdk.ConstUint248(cfg.Salt)
+	}
+	if cfg.MaxRewardShareBps != 0 {
+		c.MaxRewardShareBps = sdk.ConstUint248(cfg.MaxRewardShareBps)
+	}
+	if cfg.ConcentrationBps != 0 {
+		c.ConcentrationBps = sdk.ConstUint248(cfg.ConcentrationBps)
+	}
+	if cfg.ConcentrationPenaltyBps != 0 {
+		c.ConcentrationPenaltyBps = sdk.ConstUint248(cfg.ConcentrationPenaltyBps)
+	}
+	c.LiquiditySlot = sdk.ConstFromBigEndianBytes(LiquiditySlot(cfg.PoolId).Bytes())
+	if cfg.MinLiquidity != nil {
+		c.MinLiquidity = sdk.ConstUint248(cfg.MinLiquidity)
+	}
+	c.HookImpl = sdk.ConstUint248(cfg.HookImpl.Big())
+	for i, p := range cfg.ExtraPools {
+		c.ExtraPoolIds[i] = sdk.ConstFromBigEndianBytes(p.PoolId.Bytes())
+		c.ExtraHookAddrs[i] = sdk.ConstUint248(p.HookAddr.Big())
+	}
+	if PerHookConfig {
+		layouts := []HookLayout{cfg.HookLayout}
+		for _, p := range cfg.ExtraPools {
+			layouts = append(layouts, p.HookLayout)
+		}
+		for m, l := range layouts {
+			c.HookEventIds[m] = l.eventId()
+			c.HookOriginIndex[m] = sdk.ConstUint248(uint64(l.originIndex()))
+		}
+		// padded like Tiers, unused extra pools keep zero tiers as they never match
+		for i, p := range cfg.ExtraPools {
+			for j := range TierNum {
+				if j < len(p.Tiers) {
+					c.ExtraTierMinAmount[i][j] = sdk.ConstUint248(p.Tiers[j].MinAmount)
+					c.ExtraTierDiscount[i][j] = sdk.ConstUint248(uint64(p.Tiers[j].Discount))
+				} else {
+					c.ExtraTierMinAmount[i][j] = sdk.ConstUint248(maxUint248)
+				}
+			}
+		}
+	}
+	if CanonicalVolumeToken {
+		idx, err := cfg.amountIndex(cfg.Currency0, cfg.Currency1)
+		if err != nil {
+			return nil, err
+		}
+		c.PoolAmountIndex[0] = sdk.ConstUint248(idx)
+		for i, p := range cfg.ExtraPools {
+			if idx, err = cfg.amountIndex(p.Currency0, p.Currency1); err != nil {
+				return nil, err
+			}
+			c.PoolAmountIndex[i+1] = sdk.ConstUint248(idx)
+		}
+	}
+	if PoolWeights {
+		for m, w := range cfg.poolWeights() {
+			c.PoolWeightBps[m] = sdk.ConstUint248(w)
+		}
+	}
+	c.MinUsers = sdk.ConstUint248(uint64(cfg.MinUsers))
+	if cfg.MinBatchVolume != nil {
+		c.MinBatchVolume = sdk.ConstUint248(cfg.MinBatchVolume)
+	}
+	c.AgeCutoffBlock = sdk.ConstUint32(uint32(cfg.AgeCutoffBlock))
+	for i, p := range cfg.V3PoolAddrs {
+		c.V3PoolAddrs[i] = sdk.ConstUint248(p.Big())
+	}
+	for k, u := range cfg.RequestedUsers {
+		c.RequestedUsers[k] = sdk.ConstUint248(u.Big())
+	}
+	for i, r := range cfg.Routers {
+		c.Routers[i] = sdk.ConstUint248(r.Big())
+	}
+	if cfg.MaxUserSwaps != 0 {
+		c.MaxUserSwaps = sdk.ConstUint248(uint64(cfg.MaxUserSwaps))
+	}
+	if cfg.MaxSwapAmount != nil {
+		c.MaxSwapAmount = sdk.ConstUint248(cfg.MaxSwapAmount)
+	}
+	c.OptInRegistry = sdk.ConstUint248(cfg.OptInRegistry.Big())
+	c.OptInMappingSlot = sdk.ConstUint248(cfg.OptInMappingSlot)
+	c.NumeraireOracle = sdk.ConstUint248(cfg.NumeraireOracle.Big())
+	c.RebateFeePips = sdk.ConstUint248(uint64(cfg.RebateFeePips))
+	c.EpochSeconds = sdk.ConstUint248(cfg.epochSeconds())
+	c.ReputationRegistry = sdk.ConstUint248(cfg.ReputationRegistry.Big())
+	c.ReputationMappingSlot = sdk.ConstUint248(cfg.ReputationMappingSlot)
+	if cfg.ReputationScale != nil {
+		c.ReputationScale = sdk.ConstUint248(cfg.ReputationScale)
+	}
+	c.NumeraireSlot = sdk.ConstFromBigEndianBytes(cfg.NumeraireSlot.Bytes())
+	c.RequiredTag = sdk.ConstFromBigEndianBytes(cfg.RequiredTag.Bytes())
+	c.TickLower = sdk.ConstInt248(big.NewInt(int64(cfg.TickLower)))
+	c.TickUpper = sdk.ConstInt248(big.NewInt(int64(cfg.TickUpper)))
+	if cfg.MaxSwapContribution != nil {
+		c.MaxSwapContribution = sdk.ConstUint248(cfg.MaxSwapContribution)
+	}
+	if cfg.DustThreshold != nil {
+		c.DustThreshold = sdk.ConstUint248(cfg.DustThreshold)
+	}
+	if cfg.VolumePrecision != nil && cfg.VolumePrecision.Sign() != 0 {
+		c.VolumePrecision = sdk.ConstUint248(cfg.VolumePrecision)
+	}
+	if cfg.HookFlags != 0 {
+		c.HookFlags = sdk.ConstUint248(uint64(cfg.HookFlags))
+	}
+	if cfg.FreshPenaltyBps != 0 {
+		c.FreshPenaltyBps = sdk.ConstUint248(cfg.FreshPenaltyBps)
+	}
+	c.MultiPoolTier = sdk.ConstUint248(uint64(cfg.MultiPoolTier))
+	c.MinPools = sdk.ConstUint248(uint64(cfg.MinPools))
+	for i, w := range cfg.SwapLogWeightBps {
+		if w != 0 {
+			c.SwapLogWeightBps[i] = sdk.ConstUint248(w)
+		}
+	}
+	return c, nil
+}
+
+// BlockRange returns the first and last block receipts may be from, both inclusive, eg. for eth_getLogs
+func (cfg *Config) BlockRange() (first, last uint64) {
+	if HalfOpenBlockRange {
+		return cfg.BlockStart, cfg.BlockEnd - 1
+	}
+	return cfg.BlockStart + 1, cfg.BlockEnd - 1
+}
+
+// inBlockRange returns if block is in BlockRange()
+func (cfg *Config) inBlockRange(block uint64) bool {
+	first, last := cfg.BlockRange()
+	return block >= first && block <= last
+}
+
+// shardCount is ShardCount or its default 1
+func (cfg *Config) shardCount() uint16 {
+	return max(cfg.ShardCount, 1)
+}
+
+// validateShard checks every user is in shard ShardIndex of shardCount
+func (cfg *Config) validateShard() error {
+	n := cfg.shardCount()
+	if cfg.ShardIndex >= n {
+		return fmt.Errorf("shard index %d not below shard count %d", cfg.ShardIndex, n)
+	}
+	for i, u := range cfg.Users {
+		if shard := new(big.Int).Mod(u.Big(), big.NewInt(int64(n))).Uint64(); shard != uint64(cfg.ShardIndex) {
+			return fmt.Errorf("user %d %s is in shard %d, not %d", i, u.Hex(), shard, cfg.ShardIndex)
+		}
+	}
+	return nil
+}
+
+// poolWeights returns PoolWeightBps of PoolId then each extra pool, defaults applied
+func (cfg *Config) poolWeights() []uint64 {
+	ws := []uint64{cfg.PoolWeightBps}
+	for _, p := range cfg.ExtraPools {
+		ws = append(ws, p.WeightBps)
+	}
+	for m := range ws {
+		if ws[m] == 0 {
+			ws[m] = BpsDenom
+		}
+	}
+	return ws
+}
+
+// epochSeconds is EpochSeconds or its default from the block range
+func (cfg *Config) epochSeconds() uint64 {
+	if cfg.EpochSeconds == 0 {
+		return (cfg.BlockEnd - cfg.BlockStart) * SecondsPerBlock
+	}
+	return cfg.EpochSeconds
+}
+
+// stateRefBlock is StateRefBlock or its default BlockEnd
+func (cfg *Config) stateRefBlock() uint64 {
+	if cfg.StateRefBlock == 0 {
+		return cfg.BlockEnd
+	}
+	return cfg.StateRefBlock
+}
+
+func validateBlocks(start, end uint64) error {
+	if end > math.MaxUint32 {
+		return fmt.Errorf("block end %d exceeds uint32", end)
+	}
+	if start >= end {
+		return fmt.Errorf("block start %d must be less than end %d", start, end)
+	}
+	return nil
+}
+
+// validateTier checks t on its own and that it's above prev, prev is nil for the first tier
+func validateTier(prev *TierConfig, t TierConfig) error {
+	if t.MinAmount == nil || t.MinAmount.Sign() < 0 || t.MinAmount.Cmp(maxUint248) >= 0 {
+		return fmt.Errorf("min amount must be non-negative and less than 2^248-1")
+	}
+	if t.Discount > MaxDiscount {
+		return fmt.Errorf("discount %d greater than %d", t.Discount, MaxDiscount)
+	}
+	if t.Inclusive && (!TierInclusive || t.MinAmount.Sign() == 0) {
+		return fmt.Errorf("inclusive tier needs TierInclusive and non-zero min amount")
+	}
+	if prev != nil && t.MinAmount.Cmp(prev.MinAmount) <= 0 {
+		return fmt.Errorf("min amount %s not greater than previous tier %s", t.MinAmount, prev.MinAmount)
+	}
+	return nil
+}
+
+// distinctUsers counts users, a user spanning several slots counts once
+func distinctUsers(users []common.Address) int {
+	n := 0
+	for i, u := range users {
+		if i == 0 || users[i-1] != u {
+			n++
+		}
+	}
+	return n
+}
+
+func validateUsers(users []common.Address) error {
+	if len(users) > MaxUsrNum {
+		return fmt.Errorf("%d users exceeds MaxUsrNum %d", len(users), MaxUsrNum)
+	}
+	seen := make(map[common.Address]bool)
+	for i, u := range users {
+		if u == (common.Address{}) {
+			return fmt.Errorf("user %d is zero address", i)
+		}
+		if seen[u] && RequireDistinctUsers {
+			return fmt.Errorf("user %s at %d has another slot, RequireDistinctUsers allows one", u.Hex(), i)
+		}
+		// a user may span several slots but only adjacent ones
+		if seen[u] && users[i-1] != u {
+			return fmt.Errorf("user %s at %d is not adjacent to its other slots", u.Hex(), i)
+		}
+		seen[u] = true
+	}
+	return nil
+}
+
+// Option is one optional feature constant
+type Option struct {
+	Name string
+	On   bool
+}
+
+// Options returns all option constants in declaration order, new options are appended so flag bits stay stable
+func Options() []Option {
+	return []Option{
+		{"OutputUserIndex", OutputUserIndex},
+		{"ExcludeSelfTrades", ExcludeSelfTrades},
+		{"CapBatchVolume", CapBatchVolume},
+		{"OutputUserCommitment", OutputUserCommitment},
+		{"OutputReceiptCount", OutputReceiptCount},
+		{"PenalizeBlockConcentration", PenalizeBlockConcentration},
+		{"CheckPoolLiquidity", CheckPoolLiquidity},
+		{"OutputDiscountDenom", OutputDiscountDenom},
+		{"MarginalTiers", MarginalTiers},
+		{"FilterMinOutputTier", FilterMinOutputTier},
+		{"CheckHookImpl", CheckHookImpl},
+		{"WeightedSwapLogs", WeightedSwapLogs},
+		{"OutputConfigHash", OutputConfigHash},
+		{"MultiPool", MultiPool},
+		{"GateTierByPools", GateTierByPools},
+		{"CanonicalVolumeToken", CanonicalVolumeToken},
+		{"RequireMinUsers", RequireMinUsers},
+		{"OutputVolumeShare", OutputVolumeShare},
+		{"PenalizeFreshUsers", PenalizeFreshUsers},
+		{"OutputMerkleRoot", OutputMerkleRoot},
+		{"CheckHookFlags", CheckHookFlags},
+		{"AggregateEntities", AggregateEntities},
+		{"OutputOtherVolume", OutputOtherVolume},
+		{"RoundOutputVolume", RoundOutputVolume},
+		{"AssertSegmentLayout", AssertSegmentLayout},
+		{"PerHookConfig", PerHookConfig},
+		{"FilterDustSwaps", FilterDustSwaps},
+		{"OutputNextTierGap", OutputNextTierGap},
+		{"CapSwapContribution", CapSwapContribution},
+		{"OutputTierHistogram", OutputTierHistogram},
+		{"StreakBonus", StreakBonus},
+		{"NetSwapLogs", NetSwapLogs},
+		{"AssertUsersNotProtocol", AssertUsersNotProtocol},
+		{"OutputVolumeScore", OutputVolumeScore},
+		{"DeltaAddresses", DeltaAddresses},
+		{"CapUserSwaps", CapUserSwaps},
+		{"V3Pools", V3Pools},
+		{"OutputClampFlag", OutputClampFlag},
+		{"LiquidityAtStateRef", LiquidityAtStateRef},
+		{"OutputTierTable", OutputTierTable},
+		{"OutputRequestedUsers", OutputRequestedUsers},
+		{"EpochLabel", EpochLabel},
+		{"BlendedMetric", BlendedMetric},
+		{"AssertBlockOrder", AssertBlockOrder},
+		{"PoolWeights", PoolWeights},
+		{"OutputTotalDiscount", OutputTotalDiscount},
+		{"TierInclusive", TierInclusive},
+		{"AssertMaxSwapAmount", AssertMaxSwapAmount},
+		{"GateLowestTier", GateLowestTier},
+		{"HalfOpenBlockRange", HalfOpenBlockRange},
+		{"OutputBlockRange", OutputBlockRange},
+		{"NoHookLog", NoHookLog},
+		{"AssertDiscountSteps", AssertDiscountSteps},
+		{"OutputMatchedVolume", OutputMatchedVolume},
+		{"Sharded", Sharded},
+		{"UnsignedAmounts", UnsignedAmounts},
+		{"RequireOptIn", RequireOptIn},
+		{"OutputOutOfRangeCount", OutputOutOfRangeCount},
+		{"OutputAuditSample", OutputAuditSample},
+		{"NumeraireVolume", NumeraireVolume},
+		{"TickRange", TickRange},
+		{"AssertEpochLength", AssertEpochLength},
+		{"EOAUsersOnly", EOAUsersOnly},
+		{"OutputResultCount", OutputResultCount},
+		{"CountTiers", CountTiers},
+		{"OutputPoolAllowlist", OutputPoolAllowlist},
+		{"OutputQualifiedTiers", OutputQualifiedTiers},
+		{"RequireMinBatchVolume", RequireMinBatchVolume},
+		{"ReputationBoost", ReputationBoost},
+		{"OutputFlowRate", OutputFlowRate},
+		{"CapTierJump", CapTierJump},
+		{"OutputEffectiveDiscount", OutputEffectiveDiscount},
+		{"TxAllowlist", TxAllowlist},
+		{"CapRewardShare", CapRewardShare},
+		{"OutputGasWeightedVolume", OutputGasWeightedVolume},
+		{"RequireDistinctUsers", RequireDistinctUsers},
+		{"OutputClaimHash", OutputClaimHash},
+		{"RequireTag", RequireTag},
+		{"TopTierOnly", TopTierOnly},
+		{"CuratedBatch", CuratedBatch},
+	}
+}
+
+// receiptKeyPath is the receipt trie key of the tx at index in its block, rlp(index), as a big endian uint. index is
+// below 2^24 so it fits a uint32
+func receiptKeyPath(index uint) uint64 {
+	key, _ := rlp.EncodeToBytes(uint64(index))
+	return new(big.Int).SetBytes(key).Uint64()
+}
+
+// validateDeltaUsers checks users are ascending and their deltas fit AddressDeltaBits, padding's all ones excluded
+func validateDeltaUsers(users []common.Address) error {
+	if OutputUserCommitment || FilterMinOutputTier {
+		return fmt.Errorf("DeltaAddresses can't be combined with OutputUserCommitment or FilterMinOutputTier")
+	}
+	for i := 1; i < len(users); i++ {
+		delta := new(big.Int).Sub(users[i].Big(), users[i-1].Big())
+		if delta.Sign() < 0 {
+			return fmt.Errorf("user %d %s below previous user, DeltaAddresses needs ascending users", i, users[i].Hex())
+		}
+		if delta.Cmp(deltaPadding) >= 0 {
+			return fmt.Errorf("user %d delta doesn't fit AddressDeltaBits %d", i, AddressDeltaBits)
+		}
+	}
+	return nil
+}
+
+// DecodeDeltaAddresses reverses DeltaAddresses output, first is the header address and deltas one per slot.
+// padding slots decode to the zero address
+func DecodeDeltaAddresses(first common.Address, deltas []*big.Int) []common.Address {
+	users := make([]common.Address, len(deltas))
+	prev := first.Big()
+	for i, d := range deltas {
+		if d.Cmp(deltaPadding) == 0 {
+			continue
+		}
+		prev = new(big.Int).Add(prev, d)
+		users[i] = common.BigToAddress(prev)
+	}
+	return users
+}
+
+// OptionFlags returns option constants as a bitmask, bit i is the i-th option in declaration order. it's hashed as a
+// uint248, so there's room for 248 options
+func OptionFlags() *big.Int {
+	flags := new(big.Int)
+	for i, o := range Options() {
+		if o.On {
+			flags.SetBit(flags, i, 1)
+		}
+	}
+	return flags
+}
+
+// PoolAllowlist returns the pool ids the circuit accepts, PoolId then ExtraPools, padded with 0 to MaxPoolNum.
+// extra pools over MaxPoolNum-1 fail Validate and are left out
+func (cfg *Config) PoolAllowlist() (ids [MaxPoolNum]common.Hash) {
+	ids[0] = cfg.PoolId
+	for m := 1; m < MaxPoolNum && m <= len(cfg.ExtraPools); m++ {
+		ids[m] = cfg.ExtraPools[m-1].PoolId
+	}
+	return ids
+}
+
+// PoolAllowlistHash is poolAllowlist output of cfg's circuit, keccak256 of PoolAllowlist() packed. the program signs
+// it, or registers it in the contract, as its list of sanctioned pools
+func (cfg *Config) PoolAllowlistHash() common.Hash {
+	var buf []byte
+	for _, id := range cfg.PoolAllowlist() {
+		buf = append(buf, id.Bytes()...)
+	}
+	return crypto.Keccak256Hash(buf)
+}
+
+// ConfigHash recomputes config hash output of cfg's circuit: keccak256 of every input NewCircuit sets, in
+// UniVipHookCircuit field order with unused slots padded, then uint248 OptionFlags(), packed. uint32 inputs are 4
+// bytes, bytes32 32 and the others 31, ticks in two's complement. the per user slots, Users and the values keyed by
+// user, are laid out by Assign from the receipts and aren't hashed
+func (cfg *Config) ConfigHash() (common.Hash, error) {
+	c, err := cfg.NewCircuit()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	buf, err := c.configInputs().constBytes()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return crypto.Keccak256Hash(buf), nil
+}
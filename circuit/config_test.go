@@ -0,0 +1,407 @@
+package circuit
+
+import (
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestValidateUserIsHook(t *testing.T) {
+	requireValidConfig(t)
+	cfg := testConfig()
+	cfg.Users = []common.Address{user(1), cfg.HookAddr}
+	err := cfg.Validate()
+	if AssertUsersNotProtocol && err == nil {
+		t.Fatal("user equal to the hook accepted")
+	}
+	if !AssertUsersNotProtocol && err != nil {
+		t.Fatalf("without AssertUsersNotProtocol: %v", err)
+	}
+}
+
+func TestCircuitRejectsUserIsHook(t *testing.T) {
+	cfg, ch := optionTest(t, "AssertUsersNotProtocol")
+	a, err := cfg.Assign([]Receipt{ch.swap(cfg, 110, user(1), 5_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past Validate, as a prover assigning its own circuit could
+	a.Circuit.Users[1] = sdk.ConstUint248(cfg.HookAddr.Big())
+	rejectInMemory(t, ch, a)
+}
+
+func TestValidatePerHookConfigTierLevels(t *testing.T) {
+	// one of the options on PoolId's tier levels stands for all
+	requireOptions(t, "MultiPool", "PerHookConfig", "FilterMinOutputTier")
+	if err := testConfig().Validate(); err == nil {
+		t.Fatal("tier level option accepted with PerHookConfig")
+	}
+}
+
+func TestValidateV3PoolsLiquidity(t *testing.T) {
+	requireOptions(t, "V3Pools", "CheckPoolLiquidity")
+	if err := testConfig().Validate(); err == nil {
+		t.Fatal("CheckPoolLiquidity accepted with V3Pools")
+	}
+}
+
+func TestValidateBpsOverDenom(t *testing.T) {
+	requireValidConfig(t)
+	for name, set := range map[string]func(*Config){
+		"concentration bps":    func(c *Config) { c.ConcentrationPenaltyBps = BpsDenom + 1 },
+		"fresh penalty bps":    func(c *Config) { c.FreshPenaltyBps = BpsDenom + 1 },
+		"max reward share bps": func(c *Config) { c.MaxRewardShareBps = BpsDenom + 1 },
+	} {
+		cfg := testConfig()
+		set(cfg)
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), name) {
+			t.Errorf("%s over the denom: error %v", name, err)
+		}
+	}
+}
+
+func TestValidateMaxDiscountStep(t *testing.T) {
+	requireValidConfig(t)
+	cfg := testConfig()
+	// steps of testConfig are 100, 200, 200
+	cfg.MaxDiscountStep = 200
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	cfg.MaxDiscountStep = 150
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("jump of 200 accepted with MaxDiscountStep 150")
+	}
+	// a step down is no cliff
+	cfg.Tiers[2].Discount = 50
+	cfg.Tiers[1].Discount = 200
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("step down: %v", err)
+	}
+}
+
+func TestCircuitRejectsDiscountJump(t *testing.T) {
+	cfg, ch := optionTest(t, "AssertDiscountSteps")
+	cfg.MaxDiscountStep = 200
+	a, err := cfg.Assign([]Receipt{ch.swap(cfg, 110, user(1), 5_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.TierDiscount[2] = sdk.ConstUint248(800)
+	rejectInMemory(t, ch, a)
+}
+
+func TestCircuitRejectsSwapOutsideConfiguredPools(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	selfMade := testConfig()
+	selfMade.PoolId = common.HexToHash("0x8c6a1a2f2c2a5c5f1d6ee0f7b1a9e3cb0b5d1d9e6c2f8a3e4b7d0c1a2f3e4d5c")
+	ch := newChain()
+	a, err := cfg.Assign([]Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		// same manager and hook, a pool that's not PoolId or an extra pool. the circuit only knows the configured
+		// pools, whether they're the signed allowlist is the contract's check of poolAllowlist
+		ch.swap(selfMade, 120, user(2), 500_000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestPoolAllowlistHash(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputPoolAllowlist")
+	out := proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 110, user(1), 5_000)})
+	want := cfg.PoolAllowlistHash()
+	if got := decodeHeader(t, out)["poolAllowlist"]; got.Cmp(want.Big()) != 0 {
+		t.Fatalf("poolAllowlist %x, want %x", got, want)
+	}
+	cfg.PoolId = common.HexToHash("0x8c6a1a2f2c2a5c5f1d6ee0f7b1a9e3cb0b5d1d9e6c2f8a3e4b7d0c1a2f3e4d5c")
+	if cfg.PoolAllowlistHash() == want {
+		t.Fatal("allowlist hash of another pool is the same")
+	}
+}
+
+func TestConfigHashStable(t *testing.T) {
+	requireValidConfig(t)
+	want, err := testConfig().ConfigHash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := testConfig().ConfigHash(); got != want {
+		t.Fatalf("hash of the same config changed, %x then %x", want, got)
+	}
+
+	// every circuit input is hashed but the per user slots, including inputs added later
+	base, err := testConfig().NewCircuit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fields := reflect.TypeOf(*base)
+	for i := range fields.NumField() {
+		c := *base
+		changeInput(reflect.ValueOf(&c).Elem().Field(i))
+		buf, err := c.configInputs().constBytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := fields.Field(i).Name
+		if changed := crypto.Keccak256Hash(buf) != want; changed == batchInputs[name] {
+			t.Errorf("changing input %s changes the config hash: %t", name, changed)
+		}
+	}
+
+	// and every config field reaches one, unless the option reading it is off
+	notInputs := map[string]string{
+		"Users": "batch", "Entities": "batch", "PriorTiers": "batch", "ClaimNonces": "batch", "Streaks": "batch",
+		"AgeProofTxs": "txs Assign adds", "EOAProofTxs": "txs Assign adds",
+		"OptInBlocks": "state Simulate reads", "Reputation": "state Simulate reads", "NumerairePrice": "state Simulate reads",
+	}
+	addr := common.HexToAddress("0x00000000000000000000000000000000000f0001")
+	changes := []struct {
+		field  string
+		change func(*Config)
+		hashed bool
+	}{
+		{"Epoch", func(c *Config) { c.Epoch++ }, true},
+		{"EpochLabel", func(c *Config) { c.EpochLabel = common.HexToHash("0x2024") }, true},
+		{"PoolAddr", func(c *Config) { c.PoolAddr = addr }, true},
+		// same hook flag bits
+		{"HookAddr", func(c *Config) { c.HookAddr = common.HexToAddress("0x00000000000000000000000000000000001c0080") }, true},
+		{"PoolId", func(c *Config) { c.PoolId = common.HexToHash("0x01") }, true},
+		{"BlockStart", func(c *Config) { c.BlockStart++ }, true},
+		{"BlockEnd", func(c *Config) { c.BlockEnd++ }, true},
+		{"StateRefBlock", func(c *Config) { c.StateRefBlock = c.BlockStart + 50 }, true},
+		{"Tiers", func(c *Config) { c.Tiers[1].MinAmount = big.NewInt(10_001) }, true},
+		{"Tiers", func(c *Config) { c.Tiers[2].Discount++ }, true},
+		{"Tiers", func(c *Config) { c.Tiers = c.Tiers[:2] }, true},
+		{"CountTiers", func(c *Config) { c.CountTiers = []CountTierConfig{{MinSwaps: 2, MultiplierBps: 15_000}} }, true},
+		{"AllowedTxs", func(c *Config) { c.AllowedTxs = []TxRef{{BlockNum: 110, TxIndex: 1}} }, true},
+		{"DiscountDenom", func(c *Config) { c.DiscountDenom = 1_000 }, true},
+		{"ShardIndex", func(c *Config) { c.ShardIndex, c.ShardCount = 1, 2 }, true},
+		{"ShardCount", func(c *Config) { c.ShardCount = 2 }, true},
+		{"MaxDiscountStep", func(c *Config) { c.MaxDiscountStep = 300 }, true},
+		{"MinOutputTier", func(c *Config) { c.MinOutputTier = 1 }, true},
+		{"SelfTradeAddrs", func(c *Config) { c.SelfTradeAddrs = []common.Address{addr} }, true},
+		{"BatchVolumeCap", func(c *Config) { c.BatchVolumeCap = big.NewInt(1_000_000) }, true},
+		{"Salt", func(c *Config) { c.Salt = big.NewInt(42) }, true},
+		{"ConcentrationBps", func(c *Config) { c.ConcentrationBps = 5_000 }, true},
+		{"ConcentrationPenaltyBps", func(c *Config) { c.ConcentrationPenaltyBps = 5_000 }, true},
+		{"MaxRewardShareBps", func(c *Config) { c.MaxRewardShareBps = 5_000 }, true},
+		{"MinLiquidity", func(c *Config) { c.MinLiquidity = big.NewInt(1_000) }, true},
+		{"HookImpl", func(c *Config) { c.HookImpl = addr }, true},
+		{"SwapLogWeightBps", func(c *Config) { c.SwapLogWeightBps = [2]uint64{5_000, 5_000} }, true},
+		{"ExtraPools", func(c *Config) { c.ExtraPools = []PoolConfig{testExtraPool} }, true},
+		{"MultiPoolTier", func(c *Config) { c.MultiPoolTier = 1 }, true},
+		{"MinPools", func(c *Config) { c.MinPools = 1 }, true},
+		// each moves PoolId's volume token from currency0 to currency1
+		{"VolumeToken", func(c *Config) { c.VolumeToken, c.Currency1 = addr, addr }, CanonicalVolumeToken},
+		{"TokenAliases", func(c *Config) {
+			c.TokenAliases = map[common.Address]common.Address{addr: {}}
+			c.Currency0, c.Currency1 = testHook, addr
+		}, CanonicalVolumeToken},
+		{"Currency0", func(c *Config) { c.Currency0 = addr }, CanonicalVolumeToken},
+		{"Currency1", func(c *Config) { c.Currency0, c.Currency1 = addr, common.Address{} }, CanonicalVolumeToken},
+		{"MinUsers", func(c *Config) { c.MinUsers = 1 }, true},
+		{"AgeCutoffBlock", func(c *Config) { c.AgeCutoffBlock = 150 }, true},
+		{"FreshPenaltyBps", func(c *Config) { c.FreshPenaltyBps = 5_000 }, true},
+		{"HookFlags", func(c *Config) { c.HookFlags = AfterInitializeFlag }, true},
+		{"VolumePrecision", func(c *Config) { c.VolumePrecision = big.NewInt(1_000) }, true},
+		{"HookLayout", func(c *Config) { c.OriginTopicIndex = 2 }, PerHookConfig},
+		{"DustThreshold", func(c *Config) { c.DustThreshold = big.NewInt(10) }, true},
+		{"MaxSwapContribution", func(c *Config) { c.MaxSwapContribution = big.NewInt(1_000_000) }, true},
+		{"MaxSwapAmount", func(c *Config) { c.MaxSwapAmount = big.NewInt(1_000_000_000) }, true},
+		{"MinBatchVolume", func(c *Config) { c.MinBatchVolume = big.NewInt(10) }, true},
+		{"OptInRegistry", func(c *Config) { c.OptInRegistry = addr }, true},
+		{"OptInMappingSlot", func(c *Config) { c.OptInMappingSlot = 3 }, true},
+		{"RequiredTag", func(c *Config) { c.RequiredTag = common.HexToHash("0x7a9") }, true},
+		{"RebateFeePips", func(c *Config) { c.RebateFeePips = 3_000 }, true},
+		{"EpochSeconds", func(c *Config) { c.EpochSeconds = 3_600 }, true},
+		{"ReputationRegistry", func(c *Config) { c.ReputationRegistry = addr }, true},
+		{"ReputationMappingSlot", func(c *Config) { c.ReputationMappingSlot = 3 }, true},
+		{"ReputationScale", func(c *Config) { c.ReputationScale = big.NewInt(10) }, true},
+		{"NumeraireOracle", func(c *Config) { c.NumeraireOracle = addr }, true},
+		{"NumeraireSlot", func(c *Config) { c.NumeraireSlot = common.HexToHash("0x05") }, true},
+		{"TickLower", func(c *Config) { c.TickLower = -60 }, true},
+		{"TickUpper", func(c *Config) { c.TickUpper = 60 }, true},
+		{"MaxUserSwaps", func(c *Config) { c.MaxUserSwaps = 10 }, true},
+		{"V3PoolAddrs", func(c *Config) { c.V3PoolAddrs = []common.Address{addr} }, true},
+		{"Routers", func(c *Config) { c.Routers = []common.Address{addr} }, true},
+		{"StreakBonusBps", func(c *Config) { c.StreakBonusBps = 100 }, true},
+		{"MaxStreakBonusBps", func(c *Config) { c.MaxStreakBonusBps = 500 }, true},
+		{"VolumeWeightBps", func(c *Config) { c.VolumeWeightBps = 5_000 }, BlendedMetric},
+		{"CountWeightBps", func(c *Config) { c.CountWeightBps = 5_000 }, BlendedMetric},
+		{"SwapCountScale", func(c *Config) { c.SwapCountScale = big.NewInt(10) }, BlendedMetric},
+		{"PoolWeightBps", func(c *Config) { c.PoolWeightBps = 5_000 }, PoolWeights},
+		{"RequestedUsers", func(c *Config) { c.RequestedUsers = []common.Address{addr} }, true},
+	}
+	checked := make(map[string]bool)
+	for _, ch := range changes {
+		checked[ch.field] = true
+		cfg := testConfig()
+		ch.change(cfg)
+		got, err := cfg.ConfigHash()
+		if err != nil {
+			// some options only accept a config like testConfig's, the default build checks the rest
+			if OptionFlags().Sign() == 0 {
+				t.Errorf("changing %s: %v", ch.field, err)
+			}
+			continue
+		}
+		if changed := got != want; changed != ch.hashed {
+			t.Errorf("changing %s changes the config hash: %t", ch.field, changed)
+		}
+	}
+	for _, f := range reflect.VisibleFields(reflect.TypeOf(Config{})) {
+		// fields of HookLayout are promoted, HookLayout itself is checked
+		if len(f.Index) == 1 && !checked[f.Name] && notInputs[f.Name] == "" {
+			t.Errorf("config field %s has no change checked against the config hash", f.Name)
+		}
+	}
+
+	if OutputConfigHash {
+		cfg := testConfig()
+		ch := newChain()
+		out := proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 110, user(1), 5_000)})
+		if got := decodeHeader(t, out)["configHash"]; got.Cmp(want.Big()) != 0 {
+			t.Errorf("proven config hash %x, want %x", got, want)
+		}
+	}
+}
+
+// changeInput sets v, an sdk value or the first of an array of them, to a constant no config uses
+func changeInput(v reflect.Value) {
+	for v.Kind() == reflect.Array {
+		v = v.Index(0)
+	}
+	var x any
+	switch v.Interface().(type) {
+	case sdk.Uint32:
+		x = sdk.ConstUint32(123_456_789)
+	case sdk.Uint248:
+		x = sdk.ConstUint248(123_456_789)
+	case sdk.Int248:
+		x = sdk.ConstInt248(big.NewInt(-123_456_789))
+	case sdk.Bytes32:
+		x = sdk.ConstFromBigEndianBytes(crypto.Keccak256([]byte("changed")))
+	}
+	v.Set(reflect.ValueOf(x))
+}
+
+func TestCircuitRejectsHookWithoutFlags(t *testing.T) {
+	cfg, ch := optionTest(t, "CheckHookFlags")
+	// testHook only has the beforeSwap bit, not afterInitialize
+	cfg.HookFlags = 0
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("hook lacking afterInitialize accepted with the default flags")
+	}
+	cfg.HookFlags = BeforeSwapFlag
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	proveInMemory(t, ch, cfg, receipts)
+
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past Validate, as a prover assigning its own circuit could
+	a.Circuit.HookFlags = sdk.ConstUint248(AfterInitializeFlag | BeforeSwapFlag)
+	rejectInMemory(t, ch, a)
+}
+
+func TestInconsistentSegmentLayout(t *testing.T) {
+	cfg := testConfig()
+	// user 1 spills past its segment into a second run
+	cfg.Users = []common.Address{user(1), user(2), user(1)}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("user with two runs of slots accepted")
+	}
+	if !AssertSegmentLayout {
+		return
+	}
+	cfg.Users = nil
+	ch := newChain()
+	a, err := cfg.Assign([]Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 5_000),
+		ch.swap(cfg, 130, user(3), 5_000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past Validate, as a prover assigning its own circuit could
+	a.Circuit.Users[2] = sdk.ConstUint248(user(1).Big())
+	rejectInMemory(t, ch, a)
+}
+
+func TestDeltaAddressesRoundTrip(t *testing.T) {
+	cfg, ch := optionTest(t, "DeltaAddresses")
+	// Assign sorts users, a split user's later slot has delta 0
+	receipts := []Receipt{ch.swap(cfg, 110, user(3), 5_000), ch.swap(cfg, 120, user(1), 5_000), ch.swap(cfg, 130, user(2), 5_000)}
+	for i := range MaxPerUsr {
+		receipts = append(receipts, ch.swap(cfg, 140+uint64(i%50), user(2), 10))
+	}
+	out := proveInMemory(t, ch, cfg, receipts)
+	first := common.BigToAddress(decodeHeader(t, out)["firstAddress"])
+	var deltas []*big.Int
+	for _, row := range decodeRows(t, out, MaxUsrNum) {
+		deltas = append(deltas, row["addressDelta"])
+	}
+	want := make([]common.Address, MaxUsrNum)
+	copy(want, []common.Address{user(1), user(2), user(2), user(3)})
+	if got := DecodeDeltaAddresses(first, deltas); !slices.Equal(got, want) {
+		t.Fatalf("decoded %v, want %v", got, want)
+	}
+}
+
+func TestAdjacentEpochsTile(t *testing.T) {
+	requireOptions(t, "HalfOpenBlockRange")
+	a, b := testConfig(), testConfig()
+	b.BlockStart, b.BlockEnd = a.BlockEnd, a.BlockEnd+100
+	_, lastA := a.BlockRange()
+	firstB, _ := b.BlockRange()
+	if firstB != lastA+1 {
+		t.Fatalf("epoch ends at %d and the next starts at %d", lastA, firstB)
+	}
+	// every block of both is in exactly one
+	for block := a.BlockStart; block < b.BlockEnd; block++ {
+		if a.inBlockRange(block) == b.inBlockRange(block) {
+			t.Errorf("block %d in both or neither epoch", block)
+		}
+	}
+
+	// a swap at the boundary counts in the later epoch only
+	ch := newChain()
+	r := ch.swap(b, a.BlockEnd, user(1), 5_000)
+	proveInMemory(t, ch, b, []Receipt{r})
+	early, err := a.Assign([]Receipt{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, early)
+}
+
+func TestWrongEpochLengthRejected(t *testing.T) {
+	cfg, ch := optionTest(t, "AssertEpochLength")
+	cfg.BlockEnd = cfg.BlockStart + EpochBlocks
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	proveInMemory(t, ch, cfg, receipts)
+
+	short := testConfig()
+	short.BlockEnd = short.BlockStart + EpochBlocks - 1
+	if err := short.Validate(); err == nil {
+		t.Error("epoch a block short accepted")
+	}
+	// a block long, past Validate
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.BlockEnd = sdk.ConstUint32(uint32(cfg.BlockEnd + 1))
+	rejectInMemory(t, ch, a)
+}
@@ -0,0 +1,248 @@
+package circuit
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Client is what FetchBatchInput needs from a node, *ethclient.Client satisfies it
+type Client interface {
+	ethereum.LogFilterer
+	ethereum.TransactionReader
+}
+
+// FetchBatchInput queries client for swaps of cfg's pools with their hook's tx.origin log in cfg.BlockRange(),
+// pairs them into Receipts and returns cfg.Assign of them. sdk.DataInput itself is built by BrevisApp from what
+// Assignment.AddTo adds, so this is the whole way from a config to provable input
+func FetchBatchInput(ctx context.Context, client Client, cfg *Config) (*Assignment, error) {
+	receipts, err := FetchReceipts(ctx, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Assign(receipts)
+}
+
+// FetchReceipts returns one Receipt per tx that swapped in a configured pool, with the first tx.origin log of that
+// pool's hook in the same tx, or the swap's sender with NoHookLog, skipping swaps of Routers. errors if there are
+// more than MaxReceipts
+func FetchReceipts(ctx context.Context, client Client, cfg *Config) ([]Receipt, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if !PoolIdIsTopic {
+		return nil, fmt.Errorf("fetching needs poolid as a Swap topic")
+	}
+	pools := []PoolConfig{{PoolId: cfg.PoolId, HookAddr: cfg.HookAddr, HookLayout: cfg.HookLayout}}
+	if MultiPool {
+		pools = append(pools, cfg.ExtraPools...)
+	}
+	if !PerHookConfig {
+		// same layout receiptData uses
+		for m := range pools {
+			pools[m].HookLayout = HookLayout{}
+		}
+	}
+	first, last := cfg.BlockRange()
+	from, to := new(big.Int).SetUint64(first), new(big.Int).SetUint64(last)
+
+	swapQuery := ethereum.FilterQuery{FromBlock: from, ToBlock: to, Addresses: []common.Address{cfg.PoolAddr}}
+	swapQuery.Topics = make([][]common.Hash, 1)
+	swapQuery.Topics[0] = []common.Hash{common.HexToHash(UniSwapEv)}
+	swapQuery.Topics = append(swapQuery.Topics, make([][]common.Hash, PoolIdFieldIndex)...)
+	for _, p := range pools {
+		swapQuery.Topics[PoolIdFieldIndex] = append(swapQuery.Topics[PoolIdFieldIndex], p.PoolId)
+	}
+	swaps, err := client.FilterLogs(ctx, swapQuery)
+	if err != nil {
+		return nil, fmt.Errorf("filter swap logs: %w", err)
+	}
+	var hookLogs []types.Log
+	if !NoHookLog {
+		hookQuery := ethereum.FilterQuery{FromBlock: from, ToBlock: to, Topics: [][]common.Hash{nil}}
+		for _, p := range pools {
+			hookQuery.Addresses = append(hookQuery.Addresses, p.HookAddr)
+			hookQuery.Topics[0] = append(hookQuery.Topics[0], layoutEvent(p.HookLayout))
+		}
+		if hookLogs, err = client.FilterLogs(ctx, hookQuery); err != nil {
+			return nil, fmt.Errorf("filter hook logs: %w", err)
+		}
+	}
+
+	hooksByTx := make(map[common.Hash][]types.Log)
+	for _, l := range hookLogs {
+		if !l.Removed {
+			hooksByTx[l.TxHash] = append(hooksByTx[l.TxHash], l)
+		}
+	}
+	var receipts []Receipt
+	var txs []common.Hash
+	byTx := make(map[common.Hash]*Receipt)
+	for _, l := range swaps {
+		if l.Removed {
+			continue
+		}
+		if r, ok := byTx[l.TxHash]; ok {
+			// later swaps of a tx only count as its second swap log
+			if WeightedSwapLogs && r.SecondSwapLogPos == nil {
+				pos := l.Index
+				r.SecondSwapLogPos = &pos
+				if r.SecondAmount, err = dataWord(l, AmountDataIndex); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		m := poolOf(pools, l)
+		if m < 0 {
+			continue
+		}
+		hook, user := l, common.Address{}
+		if NoHookLog {
+			if user, err = originOf(l, SwapSenderTopicIndex); err != nil {
+				return nil, err
+			}
+			if slices.Contains(cfg.Routers, user) {
+				// swapped for someone the log doesn't name
+				continue
+			}
+		} else {
+			var ok bool
+			if hook, ok = findHookLog(hooksByTx[l.TxHash], pools[m]); !ok {
+				continue
+			}
+			if user, err = originOf(hook, pools[m].originIndex()); err != nil {
+				return nil, err
+			}
+		}
+		amountIdx, err := cfg.poolAmountIndex(m)
+		if err != nil {
+			return nil, err
+		}
+		amount, err := dataWord(l, amountIdx)
+		if err != nil {
+			return nil, err
+		}
+		// log positions are block wide here, made receipt relative below
+		byTx[l.TxHash] = &Receipt{
+			TxHash: l.TxHash, BlockNum: l.BlockNumber, User: user,
+			HookLogPos: hook.Index, SwapLogPos: l.Index, Pool: m, Amount: amount,
+			SwapContract: l.Address, HookContract: hook.Address, PoolId: l.Topics[PoolIdFieldIndex],
+		}
+		if TickRange {
+			if byTx[l.TxHash].Tick, err = tickOf(l); err != nil {
+				return nil, err
+			}
+		}
+		if OutputGasWeightedVolume {
+			if len(hook.Data) < (HookGasDataIndex+1)*32 {
+				return nil, fmt.Errorf("tx %s: hook log has no gas used", l.TxHash.Hex())
+			}
+			byTx[l.TxHash].Gas = new(big.Int).SetBytes(hook.Data[HookGasDataIndex*32 : (HookGasDataIndex+1)*32])
+		}
+		if RequireTag {
+			if len(hook.Data) < (HookTagDataIndex+1)*32 {
+				return nil, fmt.Errorf("tx %s: hook log has no tag", l.TxHash.Hex())
+			}
+			byTx[l.TxHash].Tag = common.BytesToHash(hook.Data[HookTagDataIndex*32 : (HookTagDataIndex+1)*32])
+		}
+		txs = append(txs, l.TxHash)
+	}
+	if len(txs) > MaxReceipts {
+		return nil, fmt.Errorf("%d swap txs exceeds MaxReceipts %d", len(txs), MaxReceipts)
+	}
+	for _, h := range txs {
+		rc, err := client.TransactionReceipt(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("receipt of %s: %w", h.Hex(), err)
+		}
+		if len(rc.Logs) == 0 {
+			return nil, fmt.Errorf("receipt of %s has no logs", h.Hex())
+		}
+		first := rc.Logs[0].Index
+		r := byTx[h]
+		r.HookLogPos -= first
+		r.SwapLogPos -= first
+		if r.SecondSwapLogPos != nil {
+			*r.SecondSwapLogPos -= first
+		}
+		receipts = append(receipts, *r)
+	}
+	return receipts, nil
+}
+
+// FetchTxRefs looks up the block and index of each tx in hashes, for Config.AllowedTxs
+func FetchTxRefs(ctx context.Context, client Client, hashes []common.Hash) ([]TxRef, error) {
+	refs := make([]TxRef, len(hashes))
+	for i, h := range hashes {
+		rc, err := client.TransactionReceipt(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("receipt of %s: %w", h.Hex(), err)
+		}
+		refs[i] = TxRef{TxHash: h, BlockNum: rc.BlockNumber.Uint64(), TxIndex: rc.TransactionIndex}
+	}
+	return refs, nil
+}
+
+func layoutEvent(l HookLayout) common.Hash {
+	if l.HookEventId == (common.Hash{}) {
+		return common.HexToHash(TxOriginEv)
+	}
+	return l.HookEventId
+}
+
+// poolOf returns index of l's pool in pools, -1 if none
+func poolOf(pools []PoolConfig, l types.Log) int {
+	if len(l.Topics) <= PoolIdFieldIndex {
+		return -1
+	}
+	for m, p := range pools {
+		if l.Topics[PoolIdFieldIndex] == p.PoolId {
+			return m
+		}
+	}
+	return -1
+}
+
+func findHookLog(logs []types.Log, p PoolConfig) (types.Log, bool) {
+	for _, l := range logs {
+		if l.Address == p.HookAddr && len(l.Topics) > 0 && l.Topics[0] == layoutEvent(p.HookLayout) {
+			return l, true
+		}
+	}
+	return types.Log{}, false
+}
+
+// dataWord returns the idx-th 32 byte word of l's data as a two's complement signed int, or unsigned with
+// UnsignedAmounts
+func dataWord(l types.Log, idx uint64) (*big.Int, error) {
+	if uint64(len(l.Data)) < (idx+1)*32 {
+		return nil, fmt.Errorf("tx %s: swap log has no data word %d", l.TxHash.Hex(), idx)
+	}
+	v := new(big.Int).SetBytes(l.Data[idx*32 : (idx+1)*32])
+	if !UnsignedAmounts && v.Bit(255) == 1 {
+		v.Sub(v, new(big.Int).Lsh(big.NewInt(1), 256))
+	}
+	return v, nil
+}
+
+// tickOf returns the int24 tick of swap log l, sign extended to a full word so its low 4 bytes are an int32
+func tickOf(l types.Log) (int32, error) {
+	if len(l.Data) < (TickDataIndex+1)*32 {
+		return 0, fmt.Errorf("tx %s: swap log has no tick", l.TxHash.Hex())
+	}
+	return int32(binary.BigEndian.Uint32(l.Data[(TickDataIndex+1)*32-4 : (TickDataIndex+1)*32])), nil
+}
+
+func originOf(l types.Log, idx uint) (common.Address, error) {
+	if int(idx) >= len(l.Topics) {
+		return common.Address{}, fmt.Errorf("tx %s: hook log has no topic %d", l.TxHash.Hex(), idx)
+	}
+	return common.BytesToAddress(l.Topics[idx].Bytes()), nil
+}
@@ -0,0 +1,148 @@
+package circuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// loadChain returns a chain of the eth_getTransactionReceipt responses in testdata/name, each with the from of its
+// tx as sender. block headers aren't in the fixture, so receipts are moved to the chain's own block hashes
+func loadChain(t *testing.T, name string) *chain {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw []json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	ch := newChain()
+	for _, r := range raw {
+		rc := new(types.Receipt)
+		var tx struct {
+			From common.Address `json:"from"`
+		}
+		if err := json.Unmarshal(r, rc); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(r, &tx); err != nil {
+			t.Fatal(err)
+		}
+		block := rc.BlockNumber.Uint64()
+		rc.BlockHash = blockHash(block)
+		for _, l := range rc.Logs {
+			l.BlockHash = rc.BlockHash
+		}
+		ch.blocks[block] = append(ch.blocks[block], rc.TxHash)
+		ch.receipts[rc.TxHash] = rc
+		ch.senders[rc.TxHash] = tx.From
+	}
+	return ch
+}
+
+func TestNoHookLogPoolBatch(t *testing.T) {
+	cfg, ch := optionTest(t, "NoHookLog")
+	cfg.HookAddr = common.Address{}
+	router := common.HexToAddress("0x66a9893cc07d91d95644aedd05d03f95e1dba8af")
+	cfg.Routers = []common.Address{router}
+	ch.swap(cfg, 110, user(1), 5_000)
+	ch.swap(cfg, 120, user(2), 50_000)
+	// a router swap, for an account the log doesn't name
+	ch.tx(130, user(3), swapLog(cfg.PoolAddr, cfg.PoolId, router, big.NewInt(500_000), 0))
+
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("fetched %d receipts, want 2 without the router's", len(receipts))
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	wantValue(t, rs, user(1), "discount", 100, "user 1")
+	wantValue(t, rs, user(2), "discount", 300, "user 2")
+}
+
+func TestNoHookLogRejectsRouterUser(t *testing.T) {
+	cfg, ch := optionTest(t, "NoHookLog")
+	router := common.HexToAddress("0x66a9893cc07d91d95644aedd05d03f95e1dba8af")
+	cfg.Routers = []common.Address{router}
+	cfg.Users = []common.Address{user(1), router}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("router user accepted")
+	}
+
+	// a prover assigning its own circuit gets past Validate, not the circuit
+	cfg.Users = nil
+	a, err := cfg.Assign([]Receipt{ch.swap(cfg, 110, user(1), 5_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.Users[1] = sdk.ConstUint248(router.Big())
+	rejectInMemory(t, ch, a)
+}
+
+// testdata/fetch_batch.json has two swaps of the configured pool with their hook's log, the first after a token
+// transfer in its tx, and swaps FetchBatchInput must leave out: one of another pool, one without a hook log and
+// one outside the block range
+func TestFetchBatchInputFixture(t *testing.T) {
+	if NoHookLog || RequireTag || OutputGasWeightedVolume {
+		t.Skip("fixture hook logs only carry tx.origin")
+	}
+	requireSimulated(t)
+	requireValidConfig(t)
+	cfg := testConfig()
+	ch := loadChain(t, "fetch_batch.json")
+	a, err := FetchBatchInput(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 {
+		t.Fatalf("fetched %d receipts, want 2", len(receipts))
+	}
+	if r := receipts[0]; r.User != user(1) || r.HookLogPos != 1 || r.SwapLogPos != 2 || r.Amount.Int64() != 5_000 {
+		t.Errorf("first receipt %+v, want user 1 swapping 5000 with logs at 1 and 2 of its receipt", r)
+	}
+	if r := receipts[1]; r.User != user(2) || r.HookLogPos != 0 || r.SwapLogPos != 1 || r.Amount.Int64() != -20_000 {
+		t.Errorf("second receipt %+v, want user 2 swapping -20000 with logs at 0 and 1 of its receipt", r)
+	}
+
+	want, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := proveAssigned(t, ch, a)
+	if !bytes.Equal(out, want) {
+		t.Fatal("proven output differs from Simulate")
+	}
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 100, "user 1")
+	wantValue(t, rs, user(2), "discount", 300, "user 2")
+}
+
+func TestFetchBatchInputMaxReceipts(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	ch := newChain()
+	for i := range MaxReceipts + 1 {
+		ch.swap(cfg, 110+uint64(i%80), user(i%MaxUsrNum), 1_000)
+	}
+	_, err := FetchBatchInput(context.Background(), ch, cfg)
+	if err == nil || !strings.Contains(err.Error(), "MaxReceipts") {
+		t.Fatalf("got %v, want a MaxReceipts error", err)
+	}
+}
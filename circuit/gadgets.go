@@ -0,0 +1,603 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/consensys/gnark/frontend"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// swapReceiptOK returns 1 if r is a swap of poolId in the block range with hook TxOrigin event, see inBlockRange
+func swapReceiptOK(api *sdk.CircuitAPI, r sdk.Receipt, poolAddr, hookAddr sdk.Uint248, poolId sdk.Bytes32, blockStart, blockEnd sdk.Uint32) sdk.Uint248 {
+	return api.Uint248.And(
+		swapLogsOK(api, r, poolAddr, blockStart, blockEnd),
+		hookLogOK(api, r, EventIdHook, sdk.ConstUint248(OriginTopicIndex)),
+		isPool(api, r, poolId, hookAddr),
+		api.Uint248.IsEqual(r.Fields[2].Index, sdk.ConstUint248(AmountDataIndex)),
+	)
+}
+
+// swapLogsOK checks everything in swapReceiptOK except which pool and hook r is from, the hook log and which amount it has
+func swapLogsOK(api *sdk.CircuitAPI, r sdk.Receipt, poolAddr sdk.Uint248, blockStart, blockEnd sdk.Uint32) sdk.Uint248 {
+	// Log index must be ascending order
+	swapLog := r.Fields[1]
+	swapLog2 := r.Fields[2]
+
+	return api.Uint248.And(
+		api.ToUint248(api.Uint32.And(
+			inBlockRange(api, r.BlockNum, blockStart, blockEnd),
+			api.Uint32.IsEqual(swapLog.LogPos, swapLog2.LogPos)),
+		),
+		// swap addr and eventid
+		api.Uint248.IsEqual(swapLog.Contract, poolAddr),
+		api.Uint248.IsEqual(swapLog2.Contract, poolAddr),
+		// swapLog must be poolid field and swapLog2 amount data of the same log, so there is only one
+		// poolid per receipt and a crafted input can't pass another field off as poolid or amount
+		api.Uint248.IsEqual(swapLog.IsTopic, boolConst(PoolIdIsTopic)),
+		api.Uint248.IsEqual(swapLog.Index, sdk.ConstUint248(PoolIdFieldIndex)),
+		api.Uint248.IsZero(swapLog2.IsTopic),
+		// must be same event
+		api.Uint248.IsEqual(swapLog.EventID, swapLog2.EventID),
+		// eventid must equal uniswap
+		api.Uint248.IsEqual(swapLog.EventID, EventIdUniSwap),
+	)
+}
+
+// hookLogOK returns 1 if r's hook log is event eventId and its field is the tx.origin topic at originIndex.
+// with NoHookLog it's instead the sender topic of r's swap log
+func hookLogOK(api *sdk.CircuitAPI, r sdk.Receipt, eventId, originIndex sdk.Uint248) sdk.Uint248 {
+	hookLog := r.Fields[0]
+	if NoHookLog {
+		return api.Uint248.And(
+			api.ToUint248(api.Uint32.IsEqual(hookLog.LogPos, r.Fields[1].LogPos)),
+			api.Uint248.IsEqual(hookLog.EventID, EventIdUniSwap),
+			api.Uint248.IsEqual(hookLog.IsTopic, sdk.ConstUint248(1)),
+			api.Uint248.IsEqual(hookLog.Index, sdk.ConstUint248(SwapSenderTopicIndex)),
+		)
+	}
+	return api.Uint248.And(
+		api.Uint248.IsEqual(hookLog.EventID, eventId),
+		api.Uint248.IsEqual(hookLog.IsTopic, sdk.ConstUint248(1)),
+		api.Uint248.IsEqual(hookLog.Index, originIndex),
+	)
+}
+
+// inBlockRange returns 1 if blockStart < block < blockEnd, or blockStart <= block < blockEnd with HalfOpenBlockRange
+func inBlockRange(api *sdk.CircuitAPI, block, blockStart, blockEnd sdk.Uint32) sdk.Uint32 {
+	afterStart := api.Uint32.IsLessThan(blockStart, block)
+	if HalfOpenBlockRange {
+		afterStart = api.Uint32.Not(api.Uint32.IsLessThan(block, blockStart))
+	}
+	return api.Uint32.And(afterStart, api.Uint32.IsLessThan(block, blockEnd))
+}
+
+// isPool returns 1 if r's swap is in poolId and its TxOrigin is from hookAddr. with NoHookLog hookAddr is unused,
+// Fields[0] must be from the swap's contract
+func isPool(api *sdk.CircuitAPI, r sdk.Receipt, poolId sdk.Bytes32, hookAddr sdk.Uint248) sdk.Uint248 {
+	if NoHookLog {
+		return api.Uint248.And(
+			api.Bytes32.IsEqual(r.Fields[1].Value, poolId),
+			api.Uint248.IsEqual(r.Fields[0].Contract, r.Fields[1].Contract),
+		)
+	}
+	return api.Uint248.And(
+		api.Bytes32.IsEqual(r.Fields[1].Value, poolId),
+		api.Uint248.IsEqual(r.Fields[0].Contract, hookAddr),
+	)
+}
+
+func boolConst(b bool) sdk.Uint248 {
+	if b {
+		return sdk.ConstUint248(1)
+	}
+	return sdk.ConstUint248(0)
+}
+
+// tierDiscount returns discount of the highest tier whose min amount vol is greater than, 0 if none.
+// with MarginalTiers it's the blended marginal discount instead
+func tierDiscount(api *sdk.CircuitAPI, vol sdk.Uint248, minAmount, discount [TierNum]sdk.Uint248) sdk.Uint248 {
+	// no tiers, skip the marginal division
+	if TierNum == 0 {
+		return sdk.ConstUint248(0)
+	}
+	if MarginalTiers {
+		return marginalDiscount(api, vol, minAmount, discount)
+	}
+	disc := sdk.ConstUint248(0)
+	for j := range TierNum {
+		disc = api.Uint248.Select(
+			// if vol > tiermin, set discount to this tier, otherwise, keep discount unchanged
+			api.Uint248.IsGreaterThan(vol, minAmount[j]),
+			discount[j],
+			disc)
+	}
+	return disc
+}
+
+// inclusiveMins returns minAmount lowered by 1 where inclusive is set, so vol > min is vol >= minAmount for those
+// tiers. no-op without TierInclusive
+func inclusiveMins(api *sdk.CircuitAPI, minAmount, inclusive [TierNum]sdk.Uint248) [TierNum]sdk.Uint248 {
+	if !TierInclusive {
+		return minAmount
+	}
+	for j := range TierNum {
+		lowered := api.Uint248.Sub(api.Uint248.Select(api.Uint248.IsZero(minAmount[j]), sdk.ConstUint248(1), minAmount[j]), sdk.ConstUint248(1))
+		minAmount[j] = api.Uint248.Select(inclusive[j], lowered, minAmount[j])
+	}
+	return minAmount
+}
+
+// tierLevel returns number of tiers whose min amount vol is greater than, ie. 1 + index of the reached tier, 0 if none
+func tierLevel(api *sdk.CircuitAPI, vol sdk.Uint248, minAmount [TierNum]sdk.Uint248) sdk.Uint248 {
+	level := sdk.ConstUint248(0)
+	for j := range TierNum {
+		level = api.Uint248.Add(level, api.Uint248.IsGreaterThan(vol, minAmount[j]))
+	}
+	return level
+}
+
+const (
+	// qualifiedTiersBits is the output size of a qualifiedTiers bitmask, TierNum bits rounded up to bytes
+	qualifiedTiersBits = (TierNum + 7) / 8 * 8
+	// outputQualifiedTiers is whether the bitmask is output, with TierNum 0 it would be a 0 bit field
+	outputQualifiedTiers = OutputQualifiedTiers && TierNum > 0
+)
+
+// qualifiedTiers returns a bitmask with bit j set if vol > minAmount[j]. tiers are ascending, so for a valid table
+// it's the low tierLevel bits, padded tiers are never set
+func qualifiedTiers(api *sdk.CircuitAPI, vol sdk.Uint248, minAmount [TierNum]sdk.Uint248) sdk.Uint248 {
+	mask := sdk.ConstUint248(0)
+	for j := range TierNum {
+		bit := api.Uint248.IsGreaterThan(vol, minAmount[j])
+		mask = api.Uint248.Add(mask, api.Uint248.Mul(bit, sdk.ConstUint248(1<<j)))
+	}
+	return mask
+}
+
+// nextTierGap returns min amount of the tier after vol's level minus vol, vol must be greater than the result to reach
+// it. 0 if vol is at the top configured tier, padded tiers have min amount 2^248-1
+func nextTierGap(api *sdk.CircuitAPI, vol sdk.Uint248, minAmount [TierNum]sdk.Uint248) sdk.Uint248 {
+	level := tierLevel(api, vol, minAmount)
+	unreachable := sdk.ConstUint248(maxUint248)
+	next := unreachable
+	for j := range TierNum {
+		next = api.Uint248.Select(api.Uint248.IsEqual(level, sdk.ConstUint248(j)), minAmount[j], next)
+	}
+	// vol is at most next as level is below it
+	return api.Uint248.Select(api.Uint248.IsEqual(next, unreachable), sdk.ConstUint248(0), api.Uint248.Sub(next, vol))
+}
+
+// tierHistogram counts distinct users, by their final slot, at each tier level. counts sum to the number of users
+func tierHistogram(api *sdk.CircuitAPI, users, level [MaxUsrNum]sdk.Uint248) (count [TierNum + 1]sdk.Uint248) {
+	final := finalSlots(api, users)
+	for j := range TierNum + 1 {
+		count[j] = sdk.ConstUint248(0)
+		for i := range MaxUsrNum {
+			at := api.Uint248.And(final[i], api.Uint248.IsEqual(level[i], sdk.ConstUint248(j)))
+			count[j] = api.Uint248.Add(count[j], at)
+		}
+	}
+	return count
+}
+
+// marginalDiscount splits vol into tier bands (minAmount[j], minAmount[j+1]], last band is unbounded,
+// and returns sum(band portion * band discount) / vol, rounded down. volume up to minAmount[0] gets no discount
+func marginalDiscount(api *sdk.CircuitAPI, vol sdk.Uint248, minAmount, discount [TierNum]sdk.Uint248) sdk.Uint248 {
+	weighted := sdk.ConstUint248(0)
+	for j := range TierNum {
+		upper := vol
+		if j+1 < TierNum {
+			upper = api.Uint248.Select(api.Uint248.IsLessThan(vol, minAmount[j+1]), vol, minAmount[j+1])
+		}
+		portion := api.Uint248.Select(
+			api.Uint248.IsGreaterThan(upper, minAmount[j]),
+			api.Uint248.Sub(upper, minAmount[j]),
+			sdk.ConstUint248(0))
+		weighted = api.Uint248.Add(weighted, api.Uint248.Mul(portion, discount[j]))
+	}
+	// avoid div by 0, weighted is 0 anyway if vol is 0
+	denom := api.Uint248.Select(api.Uint248.IsZero(vol), sdk.ConstUint248(1), vol)
+	disc, _ := api.Uint248.Div(weighted, denom)
+	return disc
+}
+
+// roundVolume rounds vol to the nearest multiple of precision, halves round up. precision must be non-zero
+func roundVolume(api *sdk.CircuitAPI, vol, precision sdk.Uint248) sdk.Uint248 {
+	half, _ := api.Uint248.Div(precision, sdk.ConstUint248(2))
+	q, _ := api.Uint248.Div(api.Uint248.Add(vol, half), precision)
+	return api.Uint248.Mul(q, precision)
+}
+
+// deltaPadding is the AddressDeltaBits all ones delta of padding slots
+var deltaPadding = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), AddressDeltaBits), big.NewInt(1))
+
+// Metric returns how much receipt idx (index into in.Receipts.Raw) adds to its user's total
+type Metric func(idx int, r sdk.Receipt) sdk.Uint248
+
+// segmentVolume sums metric over receipts[start:start+count] whose tx.origin is user
+func segmentVolume(api *sdk.CircuitAPI, receipts []sdk.Receipt, start, count int, user sdk.Uint248, metric Metric) sdk.Uint248 {
+	vol := sdk.ConstUint248(0)
+	for j := start; j < start+count; j++ {
+		r := receipts[j]
+		vol = api.Uint248.Select(
+			api.Uint248.IsEqual(receiptUser(api, r), user),
+			api.Uint248.Add(vol, metric(j, r)),
+			vol)
+	}
+	return vol
+}
+
+// segmentBlockRange returns the first and last block of toggled receipts[start:start+count] whose tx.origin is user,
+// 0 for both if there are none
+func segmentBlockRange(api *sdk.CircuitAPI, receipts sdk.DataPoints[sdk.Receipt], start, count int, user sdk.Uint248) (first, last sdk.Uint32) {
+	first, last = sdk.ConstUint32(0), sdk.ConstUint32(0)
+	for j := start; j < start+count; j++ {
+		r := receipts.Raw[j]
+		on := api.ToUint32(api.Uint248.And(sdk.Uint248{Val: receipts.Toggles[j]}, api.Uint248.IsEqual(receiptUser(api, r), user)))
+		earlier := api.Uint32.Or(api.Uint32.IsZero(first), api.Uint32.IsLessThan(r.BlockNum, first))
+		first = api.Uint32.Select(api.Uint32.And(on, earlier), r.BlockNum, first)
+		last = api.Uint32.Select(api.Uint32.And(on, api.Uint32.IsGreaterThan(r.BlockNum, last)), r.BlockNum, last)
+	}
+	return first, last
+}
+
+// segmentMaxBlockVolume returns the max over blocks of user's summed metric in that block, within the segment
+func segmentMaxBlockVolume(api *sdk.CircuitAPI, receipts []sdk.Receipt, start, count int, user sdk.Uint248, metric Metric) sdk.Uint248 {
+	amounts := make([]sdk.Uint248, count)
+	for k := range count {
+		r := receipts[start+k]
+		amounts[k] = api.Uint248.Select(api.Uint248.IsEqual(receiptUser(api, r), user), metric(start+k, r), sdk.ConstUint248(0))
+	}
+	maxVol := sdk.ConstUint248(0)
+	for j := range count {
+		blockVol := sdk.ConstUint248(0)
+		for k := range count {
+			sameBlock := api.ToUint248(api.Uint32.IsEqual(receipts[start+j].BlockNum, receipts[start+k].BlockNum))
+			blockVol = api.Uint248.Select(sameBlock, api.Uint248.Add(blockVol, amounts[k]), blockVol)
+		}
+		maxVol = api.Uint248.Select(api.Uint248.IsGreaterThan(blockVol, maxVol), blockVol, maxVol)
+	}
+	return maxVol
+}
+
+// receiptUser is hookLog value, tx.origin addr
+func receiptUser(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	return api.ToUint248(r.Fields[0].Value)
+}
+
+// swapAmount is abs of swaplog2 value, amount0
+func swapAmount(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	return amountValue(api, r.Fields[2].Value)
+}
+
+// amountValue is abs of a signed amount field, or the field as is with UnsignedAmounts, where a high bit is magnitude
+func amountValue(api *sdk.CircuitAPI, v sdk.Bytes32) sdk.Uint248 {
+	if UnsignedAmounts {
+		return api.ToUint248(v)
+	}
+	return api.Int248.ABS(api.ToInt248(v))
+}
+
+// netAmount returns |a + b| if hasB, otherwise |a|, using only abs values and signs
+func netAmount(api *sdk.CircuitAPI, a, b sdk.Int248, hasB sdk.Uint248) sdk.Uint248 {
+	zero := sdk.ConstInt248(big.NewInt(0))
+	absA := api.Int248.ABS(a)
+	absB := api.Uint248.Select(hasB, api.Int248.ABS(b), sdk.ConstUint248(0))
+	sameSign := api.Uint248.IsEqual(api.Int248.IsLessThan(a, zero), api.Int248.IsLessThan(b, zero))
+	aBigger := api.Uint248.IsGreaterThan(absA, absB)
+	diff := api.Uint248.Select(aBigger, api.Uint248.Sub(absA, absB), api.Uint248.Sub(absB, absA))
+	return api.Uint248.Select(sameSign, api.Uint248.Add(absA, absB), diff)
+}
+
+// finalSlots returns 1 for the last slot of each non-zero user, which holds the user's full total
+func finalSlots(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248) (final [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		final[i] = api.Uint248.Not(api.Uint248.IsZero(users[i]))
+		if i+1 < MaxUsrNum {
+			final[i] = api.Uint248.And(final[i], api.Uint248.Not(api.Uint248.IsEqual(users[i], users[i+1])))
+		}
+	}
+	return final
+}
+
+// assertSegmentLayout asserts no user has two final slots, ie. a user's segments are one adjacent run
+func assertSegmentLayout(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248) {
+	final := finalSlots(api, users)
+	for i := range MaxUsrNum {
+		for j := i + 1; j < MaxUsrNum; j++ {
+			split := api.Uint248.And(final[i], final[j], api.Uint248.IsEqual(users[i], users[j]))
+			api.Uint248.AssertIsEqual(split, sdk.ConstUint248(0))
+		}
+	}
+}
+
+// assertDistinctUsers asserts no two slots have the same user, padding slots of user 0 excluded
+func assertDistinctUsers(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		for j := i + 1; j < MaxUsrNum; j++ {
+			dup := api.Uint248.And(api.Uint248.Not(api.Uint248.IsZero(users[i])), api.Uint248.IsEqual(users[i], users[j]))
+			api.Uint248.AssertIsEqual(dup, sdk.ConstUint248(0))
+		}
+	}
+}
+
+// assertBlockOrder asserts each toggled receipt's block is not before the previous toggled receipt of its segment.
+// LogPos is relative to its receipt, so receipts of one block can't be ordered, AssertInputsAreUnique still rejects
+// a receipt given twice
+func assertBlockOrder(api *sdk.CircuitAPI, receipts sdk.DataPoints[sdk.Receipt]) {
+	for i := range MaxUsrNum {
+		last := sdk.ConstUint32(0)
+		for j := MaxPerUsr * i; j < MaxPerUsr*(i+1); j++ {
+			r, on := receipts.Raw[j], sdk.Uint248{Val: receipts.Toggles[j]}
+			before := api.ToUint248(api.Uint32.IsLessThan(r.BlockNum, last))
+			api.Uint248.AssertIsEqual(api.Uint248.And(on, before), sdk.ConstUint248(0))
+			last = api.Uint32.Select(api.ToUint32(on), r.BlockNum, last)
+		}
+	}
+}
+
+// assertSortedUsers asserts non-zero users are ascending, padding only follows them and deltas fit AddressDeltaBits
+func assertSortedUsers(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248) {
+	maxDelta := sdk.ConstUint248(new(big.Int).Sub(deltaPadding, big.NewInt(1)))
+	for i := range MaxUsrNum {
+		delta, _ := userDelta(api, users, i)
+		api.Uint248.AssertIsLessOrEqual(delta, maxDelta)
+	}
+	for i := 1; i < MaxUsrNum; i++ {
+		pad, prevPad := api.Uint248.IsZero(users[i]), api.Uint248.IsZero(users[i-1])
+		descending := api.Uint248.IsGreaterThan(users[i-1], users[i])
+		bad := api.Uint248.Or(
+			api.Uint248.And(api.Uint248.Not(pad), descending),
+			api.Uint248.And(prevPad, api.Uint248.Not(pad)))
+		api.Uint248.AssertIsEqual(bad, sdk.ConstUint248(0))
+	}
+}
+
+// userDelta returns users[i] - users[i-1], 0 for the first slot, and whether slot i is padding, where delta is 0.
+// users must be sorted, see assertSortedUsers
+func userDelta(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248, i int) (delta, pad sdk.Uint248) {
+	prev := users[max(i-1, 0)]
+	pad = api.Uint248.IsZero(users[i])
+	// subtract prev from itself for padding, so nothing underflows
+	return api.Uint248.Sub(api.Uint248.Select(pad, prev, users[i]), prev), pad
+}
+
+// addressDelta is the output of slot i with DeltaAddresses, userDelta or all ones for padding
+func addressDelta(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248, i int) sdk.Uint248 {
+	delta, pad := userDelta(api, users, i)
+	return api.Uint248.Select(pad, sdk.ConstUint248(deltaPadding), delta)
+}
+
+// propagateBack copies value of a user's last slot to its earlier slots, so all slots of a split user agree
+func propagateBack(api *sdk.CircuitAPI, users, vals [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	for i := MaxUsrNum - 2; i >= 0; i-- {
+		vals[i] = api.Uint248.Select(api.Uint248.IsEqual(users[i], users[i+1]), vals[i+1], vals[i])
+	}
+	return vals
+}
+
+// batchVolume sums vol of final slots, ie. total volume of all users
+func batchVolume(api *sdk.CircuitAPI, users, vol [MaxUsrNum]sdk.Uint248) sdk.Uint248 {
+	final := finalSlots(api, users)
+	total := sdk.ConstUint248(0)
+	for i := range MaxUsrNum {
+		total = api.Uint248.Add(total, api.Uint248.Select(final[i], vol[i], sdk.ConstUint248(0)))
+	}
+	return total
+}
+
+// volumeShare returns vol * BpsDenom / batch volume for each slot rounded down, all 0 if batch volume is 0.
+// shares of final slots sum to BpsDenom minus rounding
+func volumeShare(api *sdk.CircuitAPI, users, vol [MaxUsrNum]sdk.Uint248) (share [MaxUsrNum]sdk.Uint248) {
+	total := batchVolume(api, users, vol)
+	denom := api.Uint248.Select(api.Uint248.IsZero(total), sdk.ConstUint248(1), total)
+	for i := range MaxUsrNum {
+		share[i], _ = api.Uint248.Div(api.Uint248.Mul(vol[i], sdk.ConstUint248(BpsDenom)), denom)
+	}
+	return share
+}
+
+// volumeScore returns vol * MaxVolumeScore / max vol, rounded down, so the top user scores MaxVolumeScore. all 0
+// if no one traded. a split user's earlier slots hold partial totals so they don't raise the max
+func volumeScore(api *sdk.CircuitAPI, vol [MaxUsrNum]sdk.Uint248) (score [MaxUsrNum]sdk.Uint248) {
+	maxVol := sdk.ConstUint248(0)
+	for i := range MaxUsrNum {
+		maxVol = api.Uint248.Select(api.Uint248.IsGreaterThan(vol[i], maxVol), vol[i], maxVol)
+	}
+	denom := api.Uint248.Select(api.Uint248.IsZero(maxVol), sdk.ConstUint248(1), maxVol)
+	for i := range MaxUsrNum {
+		score[i], _ = api.Uint248.Div(api.Uint248.Mul(vol[i], sdk.ConstUint248(MaxVolumeScore)), denom)
+	}
+	return score
+}
+
+// markLowered returns flag with the users whose after volume is below their before volume set
+func markLowered(api *sdk.CircuitAPI, flag, before, after [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	for i := range MaxUsrNum {
+		flag[i] = api.Uint248.Or(flag[i], api.Uint248.IsLessThan(after[i], before[i]))
+	}
+	return flag
+}
+
+// userIndex returns, for each slot, the number of distinct non-zero users with a smaller address,
+// ie. position in the sorted unique address list. slots of the same user share one index, padding gets 0
+func userIndex(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248) (index [MaxUsrNum]sdk.Uint248) {
+	// only first slot of each real user is counted, so split users don't leave gaps
+	first := [MaxUsrNum]sdk.Uint248{}
+	for j := range MaxUsrNum {
+		first[j] = api.Uint248.Not(api.Uint248.IsZero(users[j]))
+		if j > 0 {
+			first[j] = api.Uint248.And(first[j], api.Uint248.Not(api.Uint248.IsEqual(users[j-1], users[j])))
+		}
+	}
+	for i := range MaxUsrNum {
+		index[i] = sdk.ConstUint248(0)
+		for j := range MaxUsrNum {
+			index[i] = api.Uint248.Add(index[i], api.Uint248.And(first[j], api.Uint248.IsLessThan(users[j], users[i])))
+		}
+	}
+	return index
+}
+
+// compact moves vals of kept slots to the front, in slot order, the remaining rows are 0
+func compact(api *sdk.CircuitAPI, keep, vals [MaxUsrNum]sdk.Uint248) (rows [MaxUsrNum]sdk.Uint248) {
+	var rank [MaxUsrNum]sdk.Uint248
+	kept := sdk.ConstUint248(0)
+	for i := range MaxUsrNum {
+		rank[i] = kept
+		kept = api.Uint248.Add(kept, keep[i])
+	}
+	for k := range MaxUsrNum {
+		rows[k] = sdk.ConstUint248(0)
+		// slot i can only move forward
+		for i := k; i < MaxUsrNum; i++ {
+			at := api.Uint248.And(keep[i], api.Uint248.IsEqual(rank[i], sdk.ConstUint248(k)))
+			rows[k] = api.Uint248.Select(at, vals[i], rows[k])
+		}
+	}
+	return rows
+}
+
+// requestedValue returns vals at the last slot of user, which holds its full total, or 0 if user is 0 or not in users
+func requestedValue(api *sdk.CircuitAPI, users [MaxUsrNum]sdk.Uint248, user sdk.Uint248, vals [MaxUsrNum]sdk.Uint248) sdk.Uint248 {
+	v := sdk.ConstUint248(0)
+	for i := range MaxUsrNum {
+		match := api.Uint248.And(api.Uint248.Not(api.Uint248.IsZero(user)), api.Uint248.IsEqual(users[i], user))
+		if i+1 < MaxUsrNum {
+			match = api.Uint248.And(match, api.Uint248.Not(api.Uint248.IsEqual(users[i+1], user)))
+		}
+		v = api.Uint248.Select(match, vals[i], v)
+	}
+	return v
+}
+
+// packed collects values for keccak256 like abi.encodePacked, each value with its bit size
+type packed struct {
+	vals []frontend.Variable
+	bits []int
+}
+
+func (p *packed) uint(v sdk.Uint248, bits int) *packed {
+	p.vals, p.bits = append(p.vals, v.Val), append(p.bits, bits)
+	return p
+}
+
+func (p *packed) uint32(v sdk.Uint32) *packed {
+	p.vals, p.bits = append(p.vals, v.Val), append(p.bits, 32)
+	return p
+}
+
+// bytes32 adds full 32 bytes, Val[1] holds the top byte and Val[0] the low 31 bytes
+func (p *packed) bytes32(v sdk.Bytes32) *packed {
+	p.vals, p.bits = append(p.vals, v.Val[1], v.Val[0]), append(p.bits, 8, 248)
+	return p
+}
+
+func (p *packed) keccak(api *sdk.CircuitAPI) sdk.Bytes32 {
+	return api.Keccak256(p.vals, p.bits)
+}
+
+// value adds v, an sdk value or an array of them. uint32 are 4 bytes, bytes32 32 and the others 31, with int248 in
+// two's complement
+func (p *packed) value(v reflect.Value) *packed {
+	switch x := v.Interface().(type) {
+	case sdk.Uint32:
+		return p.uint32(x)
+	case sdk.Uint248:
+		return p.uint(x, 248)
+	case sdk.Int248:
+		return p.uint(sdk.Uint248{Val: x.Val}, 248)
+	case sdk.Bytes32:
+		return p.bytes32(x)
+	}
+	if v.Kind() != reflect.Array {
+		panic(fmt.Sprintf("can't pack %s", v.Type()))
+	}
+	for i := range v.Len() {
+		p.value(v.Index(i))
+	}
+	return p
+}
+
+// constBytes returns the bytes keccak hashes if every value is a constant, eg. of a circuit from NewCircuit
+func (p *packed) constBytes() ([]byte, error) {
+	var buf []byte
+	for i, v := range p.vals {
+		n, ok := v.(*big.Int)
+		if !ok {
+			return nil, fmt.Errorf("packed value %d is %T, not a constant", i, v)
+		}
+		// mod keeps negative int248 constants in two's complement
+		n = new(big.Int).Mod(n, new(big.Int).Lsh(big.NewInt(1), uint(p.bits[i])))
+		buf = append(buf, common.LeftPadBytes(n.Bytes(), p.bits[i]/8)...)
+	}
+	return buf, nil
+}
+
+// uint256 adds v as a full 32 byte word, like abi.encode of a uint
+func (p *packed) uint256(v sdk.Uint248) *packed {
+	return p.uint(sdk.ConstUint248(0), 8).uint(v, 248)
+}
+
+// bytes32Less returns 1 if a < b as big endian bytes
+func bytes32Less(api *sdk.CircuitAPI, a, b sdk.Bytes32) sdk.Uint248 {
+	aHi, aLo := sdk.Uint248{Val: a.Val[1]}, sdk.Uint248{Val: a.Val[0]}
+	bHi, bLo := sdk.Uint248{Val: b.Val[1]}, sdk.Uint248{Val: b.Val[0]}
+	return api.Uint248.Or(
+		api.Uint248.IsLessThan(aHi, bHi),
+		api.Uint248.And(api.Uint248.IsEqual(aHi, bHi), api.Uint248.IsLessThan(aLo, bLo)))
+}
+
+// merkleRoot hashes leaves pairwise up to the root, each pair sorted like OpenZeppelin MerkleProof.
+// leaves are padded with zero to a power of 2
+func merkleRoot(api *sdk.CircuitAPI, leaves []sdk.Bytes32) sdk.Bytes32 {
+	level := append([]sdk.Bytes32{}, leaves...)
+	for len(level)&(len(level)-1) != 0 {
+		level = append(level, sdk.ConstBytes32(nil))
+	}
+	for len(level) > 1 {
+		next := make([]sdk.Bytes32, len(level)/2)
+		for i := range next {
+			a, b := level[2*i], level[2*i+1]
+			less := bytes32Less(api, a, b)
+			next[i] = new(packed).
+				bytes32(api.Bytes32.Select(less, a, b)).
+				bytes32(api.Bytes32.Select(less, b, a)).
+				keccak(api)
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// userMerkleRoot returns merkle root of one leaf per slot in MerkleLeafEncoding, with slot index, user, discount and
+// volume. only last slot of a user has a leaf so a split user can't claim twice, other slots and padding are zero
+func userMerkleRoot(api *sdk.CircuitAPI, users, discount, volume [MaxUsrNum]sdk.Uint248) sdk.Bytes32 {
+	final := finalSlots(api, users)
+	leaves := make([]sdk.Bytes32, MaxUsrNum)
+	for i := range MaxUsrNum {
+		var leaf sdk.Bytes32
+		switch MerkleLeafEncoding {
+		case LeafIndexAddressAmount:
+			leaf = new(packed).uint256(sdk.ConstUint248(i)).uint(users[i], 160).uint256(discount[i]).keccak(api)
+		case LeafOZStandard:
+			inner := new(packed).uint(sdk.ConstUint248(0), 96).uint(users[i], 160).uint256(discount[i]).keccak(api)
+			leaf = new(packed).bytes32(inner).keccak(api)
+		case LeafAddressDiscountVolume:
+			leaf = new(packed).uint(users[i], 160).uint(discount[i], 16).uint(volume[i], 248).keccak(api)
+		}
+		leaves[i] = api.Bytes32.Select(final[i], leaf, sdk.ConstBytes32(nil))
+	}
+	return merkleRoot(api, leaves)
+}
+
+// userCommitment is keccak256(addr|salt) with 20 bytes addr and 31 bytes salt, zero addr (padding) stays zero
+func userCommitment(api *sdk.CircuitAPI, addr, salt sdk.Uint248) sdk.Bytes32 {
+	return api.Bytes32.Select(
+		api.Uint248.IsZero(addr),
+		sdk.ConstBytes32(nil),
+		new(packed).uint(addr, 160).uint(salt, 248).keccak(api))
+}
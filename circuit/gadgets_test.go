@@ -0,0 +1,59 @@
+package circuit
+
+import (
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/brevis-network/brevis-sdk/test"
+)
+
+// segmentCircuit asserts segmentVolume of one segment of receipts equals the loop Define inlined before it, and Want
+type segmentCircuit struct {
+	User, Want sdk.Uint248
+}
+
+func (c *segmentCircuit) Allocate() (maxReceipts, maxStorage, maxTransactions int) {
+	return MaxPerUsr, 0, 0
+}
+
+func (c *segmentCircuit) Define(api *sdk.CircuitAPI, in sdk.DataInput) error {
+	got := segmentVolume(api, in.Receipts.Raw, 0, MaxPerUsr, c.User, func(_ int, r sdk.Receipt) sdk.Uint248 {
+		return swapAmount(api, r)
+	})
+	inline := sdk.ConstUint248(0)
+	for j := range MaxPerUsr {
+		r := in.Receipts.Raw[j]
+		amount := api.Int248.ABS(api.ToInt248(r.Fields[2].Value)) // swaplog2 value is amount
+		usrAddr := api.ToUint248(r.Fields[0].Value)               // hookLog value is tx.origin addr
+		inline = api.Uint248.Select(api.Uint248.IsEqual(usrAddr, c.User), api.Uint248.Add(inline, amount), inline)
+	}
+	api.Uint248.AssertIsEqual(got, inline)
+	api.Uint248.AssertIsEqual(got, c.Want)
+	api.OutputUint(248, got)
+	return nil
+}
+
+func TestSegmentVolumeMatchesInlineLoop(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	ch := newChain()
+	app := newApp(t, ch)
+	// user 1's swaps both ways, around another user's
+	for i, r := range []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 20_000),
+		ch.swap(cfg, 130, user(1), -700),
+	} {
+		data, err := cfg.receiptData(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.AddReceipt(data, i)
+	}
+	c := &segmentCircuit{User: sdk.ConstUint248(user(1).Big()), Want: sdk.ConstUint248(5_700)}
+	in, err := app.BuildCircuitInput(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.IsSolved(t, c, c, in)
+}
@@ -0,0 +1,80 @@
+//go:build ignore
+
+// gen_options writes the build tag files of each option in uniswap.go's option block, ie. each constant whose
+// value is opt<Name>: opt_<name>.go defines opt<Name> false, and opt_<name>_on.go defines it true when built with
+// -tags <Name>. run it with go generate after adding an option
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+const header = "// Code generated by gen_options.go. DO NOT EDIT.\n\n"
+
+func main() {
+	f, err := parser.ParseFile(token.NewFileSet(), "uniswap.go", nil, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stale, err := filepath.Glob("opt_*.go")
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, name := range stale {
+		if err := os.Remove(name); err != nil {
+			log.Fatal(err)
+		}
+	}
+	for _, decl := range f.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range gen.Specs {
+			vs := spec.(*ast.ValueSpec)
+			if len(vs.Names) != 1 || len(vs.Values) != 1 {
+				continue
+			}
+			name := vs.Names[0].Name
+			if v, ok := vs.Values[0].(*ast.Ident); ok && v.Name == "opt"+name {
+				write(name)
+			}
+		}
+	}
+}
+
+// write writes the off and on files of option name
+func write(name string) {
+	base := "opt_" + snake(name)
+	for _, on := range []bool{false, true} {
+		file, tag := base+".go", "!"+name
+		if on {
+			file, tag = base+"_on.go", name
+		}
+		src := fmt.Sprintf("%s//go:build %s\n\npackage circuit\n\nconst opt%s = %t\n", header, tag, name, on)
+		if err := os.WriteFile(file, []byte(src), 0o644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+// snake is name in snake case, runs of capitals like EOA are one word
+func snake(name string) string {
+	var b strings.Builder
+	r := []rune(name)
+	for i, c := range r {
+		if i > 0 && unicode.IsUpper(c) && (!unicode.IsUpper(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1])) {
+			b.WriteByte('_')
+		}
+		b.WriteRune(unicode.ToLower(c))
+	}
+	return b.String()
+}
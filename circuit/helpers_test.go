@@ -0,0 +1,179 @@
+package circuit
+
+import (
+	"math/big"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	testPoolManager = common.HexToAddress("0x000000000004444c5dc75cb358380d2e3de08a90")
+	testHook        = common.HexToAddress("0x00000000000000000000000000000000000c0080")
+	testPoolId      = common.HexToHash("0x21c67e77068de97969ba93d4aab21826d33ca12bb9f565d8496e8fda8a82ca27")
+	// a second pool of the same manager with its own hook, for MultiPool
+	testExtraPool = PoolConfig{
+		PoolId:   common.HexToHash("0x4f7b5a1e0c2d3b8a9f6e5d4c3b2a19087f6e5d4c3b2a1908f7e6d5c4b3a29180"),
+		HookAddr: common.HexToAddress("0x00000000000000000000000000000000000d0080"),
+	}
+)
+
+// testConfig is a valid config of one pool with three tiers over blocks (100, 200). registries of options that
+// read one are set, with nothing in them
+func testConfig() *Config {
+	cfg := &Config{
+		Epoch:      7,
+		PoolAddr:   testPoolManager,
+		HookAddr:   testHook,
+		PoolId:     testPoolId,
+		BlockStart: 100,
+		BlockEnd:   200,
+		Tiers: []TierConfig{
+			{MinAmount: big.NewInt(1_000), Discount: 100},
+			{MinAmount: big.NewInt(10_000), Discount: 300},
+			{MinAmount: big.NewInt(100_000), Discount: 500},
+		},
+	}
+	// the registries options read need an address
+	if RequireOptIn {
+		cfg.OptInRegistry = common.HexToAddress("0x00000000000000000000000000000000000a0001")
+	}
+	if ReputationBoost {
+		cfg.ReputationRegistry = common.HexToAddress("0x00000000000000000000000000000000000a0002")
+	}
+	if NumeraireVolume {
+		cfg.NumeraireOracle = common.HexToAddress("0x00000000000000000000000000000000000a0003")
+	}
+	if CheckHookFlags {
+		// testHook only has the beforeSwap bit
+		cfg.HookFlags = BeforeSwapFlag
+	}
+	if AssertEpochLength {
+		// still over every test's blocks
+		cfg.BlockEnd = cfg.BlockStart + EpochBlocks
+	}
+	return cfg
+}
+
+// user returns the n-th test user, addresses increase with n
+func user(n int) common.Address {
+	return common.BigToAddress(big.NewInt(int64(0x1000 + n)))
+}
+
+// requireOptions skips t unless the options on are exactly names. options are constants, so a test of them runs in
+// the build with their tags, one of the jobs in .github/workflows/circuit.yml
+func requireOptions(t *testing.T, names ...string) {
+	t.Helper()
+	for _, o := range Options() {
+		if o.On != slices.Contains(names, o.Name) {
+			t.Skipf("needs exactly -tags %s", strings.Join(names, ","))
+		}
+	}
+}
+
+// optionTest is requireOptions, then the test config and an empty chain an option's test starts from
+func optionTest(t *testing.T, names ...string) (*Config, *chain) {
+	t.Helper()
+	requireOptions(t, names...)
+	return testConfig(), newChain()
+}
+
+// requireDefaults skips t if any option is on, for tests of the default circuit's outputs and checks
+func requireDefaults(t *testing.T) {
+	t.Helper()
+	for _, o := range Options() {
+		if o.On {
+			t.Skipf("tests the default circuit, option %s is on", o.Name)
+		}
+	}
+}
+
+// requireValidConfig skips t if testConfig isn't valid with the options on, eg. TxAllowlist needs txs of the test's
+// own chain, for tests that run in every build
+func requireValidConfig(t *testing.T) {
+	t.Helper()
+	if err := testConfig().Validate(); err != nil {
+		t.Skipf("testConfig isn't valid with these options: %v", err)
+	}
+}
+
+// requireSimulated skips t if an option Simulate doesn't mirror is on, for tests comparing against it
+func requireSimulated(t *testing.T) {
+	t.Helper()
+	for _, o := range Options() {
+		if o.On && !slices.Contains(simulated, o.Name) {
+			t.Skipf("option %s is not simulated", o.Name)
+		}
+	}
+}
+
+// decodeResults decodes out with the default layout and its number of user slots
+func decodeResults(t *testing.T, out []byte) []UserResult {
+	t.Helper()
+	slots := MaxUsrNum
+	if OutputRequestedUsers {
+		slots = MaxRequestedUsers
+	}
+	rs, err := DecodeUserResults(out, DefaultOutputLayout(), slots)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rs
+}
+
+// decodeRows returns every per user slot of out by field name, including padding, for layouts without a plain
+// address field
+func decodeRows(t *testing.T, out []byte, slots int) []map[string]*big.Int {
+	t.Helper()
+	l := DefaultOutputLayout()
+	if len(out) != l.Bytes(slots) {
+		t.Fatalf("output is %d bytes, layout needs %d", len(out), l.Bytes(slots))
+	}
+	pos := l.Bytes(0)
+	rows := make([]map[string]*big.Int, slots)
+	for i := range rows {
+		rows[i] = make(map[string]*big.Int)
+		for _, f := range l.PerUser {
+			rows[i][f.Name] = new(big.Int).SetBytes(out[pos : pos+f.Bits/8])
+			pos += f.Bits / 8
+		}
+	}
+	return rows
+}
+
+// decodeHeader returns the header fields of out by their layout name
+func decodeHeader(t *testing.T, out []byte) map[string]*big.Int {
+	t.Helper()
+	header := make(map[string]*big.Int)
+	pos := 0
+	for _, f := range DefaultOutputLayout().Header {
+		if pos+f.Bits/8 > len(out) {
+			t.Fatalf("output of %d bytes has no header field %s", len(out), f.Name)
+		}
+		header[f.Name] = new(big.Int).SetBytes(out[pos : pos+f.Bits/8])
+		pos += f.Bits / 8
+	}
+	return header
+}
+
+// resultOf returns the first result of addr in rs, failing t if there's none
+func resultOf(t *testing.T, rs []UserResult, addr common.Address) UserResult {
+	t.Helper()
+	for _, r := range rs {
+		if r.Address == addr {
+			return r
+		}
+	}
+	t.Fatalf("no result for %s", addr.Hex())
+	return UserResult{}
+}
+
+// wantValue fails t unless addr's result has want as field, who describes the user in the message
+func wantValue(t *testing.T, rs []UserResult, addr common.Address, field string, want uint64, who string) {
+	t.Helper()
+	if got := resultOf(t, rs, addr).Values[field].Uint64(); got != want {
+		t.Errorf("%s: %s %d, want %d", who, field, got, want)
+	}
+}
@@ -0,0 +1,171 @@
+package circuit
+
+import (
+	"fmt"
+	"slices"
+)
+
+// MaxOutputWords is this repo's budget of Output* calls per batch, not an SDK limit. every output is hashed into
+// the public output commitment in circuit and decoded by the contract, so the budget keeps both bounded. raise it
+// after checking constraint count and decoding gas of the larger layout
+const MaxOutputWords = 512
+
+// OutputField is one Output* call, Bits is its size in the output bytes
+type OutputField struct {
+	Name string
+	Bits int
+}
+
+// OutputLayout describes circuit output, Header is emitted once followed by PerUser for every user slot
+type OutputLayout struct {
+	Header  []OutputField
+	PerUser []OutputField
+}
+
+// DefaultOutputLayout returns the layout Define emits with current option constants. with OutputRequestedUsers,
+// PerUser repeats MaxRequestedUsers times instead of MaxUsrNum
+func DefaultOutputLayout() OutputLayout {
+	l := OutputLayout{
+		Header: []OutputField{{"epoch", 32}},
+	}
+	if EpochLabel {
+		l.Header[0].Bits = 256
+	}
+	if Sharded {
+		l.Header = append(l.Header, OutputField{"shardIndex", 16}, OutputField{"shardCount", 16})
+	}
+	if OutputReceiptCount {
+		l.Header = append(l.Header, OutputField{"receiptCount", 32})
+	}
+	if OutputOutOfRangeCount {
+		l.Header = append(l.Header, OutputField{"outOfRangeCount", 32})
+	}
+	if OutputDiscountDenom {
+		l.Header = append(l.Header, OutputField{"discountDenom", 16})
+	}
+	if OutputConfigHash {
+		l.Header = append(l.Header, OutputField{"configHash", 256})
+	}
+	if OutputPoolAllowlist {
+		l.Header = append(l.Header, OutputField{"poolAllowlist", 256})
+	}
+	if OutputTierTable {
+		for j := range TierNum {
+			l.Header = append(l.Header, OutputField{fmt.Sprintf("tier%dMinAmount", j), 248}, OutputField{fmt.Sprintf("tier%dDiscount", j), 16})
+		}
+	}
+	if OutputAuditSample {
+		for k := range AuditSampleNum {
+			l.Header = append(l.Header, OutputField{fmt.Sprintf("sample%dBlock", k), 32},
+				OutputField{fmt.Sprintf("sample%dLogPos", k), 32}, OutputField{fmt.Sprintf("sample%dUser", k), 160})
+		}
+	}
+	if OutputOtherVolume {
+		l.Header = append(l.Header, OutputField{"otherVolume", 248})
+	}
+	if OutputTierHistogram {
+		for j := range TierNum + 1 {
+			l.Header = append(l.Header, OutputField{fmt.Sprintf("tier%dUsers", j), 32})
+		}
+	}
+	if DeltaAddresses {
+		l.Header = append(l.Header, OutputField{"firstAddress", 160})
+	}
+	if OutputTotalDiscount {
+		l.Header = append(l.Header, OutputField{"totalDiscount", 32})
+	}
+	if OutputMerkleRoot {
+		l.Header = append(l.Header, OutputField{"merkleRoot", 256})
+	}
+	if OutputResultCount {
+		l.Header = append(l.Header, OutputField{"resultCount", 32})
+	}
+	if OutputRequestedUsers {
+		l.PerUser = []OutputField{{"address", 160}, {"discount", 16}}
+		return l
+	}
+	if OutputUserCommitment {
+		l.PerUser = append(l.PerUser, OutputField{"commitment", 256})
+	} else if DeltaAddresses {
+		l.PerUser = append(l.PerUser, OutputField{"addressDelta", AddressDeltaBits})
+	} else {
+		l.PerUser = append(l.PerUser, OutputField{"address", 160})
+	}
+	if OutputUserIndex {
+		l.PerUser = append(l.PerUser, OutputField{"index", 32})
+	}
+	l.PerUser = append(l.PerUser, OutputField{"discount", 16})
+	if OutputMerkleRoot && MerkleLeafEncoding == LeafAddressDiscountVolume {
+		l.PerUser = append(l.PerUser, OutputField{"volume", 248})
+	}
+	if OutputVolumeShare {
+		l.PerUser = append(l.PerUser, OutputField{"volumeShareBps", 16})
+	}
+	if OutputNextTierGap {
+		l.PerUser = append(l.PerUser, OutputField{"nextTierGap", 248})
+	}
+	if OutputVolumeScore {
+		l.PerUser = append(l.PerUser, OutputField{"volumeScore", 16})
+	}
+	if OutputClampFlag {
+		l.PerUser = append(l.PerUser, OutputField{"clamped", 8})
+	}
+	if OutputBlockRange {
+		l.PerUser = append(l.PerUser, OutputField{"firstBlock", 32}, OutputField{"lastBlock", 32})
+	}
+	if OutputMatchedVolume {
+		l.PerUser = append(l.PerUser, OutputField{"matchedVolume", 248})
+	}
+	if CountTiers {
+		l.PerUser = append(l.PerUser, OutputField{"multiplierBps", 16})
+	}
+	if outputQualifiedTiers {
+		l.PerUser = append(l.PerUser, OutputField{"qualifiedTiers", qualifiedTiersBits})
+	}
+	if OutputFlowRate {
+		l.PerUser = append(l.PerUser, OutputField{"flowRate", 96})
+	}
+	if OutputEffectiveDiscount {
+		l.PerUser = append(l.PerUser, OutputField{"effectiveDiscount", 16})
+	}
+	if OutputGasWeightedVolume {
+		l.PerUser = append(l.PerUser, OutputField{"gasWeightedVolume", 248})
+	}
+	if OutputClaimHash {
+		l.PerUser = append(l.PerUser, OutputField{"claimHash", 256})
+	}
+	return l
+}
+
+// Words returns total number of output words for numUsers slots
+func (l OutputLayout) Words(numUsers int) int {
+	return len(l.Header) + numUsers*len(l.PerUser)
+}
+
+// Bytes returns total output length in bytes for numUsers slots, ie. what the contract decodes
+func (l OutputLayout) Bytes(numUsers int) int {
+	size := func(fields []OutputField) (n int) {
+		for _, f := range fields {
+			n += f.Bits / 8
+		}
+		return n
+	}
+	return size(l.Header) + numUsers*size(l.PerUser)
+}
+
+// ValidateOutputLayout returns error if layout with numUsers slots has more words than the sdk allows
+func ValidateOutputLayout(layout OutputLayout, numUsers int) error {
+	if numUsers < 0 {
+		return fmt.Errorf("invalid number of users %d", numUsers)
+	}
+	for _, f := range slices.Concat(layout.Header, layout.PerUser) {
+		if f.Bits <= 0 || f.Bits%8 != 0 || f.Bits > 256 {
+			return fmt.Errorf("output field %s has invalid bit size %d", f.Name, f.Bits)
+		}
+	}
+	if words := layout.Words(numUsers); words > MaxOutputWords {
+		return fmt.Errorf("output layout needs %d words (%d header + %d users * %d per user), exceeds max %d",
+			words, len(layout.Header), numUsers, len(layout.PerUser), MaxOutputWords)
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package circuit
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestQualifiedTiersLayout(t *testing.T) {
+	requireOptions(t, "OutputQualifiedTiers")
+	i := slices.IndexFunc(DefaultOutputLayout().PerUser, func(f OutputField) bool { return f.Name == "qualifiedTiers" })
+	if TierNum == 0 {
+		if i >= 0 {
+			t.Fatal("qualifiedTiers output with no tiers")
+		}
+		return
+	}
+	if i < 0 {
+		t.Fatal("no qualifiedTiers field")
+	}
+	// one bit per tier, in whole bytes
+	if bits := DefaultOutputLayout().PerUser[i].Bits; bits%8 != 0 || bits < TierNum || bits >= TierNum+8 {
+		t.Fatalf("qualifiedTiers is %d bits for %d tiers", bits, TierNum)
+	}
+}
+
+func TestValidateOutputLayoutOversized(t *testing.T) {
+	l := OutputLayout{Header: []OutputField{{"epoch", 32}}, PerUser: []OutputField{{"address", 160}, {"discount", 16}}}
+	// 1 header word and 2 per user
+	fits := (MaxOutputWords - 1) / 2
+	if err := ValidateOutputLayout(l, fits); err != nil {
+		t.Fatalf("%d users: %v", fits, err)
+	}
+	if err := ValidateOutputLayout(l, fits+1); err == nil {
+		t.Fatalf("%d users over MaxOutputWords accepted", fits+1)
+	}
+	bad := OutputLayout{PerUser: []OutputField{{"address", 160}, {"odd", 12}}}
+	if err := ValidateOutputLayout(bad, 1); err == nil {
+		t.Fatal("field of 12 bits accepted")
+	}
+}
+
+func TestDefaultOutputLayoutFits(t *testing.T) {
+	slots := MaxUsrNum
+	if OutputRequestedUsers {
+		slots = MaxRequestedUsers
+	}
+	if err := ValidateOutputLayout(DefaultOutputLayout(), slots); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,86 @@
+package circuit
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// MerkleLeaf computes the leaf of slot index for user and discount in MerkleLeafEncoding, same as the circuit.
+// LeafAddressDiscountVolume also needs the user's volume, see MerkleVolumeLeaf
+func MerkleLeaf(index uint64, user common.Address, discount *big.Int) common.Hash {
+	return MerkleVolumeLeaf(index, user, discount, new(big.Int))
+}
+
+// MerkleVolumeLeaf is MerkleLeaf with the user's volume, ie. the volume output in its row with LeafAddressDiscountVolume
+func MerkleVolumeLeaf(index uint64, user common.Address, discount, volume *big.Int) common.Hash {
+	word := func(v *big.Int) []byte { return common.LeftPadBytes(v.Bytes(), 32) }
+	switch MerkleLeafEncoding {
+	case LeafOZStandard:
+		inner := crypto.Keccak256(common.LeftPadBytes(user.Bytes(), 32), word(discount))
+		return crypto.Keccak256Hash(inner)
+	case LeafAddressDiscountVolume:
+		return crypto.Keccak256Hash(user.Bytes(), common.LeftPadBytes(discount.Bytes(), 2), common.LeftPadBytes(volume.Bytes(), 31))
+	default:
+		return crypto.Keccak256Hash(word(new(big.Int).SetUint64(index)), user.Bytes(), word(discount))
+	}
+}
+
+// MerkleTree holds all levels of a sorted pair hash tree, levels[0] are leaves padded to a power of 2
+type MerkleTree struct {
+	levels [][]common.Hash
+}
+
+// NewMerkleTree builds the tree over leaves, zero hash for slots without a leaf
+func NewMerkleTree(leaves []common.Hash) *MerkleTree {
+	level := append([]common.Hash{}, leaves...)
+	for len(level) == 0 || len(level)&(len(level)-1) != 0 {
+		level = append(level, common.Hash{})
+	}
+	t := &MerkleTree{levels: [][]common.Hash{level}}
+	for len(level) > 1 {
+		next := make([]common.Hash, len(level)/2)
+		for i := range next {
+			next[i] = hashPair(level[2*i], level[2*i+1])
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+func (t *MerkleTree) Root() common.Hash {
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns sibling hashes from leaf index up, for MerkleProof.verify
+func (t *MerkleTree) Proof(index int) ([]common.Hash, error) {
+	if index < 0 || index >= len(t.levels[0]) {
+		return nil, fmt.Errorf("leaf index %d out of range", index)
+	}
+	var proof []common.Hash
+	for _, level := range t.levels[:len(t.levels)-1] {
+		proof = append(proof, level[index^1])
+		index /= 2
+	}
+	return proof, nil
+}
+
+// VerifyMerkleProof checks leaf is in root with proof, same as OpenZeppelin MerkleProof.verify
+func VerifyMerkleProof(root, leaf common.Hash, proof []common.Hash) bool {
+	h := leaf
+	for _, p := range proof {
+		h = hashPair(h, p)
+	}
+	return h == root
+}
+
+func hashPair(a, b common.Hash) common.Hash {
+	if bytes.Compare(a.Bytes(), b.Bytes()) > 0 {
+		a, b = b, a
+	}
+	return crypto.Keccak256Hash(a.Bytes(), b.Bytes())
+}
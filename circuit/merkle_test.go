@@ -0,0 +1,96 @@
+package circuit
+
+import (
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestMerkleLeafFormat(t *testing.T) {
+	u := common.HexToAddress("0x1f9840a85d5af5bf1d1762f925bdaddc4201f984")
+	// abi encoded by hand, the way each distributor's claim hashes its arguments
+	var packed string
+	switch MerkleLeafEncoding {
+	case LeafIndexAddressAmount:
+		// MerkleDistributor: keccak256(abi.encodePacked(index, account, amount))
+		packed = strings.Repeat("0", 63) + "5" + "1f9840a85d5af5bf1d1762f925bdaddc4201f984" + strings.Repeat("0", 61) + "12c"
+	case LeafOZStandard:
+		// StandardMerkleTree: keccak256(bytes.concat(keccak256(abi.encode(account, amount))))
+		inner, _ := hex.DecodeString(strings.Repeat("0", 24) + "1f9840a85d5af5bf1d1762f925bdaddc4201f984" + strings.Repeat("0", 61) + "12c")
+		packed = hex.EncodeToString(crypto.Keccak256(inner))
+	default:
+		t.Skip("not a distributor leaf format")
+	}
+	b, err := hex.DecodeString(packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := MerkleLeaf(5, u, big.NewInt(300)), crypto.Keccak256Hash(b); got != want {
+		t.Fatalf("leaf %x, distributor hashes %x", got, want)
+	}
+}
+
+func TestMerkleRootClaims(t *testing.T) {
+	requireOptions(t, "OutputMerkleRoot")
+	if MerkleLeafEncoding == LeafAddressDiscountVolume {
+		t.Skip("leaves need volume, not output with this encoding")
+	}
+	cfg := testConfig()
+	ch := newChain()
+	out := proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 50_000)})
+	root := common.BigToHash(decodeHeader(t, out)["merkleRoot"])
+	leaves := make([]common.Hash, MaxUsrNum)
+	leaves[0] = MerkleLeaf(0, user(1), big.NewInt(100))
+	leaves[1] = MerkleLeaf(1, user(2), big.NewInt(300))
+	tree := NewMerkleTree(leaves)
+	if tree.Root() != root {
+		t.Fatalf("proven root %x, tree of the claims %x", root, tree.Root())
+	}
+	for i, leaf := range leaves[:2] {
+		proof, err := tree.Proof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyMerkleProof(root, leaf, proof) {
+			t.Errorf("claim %d doesn't verify against the proven root", i)
+		}
+	}
+	proof, _ := tree.Proof(0)
+	if VerifyMerkleProof(root, MerkleLeaf(0, user(1), big.NewInt(500)), proof) {
+		t.Error("claim of a higher discount verifies")
+	}
+}
+
+func TestRowLeafVerifies(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputMerkleRoot")
+	out := proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 130, user(3), 500),
+	})
+	root := common.BigToHash(decodeHeader(t, out)["merkleRoot"])
+	// each row is its own leaf's preimage, a volume field is there with LeafAddressDiscountVolume
+	rs := decodeResults(t, out)
+	leaves := make([]common.Hash, MaxUsrNum)
+	for i, r := range rs {
+		volume := r.Values["volume"]
+		if volume == nil {
+			volume = new(big.Int)
+		}
+		leaves[i] = MerkleVolumeLeaf(uint64(i), r.Address, r.Values["discount"], volume)
+	}
+	tree := NewMerkleTree(leaves)
+	for i := range rs {
+		proof, err := tree.Proof(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyMerkleProof(root, leaves[i], proof) {
+			t.Errorf("leaf of row %d doesn't verify against the output root", i)
+		}
+	}
+}
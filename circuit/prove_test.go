@@ -0,0 +1,153 @@
+package circuit
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/brevis-network/brevis-sdk/test"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// newApp returns a BrevisApp querying ch's node
+func newApp(t *testing.T, ch *chain) *sdk.BrevisApp {
+	t.Helper()
+	app, err := sdk.NewBrevisApp(testChainId, ch.serve(t), t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// buildInput builds circuit input of a with a BrevisApp querying ch's node
+func buildInput(t *testing.T, ch *chain, a *Assignment) (sdk.CircuitInput, error) {
+	t.Helper()
+	app := newApp(t, ch)
+	a.AddTo(app)
+	return app.BuildCircuitInput(a.Circuit)
+}
+
+// proveInMemory runs Define on receipts of ch with the SDK's test engine and returns the output bytes, the real
+// circuit's counterpart to Simulate. it fails t if the witness doesn't satisfy the circuit
+func proveInMemory(t *testing.T, ch *chain, cfg *Config, receipts []Receipt) []byte {
+	t.Helper()
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proveAssigned(t, ch, a)
+}
+
+// proveSimulated is proveInMemory that also fails t unless the output is what Simulate computes
+func proveSimulated(t *testing.T, ch *chain, cfg *Config, receipts []Receipt) []byte {
+	t.Helper()
+	out := proveInMemory(t, ch, cfg, receipts)
+	if sim, err := cfg.Simulate(receipts); err != nil || !bytes.Equal(sim, out) {
+		t.Fatalf("proven output differs from Simulate, err %v", err)
+	}
+	return out
+}
+
+// proveAssigned is proveInMemory of an assignment, eg. one altered after Assign
+func proveAssigned(t *testing.T, ch *chain, a *Assignment) []byte {
+	t.Helper()
+	in, err := buildInput(t, ch, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.IsSolved(t, a.Circuit, a.Circuit, in)
+	return in.GetAbiPackedOutput()
+}
+
+// rejectInMemory checks the circuit rejects a: building its input fails, or no proof satisfies Define. a may be
+// altered after Assign, to get past checks Go would do first
+func rejectInMemory(t *testing.T, ch *chain, a *Assignment) {
+	t.Helper()
+	in, err := buildInput(t, ch, a)
+	if err != nil {
+		t.Logf("rejected building input: %v", err)
+		return
+	}
+	test.ProverFailed(t, a.Circuit, a.Circuit, in)
+}
+
+func TestProveInMemoryMatchesSimulate(t *testing.T) {
+	requireSimulated(t)
+	requireValidConfig(t)
+	cfg := testConfig()
+	ch := newChain()
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 600),
+		ch.swap(cfg, 120, user(2), 20_000),
+		ch.swap(cfg, 130, user(1), -700),
+		ch.swap(cfg, 140, user(3), 50),
+	}
+	want, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := proveInMemory(t, ch, cfg, receipts)
+	if !bytes.Equal(got, want) {
+		t.Fatalf("proven output differs from Simulate\nproven:    %x\nsimulated: %x", got, want)
+	}
+}
+
+// phaseCircuit runs one of Define's two phases, to check them apart
+type phaseCircuit struct {
+	UniVipHookCircuit
+	assertOnly bool
+}
+
+func (c *phaseCircuit) Define(api *sdk.CircuitAPI, in sdk.DataInput) error {
+	receipts := sdk.NewDataStream(api, in.Receipts)
+	if c.assertOnly {
+		c.assertInputs(api, in, receipts)
+		return nil
+	}
+	return c.output(api, in, receipts)
+}
+
+func TestDefineIsTwoPhase(t *testing.T) {
+	requireSimulated(t)
+	if RequireMinBatchVolume {
+		t.Skip("output asserts the batch volume it sums")
+	}
+	requireValidConfig(t)
+	cfg := testConfig()
+	ch := newChain()
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 50_000)}
+	want, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := proveInMemory(t, ch, cfg, receipts); !bytes.Equal(got, want) {
+		t.Fatal("proven output differs from Simulate")
+	}
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outputOnly := &phaseCircuit{UniVipHookCircuit: *a.Circuit}
+	in, err := buildInput(t, ch, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.IsSolved(t, outputOnly, outputOnly, in)
+	if !bytes.Equal(in.GetAbiPackedOutput(), want) {
+		t.Fatal("output phase alone differs from Define")
+	}
+
+	// a swap in a pool cfg doesn't list only fails the assert phase
+	selfMade := testConfig()
+	selfMade.PoolId = common.HexToHash("0x8c6a1a2f2c2a5c5f1d6ee0f7b1a9e3cb0b5d1d9e6c2f8a3e4b7d0c1a2f3e4d5c")
+	if a, err = cfg.Assign(append(receipts, ch.swap(selfMade, 130, user(3), 5_000))); err != nil {
+		t.Fatal(err)
+	}
+	if in, err = buildInput(t, ch, a); err != nil {
+		t.Fatal(err)
+	}
+	assertOnly := &phaseCircuit{UniVipHookCircuit: *a.Circuit, assertOnly: true}
+	test.ProverFailed(t, assertOnly, assertOnly, in)
+	outputOnly = &phaseCircuit{UniVipHookCircuit: *a.Circuit}
+	test.IsSolved(t, outputOnly, outputOnly, in)
+}
@@ -0,0 +1,100 @@
+package circuit
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// UserResult is one user slot of decoded output, Values has each PerUser field except address by its layout name
+type UserResult struct {
+	Address common.Address
+	Values  map[string]*big.Int
+}
+
+// Mismatch is one difference found by DiffResultsByAddress. Field is empty if the user is missing on one side, whose
+// value is then nil
+type Mismatch struct {
+	Address          common.Address
+	Field            string
+	Expected, Actual *big.Int
+}
+
+// DecodeUserResults returns the per user slots of out, laid out like l with numUsers slots. slots with address 0
+// are padding and skipped. PerUser must have a plain address field, not a commitment or delta
+func DecodeUserResults(out []byte, l OutputLayout, numUsers int) ([]UserResult, error) {
+	if len(out) != l.Bytes(numUsers) {
+		return nil, fmt.Errorf("output is %d bytes, layout needs %d", len(out), l.Bytes(numUsers))
+	}
+	if !slices.ContainsFunc(l.PerUser, func(f OutputField) bool { return f.Name == "address" }) {
+		return nil, fmt.Errorf("per user layout has no address field")
+	}
+	pos := l.Bytes(0)
+	var results []UserResult
+	for range numUsers {
+		r := UserResult{Values: make(map[string]*big.Int)}
+		for _, f := range l.PerUser {
+			word := out[pos : pos+f.Bits/8]
+			pos += f.Bits / 8
+			if f.Name == "address" {
+				r.Address = common.BytesToAddress(word)
+				continue
+			}
+			r.Values[f.Name] = new(big.Int).SetBytes(word)
+		}
+		if r.Address != (common.Address{}) {
+			results = append(results, r)
+		}
+	}
+	return results, nil
+}
+
+// DiffResultsByAddress compares expected and actual results matched by address, so their order doesn't matter.
+// a user output more than once is matched in order of its occurrences. mismatches follow expected's order, then
+// users only in actual
+func DiffResultsByAddress(expected, actual []UserResult) []Mismatch {
+	byAddr := make(map[common.Address][]UserResult)
+	for _, r := range actual {
+		byAddr[r.Address] = append(byAddr[r.Address], r)
+	}
+	var diff []Mismatch
+	for _, e := range expected {
+		if len(byAddr[e.Address]) == 0 {
+			diff = append(diff, Mismatch{Address: e.Address})
+			continue
+		}
+		a := byAddr[e.Address][0]
+		byAddr[e.Address] = byAddr[e.Address][1:]
+		diff = append(diff, diffValues(e, a)...)
+	}
+	for _, r := range actual {
+		if len(byAddr[r.Address]) > 0 {
+			byAddr[r.Address] = byAddr[r.Address][1:]
+			diff = append(diff, Mismatch{Address: r.Address})
+		}
+	}
+	return diff
+}
+
+// diffValues returns fields of e and a, sorted by name, whose values differ or are missing on one side
+func diffValues(e, a UserResult) (diff []Mismatch) {
+	var names []string
+	for name := range e.Values {
+		names = append(names, name)
+	}
+	for name := range a.Values {
+		if _, ok := e.Values[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	for _, name := range names {
+		ev, av := e.Values[name], a.Values[name]
+		if ev == nil || av == nil || ev.Cmp(av) != 0 {
+			diff = append(diff, Mismatch{Address: e.Address, Field: name, Expected: ev, Actual: av})
+		}
+	}
+	return diff
+}
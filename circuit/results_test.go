@@ -0,0 +1,28 @@
+package circuit
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestDiffResultsReordered(t *testing.T) {
+	result := func(n int, discount int64) UserResult {
+		return UserResult{Address: user(n), Values: map[string]*big.Int{"discount": big.NewInt(discount), "index": big.NewInt(int64(n))}}
+	}
+	expected := []UserResult{result(1, 100), result(2, 300), result(3, 0)}
+	actual := []UserResult{result(3, 0), result(1, 100), result(2, 300)}
+	if diff := DiffResultsByAddress(expected, actual); len(diff) != 0 {
+		t.Fatalf("reordered equal results differ: %+v", diff)
+	}
+
+	actual = []UserResult{result(2, 500), result(4, 100), result(1, 100)}
+	want := []Mismatch{
+		{Address: user(2), Field: "discount", Expected: big.NewInt(300), Actual: big.NewInt(500)},
+		{Address: user(3)},
+		{Address: user(4)},
+	}
+	if diff := DiffResultsByAddress(expected, actual); !reflect.DeepEqual(diff, want) {
+		t.Fatalf("diff %+v, want %+v", diff, want)
+	}
+}
@@ -0,0 +1,477 @@
+package circuit
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// simulated are options Simulate mirrors, the others change outputs in ways it doesn't compute
+var simulated = []string{
+	"OutputUserIndex", "ExcludeSelfTrades", "OutputReceiptCount", "OutputDiscountDenom", "MarginalTiers",
+	"FilterMinOutputTier", "CapSwapContribution", "OutputNextTierGap", "OutputTierTable", "EpochLabel",
+	"BlendedMetric", "PoolWeights", "OutputTotalDiscount", "TierInclusive", "OutputBlockRange",
+	"OutputMatchedVolume", "Sharded", "UnsignedAmounts", "RequireOptIn",
+	"OutputOutOfRangeCount", "OutputAuditSample", "NumeraireVolume", "TickRange",
+	"EOAUsersOnly", "OutputPoolAllowlist", "OutputQualifiedTiers", "RequireMinBatchVolume",
+	"ReputationBoost", "OutputFlowRate", "CapTierJump", "TxAllowlist",
+	"CapRewardShare", "OutputGasWeightedVolume", "OutputClaimHash",
+	"RequireTag", "WeightedSwapLogs", "NetSwapLogs",
+	// only assert, Validate checks the same
+	"RequireMinUsers", "CheckHookFlags", "AssertSegmentLayout", "AssertUsersNotProtocol",
+	"CapUserSwaps", "AssertBlockOrder", "AssertMaxSwapAmount", "AssertDiscountSteps",
+	"AssertEpochLength", "RequireDistinctUsers",
+	// only sizes the circuit
+	"CuratedBatch",
+}
+
+// Simulate computes in Go the output bytes Define emits for receipts laid out like Assign, with each
+// receipt's Amount set. It needs no node or prover, and tests check the circuit's output against it.
+// Receipts are assumed to pass the circuit's checks
+func (cfg *Config) Simulate(receipts []Receipt) ([]byte, error) {
+	for _, o := range Options() {
+		if o.On && !slices.Contains(simulated, o.Name) {
+			return nil, fmt.Errorf("option %s is not simulated", o.Name)
+		}
+	}
+	laid, pos, err := cfg.layout(receipts)
+	if err != nil {
+		return nil, err
+	}
+	if err := laid.Validate(); err != nil {
+		return nil, err
+	}
+	var users [MaxUsrNum]common.Address
+	copy(users[:], laid.Users)
+
+	minAmount, tierMin, tierDisc := simTiers(cfg.Tiers)
+
+	var vol [MaxUsrNum]*big.Int
+	var bought, sold, gasVol [MaxUsrNum]*big.Int
+	var first, last [MaxUsrNum]uint64
+	for i := range MaxUsrNum {
+		vol[i], bought[i], sold[i], gasVol[i] = new(big.Int), new(big.Int), new(big.Int), new(big.Int)
+	}
+	for idx, r := range pos {
+		if r.Amount == nil {
+			return nil, fmt.Errorf("tx %s: no amount", r.TxHash.Hex())
+		}
+		if own := cfg.simReceiptAmount(r); AssertMaxSwapAmount && cfg.MaxSwapAmount != nil && own.Cmp(cfg.MaxSwapAmount) > 0 {
+			return nil, fmt.Errorf("tx %s: amount %s above MaxSwapAmount %s", r.TxHash.Hex(), own, cfg.MaxSwapAmount)
+		}
+		if i := idx / MaxPerUsr; r.User == users[i] {
+			amount := cfg.simAmount(r)
+			vol[i].Add(vol[i], amount)
+			if r.Gas != nil && r.Gas.BitLen() <= GasBits {
+				gasVol[i].Add(gasVol[i], new(big.Int).Mul(amount, r.Gas))
+			}
+			if r.Amount.Sign() < 0 {
+				sold[i].Add(sold[i], amount)
+			} else {
+				bought[i].Add(bought[i], amount)
+			}
+			if first[i] == 0 || r.BlockNum < first[i] {
+				first[i] = r.BlockNum
+			}
+			last[i] = max(last[i], r.BlockNum)
+		}
+	}
+	for i := 1; i < MaxUsrNum; i++ {
+		if users[i] == users[i-1] {
+			vol[i].Add(vol[i], vol[i-1])
+			bought[i].Add(bought[i], bought[i-1])
+			sold[i].Add(sold[i], sold[i-1])
+			gasVol[i].Add(gasVol[i], gasVol[i-1])
+			if first[i-1] != 0 && (first[i] == 0 || first[i-1] < first[i]) {
+				first[i] = first[i-1]
+			}
+			last[i] = max(last[i], last[i-1])
+		}
+	}
+
+	if RequireMinBatchVolume {
+		batch := new(big.Int)
+		for i := range MaxUsrNum {
+			if users[i] != (common.Address{}) && (i+1 == MaxUsrNum || users[i+1] != users[i]) {
+				batch.Add(batch, vol[i])
+			}
+		}
+		min := cfg.MinBatchVolume
+		if min == nil {
+			min = new(big.Int)
+		}
+		if batch.Cmp(min) <= 0 {
+			return nil, fmt.Errorf("batch volume %s not above MinBatchVolume", batch)
+		}
+	}
+
+	// vol becomes tier volume below, flow rates are on counted volume
+	var counted [MaxUsrNum]*big.Int
+	for i := range MaxUsrNum {
+		counted[i] = new(big.Int).Set(vol[i])
+	}
+	if ReputationBoost && cfg.ReputationScale != nil {
+		for i, u := range users {
+			if score := new(big.Int).SetUint64(cfg.Reputation[u]); u != (common.Address{}) && score.BitLen() <= ReputationBits {
+				vol[i].Add(vol[i], score.Mul(score, cfg.ReputationScale))
+			}
+		}
+	}
+
+	if CapTierJump {
+		for i, u := range users {
+			if next := int(cfg.PriorTiers[u]) + 1; next < TierNum && vol[i].Cmp(minAmount[next]) > 0 {
+				vol[i].Set(minAmount[next])
+			}
+		}
+	}
+
+	var outUser [MaxUsrNum]common.Address
+	var disc [MaxUsrNum]*big.Int
+	for i := range MaxUsrNum {
+		outUser[i], disc[i] = users[i], simDiscount(vol[i], minAmount, tierDisc)
+		if FilterMinOutputTier && simLevel(vol[i], minAmount) < int(cfg.MinOutputTier) {
+			outUser[i], disc[i] = common.Address{}, new(big.Int)
+		}
+	}
+	if CapRewardShare {
+		disc = cfg.simCapRewardShare(users, counted, disc)
+	}
+	total := new(big.Int)
+	for i := range MaxUsrNum {
+		if users[i] != (common.Address{}) && (i+1 == MaxUsrNum || users[i+1] != users[i]) {
+			total.Add(total, disc[i])
+		}
+	}
+
+	out := &outputs{}
+	out.add("epoch", big.NewInt(int64(cfg.Epoch)))
+	if EpochLabel {
+		out.add("epoch", cfg.EpochLabel.Big())
+	}
+	out.add("shardIndex", big.NewInt(int64(cfg.ShardIndex)))
+	out.add("shardCount", big.NewInt(int64(cfg.shardCount())))
+	outOfRange := 0
+	for _, r := range pos {
+		if !cfg.inBlockRange(r.BlockNum) {
+			outOfRange++
+		}
+	}
+	out.add("receiptCount", big.NewInt(int64(len(pos)-outOfRange)))
+	out.add("outOfRangeCount", big.NewInt(int64(outOfRange)))
+	for k, r := range cfg.AuditSample(pos) {
+		out.add(fmt.Sprintf("sample%dBlock", k), new(big.Int).SetUint64(r.BlockNum))
+		out.add(fmt.Sprintf("sample%dLogPos", k), new(big.Int).SetUint64(uint64(r.SwapLogPos)))
+		out.add(fmt.Sprintf("sample%dUser", k), r.User.Big())
+	}
+	denom := cfg.DiscountDenom
+	if denom == 0 {
+		denom = MaxDiscount
+	}
+	out.add("discountDenom", big.NewInt(int64(denom)))
+	out.add("poolAllowlist", cfg.PoolAllowlistHash().Big())
+	for j := range TierNum {
+		out.add(fmt.Sprintf("tier%dMinAmount", j), tierMin[j])
+		out.add(fmt.Sprintf("tier%dDiscount", j), tierDisc[j])
+	}
+	out.add("totalDiscount", total)
+	layout := DefaultOutputLayout()
+	buf, err := out.pack(layout.Header)
+	if err != nil {
+		return nil, err
+	}
+	for i := range MaxUsrNum {
+		out = &outputs{}
+		out.add("address", outUser[i].Big())
+		out.add("index", big.NewInt(int64(simIndex(users, i))))
+		out.add("discount", disc[i])
+		out.add("nextTierGap", simGap(vol[i], minAmount))
+		qualified := new(big.Int)
+		for j := range simLevel(vol[i], minAmount) {
+			qualified.SetBit(qualified, j, 1)
+		}
+		out.add("qualifiedTiers", qualified)
+		out.add("firstBlock", new(big.Int).SetUint64(first[i]))
+		out.add("lastBlock", new(big.Int).SetUint64(last[i]))
+		flow := new(big.Int).Mul(counted[i], big.NewInt(int64(cfg.RebateFeePips)))
+		flow.Mul(flow, disc[i])
+		flowDenom := new(big.Int).Mul(big.NewInt(FeePipsDenom*int64(denom)), new(big.Int).SetUint64(cfg.epochSeconds()))
+		out.add("flowRate", flow.Div(flow, flowDenom))
+		out.add("matchedVolume", new(big.Int).Set(bought[i]))
+		if sold[i].Cmp(bought[i]) < 0 {
+			out.add("matchedVolume", sold[i])
+		}
+		out.add("gasWeightedVolume", gasVol[i])
+		out.add("claimHash", new(big.Int))
+		if outUser[i] != (common.Address{}) {
+			claim := ClaimHash(outUser[i], uint16(disc[i].Uint64()), cfg.Epoch, cfg.ClaimNonces[users[i]])
+			if EpochLabel {
+				claim = LabeledClaimHash(outUser[i], uint16(disc[i].Uint64()), cfg.EpochLabel, cfg.ClaimNonces[users[i]])
+			}
+			out.add("claimHash", claim.Big())
+		}
+		b, err := out.pack(layout.PerUser)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf, nil
+}
+
+// TieringEquivalent returns true if tiers a and b give the same discount to every sample volume, eg. to check a
+// migrated tier table. both must be valid tiers of a Config, discounts are compared as is so both need the same
+// DiscountDenom
+func TieringEquivalent(a, b []TierConfig, sampleVolumes []*big.Int) bool {
+	minA, _, discA := simTiers(a)
+	minB, _, discB := simTiers(b)
+	for _, vol := range sampleVolumes {
+		if simDiscount(vol, minA, discA).Cmp(simDiscount(vol, minB, discB)) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// simTiers pads tiers like NewCircuit. minAmount is compared like tierMins, tierMin is the configured value
+func simTiers(tiers []TierConfig) (minAmount, tierMin, discount [TierNum]*big.Int) {
+	for j := range TierNum {
+		minAmount[j], discount[j] = maxUint248, new(big.Int)
+		if j < len(tiers) {
+			minAmount[j], discount[j] = tiers[j].MinAmount, big.NewInt(int64(tiers[j].Discount))
+		}
+		tierMin[j] = minAmount[j]
+		if j < len(tiers) && tiers[j].Inclusive {
+			minAmount[j] = new(big.Int).Sub(minAmount[j], big.NewInt(1))
+		}
+	}
+	return minAmount, tierMin, discount
+}
+
+// AuditSample returns the receipts auditSample picks from receipts by their index, as laid out by Assign.
+// a sample is a zero Receipt if there are none. with OutputOutOfRangeCount, receipts outside the block range are
+// never picked
+func (cfg *Config) AuditSample(receipts map[int]Receipt) (samples [AuditSampleNum]Receipt) {
+	span := new(big.Int).SetUint64(cfg.BlockEnd - cfg.BlockStart)
+	for k := range samples {
+		var buf []byte
+		buf = binary.BigEndian.AppendUint32(buf, cfg.Epoch)
+		buf = binary.BigEndian.AppendUint32(buf, uint32(cfg.BlockStart))
+		buf = binary.BigEndian.AppendUint32(buf, uint32(cfg.BlockEnd))
+		seed := crypto.Keccak256(append(buf, uint8(k)))
+		// low 248 bits, like sdk.Bytes32.Val[0]
+		offset := new(big.Int).Mod(new(big.Int).SetBytes(seed[1:]), span)
+		target := cfg.BlockStart + offset.Uint64()
+		best := uint64(math.MaxUint64)
+		for idx, r := range receipts {
+			if OutputOutOfRangeCount && !cfg.inBlockRange(r.BlockNum) {
+				continue
+			}
+			dist := r.BlockNum - target
+			if r.BlockNum < target {
+				dist = r.BlockNum + 1<<32 - target
+			}
+			if key := dist*MaxReceipts + uint64(idx); key < best {
+				best, samples[k] = key, r
+			}
+		}
+	}
+	return samples
+}
+
+// simAmount mirrors volumeMetric for a receipt credited to its own user
+func (cfg *Config) simAmount(r Receipt) *big.Int {
+	if ExcludeSelfTrades && slices.Contains(cfg.SelfTradeAddrs, r.User) {
+		return new(big.Int)
+	}
+	if _, ok := cfg.EOAProofTxs[r.User]; EOAUsersOnly && !ok {
+		return new(big.Int)
+	}
+	if RequireTag && r.Tag != cfg.RequiredTag {
+		return new(big.Int)
+	}
+	if TxAllowlist && !slices.ContainsFunc(cfg.AllowedTxs, func(t TxRef) bool { return t.TxHash == r.TxHash }) {
+		return new(big.Int)
+	}
+	if TickRange && (r.Tick < cfg.TickLower || r.Tick > cfg.TickUpper) {
+		return new(big.Int)
+	}
+	if OutputOutOfRangeCount && !cfg.inBlockRange(r.BlockNum) {
+		return new(big.Int)
+	}
+	if b := cfg.OptInBlocks[r.User]; RequireOptIn && (b == 0 || r.BlockNum <= b) {
+		return new(big.Int)
+	}
+	amount := cfg.simReceiptAmount(r)
+	if NumeraireVolume && cfg.NumerairePrice != nil {
+		amount.Rsh(amount.Mul(amount, cfg.NumerairePrice), NumeraireShift)
+	}
+	if ws := cfg.poolWeights(); PoolWeights && !r.V3 && r.Pool >= 0 && r.Pool < len(ws) {
+		amount.Mul(amount, new(big.Int).SetUint64(ws[r.Pool]))
+		amount.Div(amount, big.NewInt(BpsDenom))
+	}
+	if CapSwapContribution && cfg.MaxSwapContribution != nil && amount.Cmp(cfg.MaxSwapContribution) > 0 {
+		amount.Set(cfg.MaxSwapContribution)
+	}
+	if BlendedMetric {
+		amount.Mul(amount, new(big.Int).SetUint64(cfg.VolumeWeightBps))
+		if cfg.SwapCountScale != nil {
+			amount.Add(amount, new(big.Int).Mul(cfg.SwapCountScale, new(big.Int).SetUint64(cfg.CountWeightBps)))
+		}
+		amount.Div(amount, big.NewInt(BpsDenom))
+	}
+	return amount
+}
+
+// simReceiptAmount mirrors receiptAmount, the abs amount of r's swap log, or with WeightedSwapLogs its weighted sum
+// with the second log's, or their net with NetSwapLogs
+func (cfg *Config) simReceiptAmount(r Receipt) *big.Int {
+	amount := new(big.Int).Abs(r.Amount)
+	if !WeightedSwapLogs {
+		return amount
+	}
+	if NetSwapLogs {
+		if r.SecondAmount != nil {
+			amount.Abs(new(big.Int).Add(r.Amount, r.SecondAmount))
+		}
+		return amount
+	}
+	w := cfg.SwapLogWeightBps
+	for i := range w {
+		if w[i] == 0 {
+			w[i] = BpsDenom
+		}
+	}
+	amount.Mul(amount, new(big.Int).SetUint64(w[0]))
+	if r.SecondAmount != nil {
+		amount.Add(amount, new(big.Int).Mul(new(big.Int).Abs(r.SecondAmount), new(big.Int).SetUint64(w[1])))
+	}
+	return amount.Div(amount, big.NewInt(BpsDenom))
+}
+
+// simCapRewardShare mirrors capRewardShare. vol is counted volume, before tier volume adjustments
+func (cfg *Config) simCapRewardShare(users [MaxUsrNum]common.Address, vol, disc [MaxUsrNum]*big.Int) [MaxUsrNum]*big.Int {
+	bps := cfg.MaxRewardShareBps
+	if bps == 0 {
+		bps = BpsDenom
+	}
+	total, rewarded := new(big.Int), 0
+	for i := range MaxUsrNum {
+		if users[i] != (common.Address{}) && (i+1 == MaxUsrNum || users[i+1] != users[i]) {
+			rebate := new(big.Int).Mul(vol[i], disc[i])
+			total.Add(total, rebate)
+			if rebate.Sign() > 0 {
+				rewarded++
+			}
+		}
+	}
+	if rewarded < 2 {
+		return disc
+	}
+	maxRebate := total.Div(total.Mul(total, new(big.Int).SetUint64(bps)), big.NewInt(BpsDenom))
+	full := new(big.Int)
+	for i := MaxUsrNum - 1; i >= 0; i-- {
+		if i+1 == MaxUsrNum || users[i+1] != users[i] {
+			full = vol[i]
+		}
+		d := big.NewInt(1)
+		if full.Sign() > 0 {
+			d = full
+		}
+		if d = new(big.Int).Div(maxRebate, d); disc[i].Cmp(d) > 0 {
+			disc[i] = d
+		}
+	}
+	return disc
+}
+
+// simLevel mirrors tierLevel
+func simLevel(vol *big.Int, minAmount [TierNum]*big.Int) (level int) {
+	for j := range TierNum {
+		if vol.Cmp(minAmount[j]) > 0 {
+			level++
+		}
+	}
+	return level
+}
+
+// simDiscount mirrors tierDiscount
+func simDiscount(vol *big.Int, minAmount, discount [TierNum]*big.Int) *big.Int {
+	if !MarginalTiers {
+		disc := new(big.Int)
+		for j := range TierNum {
+			if vol.Cmp(minAmount[j]) > 0 {
+				disc = discount[j]
+			}
+		}
+		return disc
+	}
+	weighted := new(big.Int)
+	for j := range TierNum {
+		upper := vol
+		if j+1 < TierNum && vol.Cmp(minAmount[j+1]) >= 0 {
+			upper = minAmount[j+1]
+		}
+		if upper.Cmp(minAmount[j]) > 0 {
+			portion := new(big.Int).Sub(upper, minAmount[j])
+			weighted.Add(weighted, portion.Mul(portion, discount[j]))
+		}
+	}
+	if vol.Sign() == 0 {
+		return weighted
+	}
+	return weighted.Div(weighted, vol)
+}
+
+// simGap mirrors nextTierGap
+func simGap(vol *big.Int, minAmount [TierNum]*big.Int) *big.Int {
+	level := simLevel(vol, minAmount)
+	if level == TierNum || minAmount[level].Cmp(maxUint248) == 0 {
+		return new(big.Int)
+	}
+	return new(big.Int).Sub(minAmount[level], vol)
+}
+
+// simIndex mirrors userIndex, number of distinct users with a smaller address
+func simIndex(users [MaxUsrNum]common.Address, i int) (index int) {
+	for j, u := range users {
+		first := u != (common.Address{}) && (j == 0 || users[j-1] != u)
+		if first && u.Big().Cmp(users[i].Big()) < 0 {
+			index++
+		}
+	}
+	return index
+}
+
+// outputs holds simulated values by layout field name
+type outputs struct {
+	vals map[string]*big.Int
+}
+
+func (o *outputs) add(name string, v *big.Int) {
+	if o.vals == nil {
+		o.vals = make(map[string]*big.Int)
+	}
+	o.vals[name] = v
+}
+
+// pack encodes fields packed big endian, Bits/8 bytes each, like the circuit output
+func (o *outputs) pack(fields []OutputField) ([]byte, error) {
+	var buf []byte
+	for _, f := range fields {
+		v, ok := o.vals[f.Name]
+		if !ok {
+			return nil, fmt.Errorf("output %s is not simulated", f.Name)
+		}
+		if v.Sign() < 0 || v.BitLen() > f.Bits {
+			return nil, fmt.Errorf("output %s value %s doesn't fit %d bits", f.Name, v, f.Bits)
+		}
+		buf = append(buf, common.LeftPadBytes(v.Bytes(), f.Bits/8)...)
+	}
+	return buf, nil
+}
@@ -0,0 +1,31 @@
+package circuit
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestTieringEquivalent(t *testing.T) {
+	a := []TierConfig{{MinAmount: big.NewInt(1_000), Discount: 100}, {MinAmount: big.NewInt(10_000), Discount: 300}}
+	// same table with a redundant tier of the same discount
+	b := []TierConfig{
+		{MinAmount: big.NewInt(1_000), Discount: 100},
+		{MinAmount: big.NewInt(5_000), Discount: 100},
+		{MinAmount: big.NewInt(10_000), Discount: 300},
+	}
+	// tier 1 one unit higher
+	c := []TierConfig{{MinAmount: big.NewInt(1_000), Discount: 100}, {MinAmount: big.NewInt(10_001), Discount: 300}}
+	var samples []*big.Int
+	for _, boundary := range []int64{0, 1_000, 5_000, 10_000, 10_001} {
+		for d := int64(-1); d <= 1; d++ {
+			samples = append(samples, big.NewInt(boundary+d))
+		}
+	}
+	if !TieringEquivalent(a, b, samples) {
+		t.Error("table with a redundant tier isn't equivalent")
+	}
+	// marginal discounts of c differ by less than rounding at these samples
+	if !MarginalTiers && TieringEquivalent(a, c, samples) {
+		t.Error("table with tier 1 a unit higher is equivalent")
+	}
+}
@@ -0,0 +1,78 @@
+package circuit
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// v4 PoolManager storage layout, same as v4-core StateLibrary
+const (
+	PoolsSlot       = 6
+	LiquidityOffset = 3
+)
+
+// EIP-1967 implementation slot of the hook's TransparentUpgradeableProxy, bytes32(uint256(keccak256('eip1967.proxy.implementation')) - 1)
+const ImplementationSlot = "0x360894a13ba1a3210667c828492db98dca3e2076cc3735a920a3ca505d382bbc"
+
+// slotLayout is where each enabled state proof starts in in.StorageSlots, Total is number of slots to allocate
+type slotLayout struct {
+	Liquidity, HookImpl, OptIn, Numeraire, Reputation, Total int
+}
+
+// storageSlots returns storage slot layout for enabled options, in the order state proofs are listed
+func storageSlots() (l slotLayout) {
+	add := func(enabled bool, n int) int {
+		start := l.Total
+		if enabled {
+			l.Total += n
+		}
+		return start
+	}
+	// one liquidity slot per receipt, at receipt's index, or a single one at StateRefBlock
+	liquiditySlots := MaxReceipts
+	if LiquidityAtStateRef {
+		liquiditySlots = 1
+	}
+	l.Liquidity = add(CheckPoolLiquidity, liquiditySlots)
+	l.HookImpl = add(CheckHookImpl, 1)
+	// one per user slot, at slot index
+	l.OptIn = add(RequireOptIn, MaxUsrNum)
+	l.Numeraire = add(NumeraireVolume, 1)
+	// one per user slot, at slot index
+	l.Reputation = add(ReputationBoost, MaxUsrNum)
+	return l
+}
+
+// txLayout is where each enabled transaction proof starts in in.Transactions, Total is number to allocate
+type txLayout struct {
+	AgeProof, EOAProof, Total int
+}
+
+// transactionSlots returns transaction layout for enabled options
+func transactionSlots() (l txLayout) {
+	add := func(enabled bool, n int) int {
+		start := l.Total
+		if enabled {
+			l.Total += n
+		}
+		return start
+	}
+	// one per user slot, at slot index
+	l.AgeProof = add(PenalizeFreshUsers, MaxUsrNum)
+	l.EOAProof = add(EOAUsersOnly, MaxUsrNum)
+	return l
+}
+
+// LiquiditySlot returns the PoolManager storage slot holding liquidity of poolId: keccak256(poolId, PoolsSlot) + LiquidityOffset
+func LiquiditySlot(poolId common.Hash) common.Hash {
+	state := crypto.Keccak256(poolId.Bytes(), common.LeftPadBytes(big.NewInt(PoolsSlot).Bytes(), 32))
+	return common.BigToHash(new(big.Int).Add(new(big.Int).SetBytes(state), big.NewInt(LiquidityOffset)))
+}
+
+// AddressMappingSlot returns the registry storage slot of user's entry in a mapping(address => uint256) at mappingSlot:
+// keccak256(abi.encode(user, mappingSlot))
+func AddressMappingSlot(user common.Address, mappingSlot uint64) common.Hash {
+	return crypto.Keccak256Hash(common.LeftPadBytes(user.Bytes(), 32), common.LeftPadBytes(new(big.Int).SetUint64(mappingSlot).Bytes(), 32))
+}
@@ -3,6 +3,10 @@ package circuit
 import (
 	"encoding/hex"
 	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"slices"
 
 	"github.com/brevis-network/brevis-sdk/sdk"
 )
@@ -10,96 +14,561 @@ import (
 const (
 	MaxReceipts = MaxPerUsr * MaxUsrNum
 	MaxPerUsr   = 128
-	MaxUsrNum   = 32
-	TierNum     = 5
+	// 32, or CuratedUsrNum with CuratedBatch
+	MaxUsrNum = maxUsrNum
+	// user slots with CuratedBatch, eg. the list of an invite only program
+	CuratedUsrNum = 10
+	// may be 0, no discount for anyone, or 1, a single pass/fail tier
+	TierNum = 5
+	// max number of configured self-trade/collusion addresses
+	MaxSelfTradeAddrs = 4
+	// max number of pools with MultiPool, including PoolId
+	MaxPoolNum = 4
+	// max number of v3 pools with V3Pools
+	MaxV3PoolNum = 4
+	// max number of routers with NoHookLog
+	MaxRouterNum = 4
+	// max number of users output with OutputRequestedUsers
+	MaxRequestedUsers = 8
+	// max number of txs with TxAllowlist
+	MaxAllowedTxs = 16
+	// number of receipts output with OutputAuditSample
+	AuditSampleNum = 4
+	// BlockEnd - BlockStart of every epoch with AssertEpochLength, a week of 12s blocks
+	EpochBlocks = 50400
+	// with OutputFlowRate, pool fees are in pips like v4 LPFee, and an epoch's default length is its blocks times
+	// SecondsPerBlock
+	FeePipsDenom    = 1000000
+	SecondsPerBlock = 12
+	// with OutputGasWeightedVolume, gas fields from 2^GasBits up are treated as 0
+	GasBits = 64
+	// with ReputationBoost, reputation scores from 2^ReputationBits up are treated as 0
+	ReputationBits = 64
+	// with NumeraireVolume, the proven price is a fixed point with NumeraireShift fractional bits, below 2^NumerairePriceBits
+	NumeraireShift     = 64
+	NumerairePriceBits = 120
+	// denominator of all *Bps params, 10000 is 100%
+	BpsDenom = 10000
+	// score of the top user with OutputVolumeScore
+	MaxVolumeScore = 1000
+	// size of each delta with DeltaAddresses, all ones marks padding. sorted random addresses are ~2^160/N apart so
+	// lower it only for batches known to be denser, proofs fail for larger deltas
+	AddressDeltaBits = 160
+)
+
+//go:generate go run gen_options.go
+
+// optional features, all disabled by default so output stays epoch:[address:discount]. each is enabled by the
+// build tag of its name, eg. go test -tags MultiPool,GateTierByPools, set in its opt_*.go files
+const (
+	// output each user's position in the sorted unique address list right after its address,
+	// so contracts can store results keyed by a compact index
+	OutputUserIndex = optOutputUserIndex
+	// skip swaps whose tx.origin is in SelfTradeAddrs, see README for limits
+	ExcludeSelfTrades = optExcludeSelfTrades
+	// rank users by volume and zero the discount of those past BatchVolumeCap cumulative volume
+	CapBatchVolume = optCapBatchVolume
+	// output keccak256(address|Salt) instead of the address so results don't reveal who traded
+	OutputUserCommitment = optOutputUserCommitment
+	// output number of accepted receipts after epoch, so consumer can check no swaps were omitted
+	OutputReceiptCount = optOutputReceiptCount
+	// scale volume by ConcentrationPenaltyBps if a user's max single block volume is over ConcentrationBps of total
+	PenalizeBlockConcentration = optPenalizeBlockConcentration
+	// only count swaps where a storage proof shows pool liquidity at the swap block is at least MinLiquidity
+	CheckPoolLiquidity = optCheckPoolLiquidity
+	// output DiscountDenom after epoch so the contract reads discount units from the proof
+	OutputDiscountDenom = optOutputDiscountDenom
+	// discount is the volume weighted blend of each tier band's discount, like marginal tax rates
+	MarginalTiers = optMarginalTiers
+	// users below tier MinOutputTier are output as padding (zero addr and discount)
+	FilterMinOutputTier = optFilterMinOutputTier
+	// prove hook proxy's EIP-1967 implementation at StateRefBlock is HookImpl, so receipts are from the expected code
+	CheckHookImpl = optCheckHookImpl
+	// Fields[3] is amount of an optional second swap log in the same tx, amounts are weighted by SwapLogWeightBps
+	WeightedSwapLogs = optWeightedSwapLogs
+	// output hash of pool, hook, blocks, tiers, denom and option flags so auditors can match a proof to a published config
+	OutputConfigHash = optOutputConfigHash
+	// also accept swaps from ExtraPoolIds, each with its own hook, and sum a user's volume across all pools
+	MultiPool = optMultiPool
+	// tiers from MultiPoolTier up require swaps in at least MinPools distinct pools, needs MultiPool
+	GateTierByPools = optGateTierByPools
+	// with MultiPool, read amount0 or amount1 per pool (PoolAmountIndex) so all pools count the same canonical token
+	CanonicalVolumeToken = optCanonicalVolumeToken
+	// reject batches with fewer than MinUsers distinct non-zero users
+	RequireMinUsers = optRequireMinUsers
+	// output each user's share of batch volume in bps after discount
+	OutputVolumeShare = optOutputVolumeShare
+	// scale tier volume by FreshPenaltyBps for users without a proven tx sent before AgeCutoffBlock
+	PenalizeFreshUsers = optPenalizeFreshUsers
+	// output merkle root of (index, user, discount) leaves in MerkleLeafEncoding, for airdrop distributor contracts
+	OutputMerkleRoot   = optOutputMerkleRoot
+	MerkleLeafEncoding = LeafIndexAddressAmount
+	// assert every configured hook address has all HookFlags permission bits set
+	CheckHookFlags = optCheckHookFlags
+	// users with the same non-zero EntityIds are tiered on their combined volume and all get the entity's discount
+	AggregateEntities = optAggregateEntities
+	// output volume of receipts that passed the pool checks but aren't credited to their segment's user
+	OutputOtherVolume = optOutputOtherVolume
+	// round output volumes to the nearest multiple of VolumePrecision, tier decisions use full precision
+	RoundOutputVolume = optRoundOutputVolume
+	// assert each user's slots are adjacent, as validateUsers does, so its last slot holds its full total
+	AssertSegmentLayout = optAssertSegmentLayout
+	// with MultiPool, each hook has its own TxOrigin event layout (HookEventIds, HookOriginIndex) and tier table,
+	// a user gets the best discount over per pool volumes
+	PerHookConfig = optPerHookConfig
+	// receipts count only if amount0 or amount1 is above DustThreshold, the other amount is Fields[3].
+	// can't be combined with WeightedSwapLogs which uses the same field
+	FilterDustSwaps = optFilterDustSwaps
+	// output per user how much volume is missing for the next tier, 0 at top tier
+	OutputNextTierGap = optOutputNextTierGap
+	// a single receipt adds at most MaxSwapContribution to its user's volume
+	CapSwapContribution = optCapSwapContribution
+	// output number of distinct users at each tier level 0..TierNum
+	OutputTierHistogram = optOutputTierHistogram
+	// scale discount up by StreakBonusBps per consecutive epoch in StreakLength, at most MaxStreakBonusBps
+	StreakBonus = optStreakBonus
+	// with WeightedSwapLogs, a receipt's two swap amounts are netted by sign, so a buy then sell round trip counts
+	// only its net position change
+	NetSwapLogs = optNetSwapLogs
+	// assert no user slot is PoolAddr or a configured hook, so protocol activity can't be attributed to a user
+	AssertUsersNotProtocol = optAssertUsersNotProtocol
+	// output per user volume scaled to 0..MaxVolumeScore relative to the batch's top user
+	OutputVolumeScore = optOutputVolumeScore
+	// users sorted ascending, output first user in the header and each slot as delta from the previous slot's user
+	DeltaAddresses = optDeltaAddresses
+	// assert no user has more than MaxUserSwaps receipts in the batch
+	CapUserSwaps = optCapUserSwaps
+	// also accept v3 Swap logs of V3Pools, user is the swap recipient, and add their volume to the same tier decision
+	V3Pools = optV3Pools
+	// output per user 1 if a cap or penalty lowered its tier volume below its raw volume
+	OutputClampFlag = optOutputClampFlag
+	// with CheckPoolLiquidity, prove liquidity once at StateRefBlock instead of at each receipt's block
+	LiquidityAtStateRef = optLiquidityAtStateRef
+	// output each tier's min amount and discount after config hash, so the contract can match them to its own table
+	OutputTierTable = optOutputTierTable
+	// per user output is only address and discount of each of RequestedUsers, looked up among Users
+	OutputRequestedUsers = optOutputRequestedUsers
+	// output bytes32 EpochLabel instead of uint32 Epoch, for programs naming epochs by a hash or string
+	EpochLabel = optEpochLabel
+	// metric is VolumeWeightBps of volume plus CountWeightBps of SwapCountScale per counted swap
+	BlendedMetric = optBlendedMetric
+	// assert toggled receipts of each segment are in non-decreasing block order
+	AssertBlockOrder = optAssertBlockOrder
+	// scale each swap's amount by its pool's PoolWeightBps, see pools()
+	PoolWeights = optPoolWeights
+	// output sum of final discounts of all users before merkle root, for tracking program cost per epoch
+	OutputTotalDiscount = optOutputTotalDiscount
+	// tiers with TierInclusive set are reached at vol >= min amount instead of vol > min amount
+	TierInclusive = optTierInclusive
+	// assert no toggled receipt's amount is above MaxSwapAmount, a sanity ceiling against garbage or manipulated logs
+	AssertMaxSwapAmount = optAssertMaxSwapAmount
+	// tier 0's min amount is an eligibility gate: only users who reach it are output, packed at the front
+	GateLowestTier = optGateLowestTier
+	// receipts must be in [BlockStart, BlockEnd) instead of (BlockStart, BlockEnd), so epochs with end = next start tile
+	HalfOpenBlockRange = optHalfOpenBlockRange
+	// output each user's first and last receipt block after its other values, tenure within the epoch
+	OutputBlockRange = optOutputBlockRange
+	// no hook log: Fields[0] is the swap log's sender topic and the user is the sender, for pools without a VipHook
+	NoHookLog = optNoHookLog
+	// assert each tier's discount is at most MaxDiscountStep above the previous tier's, and tier 0's above 0
+	AssertDiscountSteps = optAssertDiscountSteps
+	// output each user's matched volume, min of its bought and sold volume by amount0 sign, ie. round trip turnover
+	OutputMatchedVolume = optOutputMatchedVolume
+	// output ShardIndex and ShardCount after epoch and assert every user is in the shard, address % ShardCount
+	Sharded = optSharded
+	// swap amount fields are unsigned, eg. a hook emitting abs amounts, so they're read as is instead of ABS
+	UnsignedAmounts = optUnsignedAmounts
+	// count a user's swaps only after its opt-in block, proven from OptInRegistry's mapping at StateRefBlock
+	RequireOptIn = optRequireOptIn
+	// accept swaps outside the block range, they don't count, and output how many after receipt count
+	OutputOutOfRangeCount = optOutputOutOfRangeCount
+	// output AuditSampleNum receipts picked by a hash of epoch and block range, for auditors to spot check on chain
+	OutputAuditSample = optOutputAuditSample
+	// convert each swap's amount to numeraire value with the price proven from NumeraireOracle at StateRefBlock
+	NumeraireVolume = optNumeraireVolume
+	// receipts count only if their swap's resulting tick, Fields[3], is in [TickLower, TickUpper]
+	TickRange = optTickRange
+	// assert BlockEnd - BlockStart is EpochBlocks, so a standard epoch's proof can't cover a shorter or longer window
+	AssertEpochLength = optAssertEpochLength
+	// count only users with a proven tx they sent, ie. EOAs, so contract senders and recipients get nothing
+	EOAUsersOnly = optEOAUsersOnly
+	// pack one row per real user at the front, like GateLowestTier, and output their count before the rows
+	OutputResultCount = optOutputResultCount
+	// second tier table on each user's counted swaps, output per user as a rewards multiplier in bps
+	CountTiers = optCountTiers
+	// output keccak256 of all pool ids after config hash, for the contract to match a signed pool allowlist
+	OutputPoolAllowlist = optOutputPoolAllowlist
+	// output per user a bitmask of tiers it qualified for, bit j set if its tier volume reaches tier j
+	OutputQualifiedTiers = optOutputQualifiedTiers
+	// reject batches whose total volume isn't above MinBatchVolume, so near empty epochs aren't worth a submission
+	RequireMinBatchVolume = optRequireMinBatchVolume
+	// add ReputationScale per point of each user's reputation, proven from ReputationRegistry, to its tier volume
+	ReputationBoost = optReputationBoost
+	// output per user the rebate its discount earns, spread over the epoch as a per second flow rate for streaming
+	// distributors
+	OutputFlowRate = optOutputFlowRate
+	// clamp each user's tier level to one above its PriorTier, so no user jumps more than one tier per epoch
+	CapTierJump = optCapTierJump
+	// output per user the final discount with the CountTiers multiplier applied, so the contract applies one value
+	OutputEffectiveDiscount = optOutputEffectiveDiscount
+	// count only receipts of AllowedTxs, eg. to prove a claim or audit on a precise set of swaps
+	TxAllowlist = optTxAllowlist
+	// lower discounts so no user's rebate, volume times discount, is above MaxRewardShareBps of the batch's total
+	CapRewardShare = optCapRewardShare
+	// output per user the sum of each counted swap's volume times the gas used its hook log reports, for gas aware
+	// rewards. needs a hook that emits gas used
+	OutputGasWeightedVolume = optOutputGasWeightedVolume
+	// assert no two non padding user slots have the same user, for programs with one slot per user
+	RequireDistinctUsers = optRequireDistinctUsers
+	// output per user keccak256(abi.encodePacked(address account, uint16 discount, uint32 epoch, uint64 nonce)), a
+	// message a relayer or contract can take as the user's signed claim authorization
+	OutputClaimHash = optOutputClaimHash
+	// count only swaps whose hook log carries RequiredTag, eg. a referral tag of a partner frontend
+	RequireTag = optRequireTag
+	// pack only users at the top tier, level TierNum, into the output rows like GateLowestTier, for top tier giveaways
+	TopTierOnly = optTopTierOnly
+	// size the circuit for a small curated list, MaxUsrNum is CuratedUsrNum so the user and receipt loops shrink
+	CuratedBatch = optCuratedBatch
+)
+
+// v4 hook permission flags in the low bits of hook address, see v4-core Hooks.sol. VipHook uses afterInitialize and beforeSwap
+const (
+	AfterInitializeFlag = 1 << 12
+	BeforeSwapFlag      = 1 << 7
+	// all permission bits
+	AllHookMask = 1<<14 - 1
+)
+
+// merkle leaf encodings, tree uses sorted pair hashing like OpenZeppelin MerkleProof
+const (
+	// keccak256(abi.encodePacked(uint256 index, address account, uint256 amount)), Uniswap MerkleDistributor
+	LeafIndexAddressAmount = iota
+	// keccak256(bytes.concat(keccak256(abi.encode(address account, uint256 amount)))), OpenZeppelin StandardMerkleTree
+	LeafOZStandard
+	// keccak256(abi.encodePacked(address account, uint16 discount, uint248 volume)), each user's output row then adds
+	// volume so the row is the leaf preimage
+	LeafAddressDiscountVolume
 )
 
 // output addr:discount
 type UniVipHookCircuit struct {
 	Epoch sdk.Uint32
+	// output instead of Epoch with EpochLabel
+	EpochLabel sdk.Bytes32
 	// addr that emits events
 	PoolAddr, HookAddr sdk.Uint248
 	// unique pool identifier, hash of PoolKey
 	PoolId sdk.Bytes32
 	// block range, check receipt is in range
 	BlockStart, BlockEnd sdk.Uint32
+	// block all snapshot state proofs are read at, in (BlockStart, BlockEnd]
+	StateRefBlock sdk.Uint32
 
 	// tier configs
 	// MUST be sorted from LOWEST to HIGHEST, discount must match minAmount config
 	// logic is simple: disc = 0; while vol > minAmount[i], disc = dicount[i],
 	TierMinAmount, TierDiscount [TierNum]sdk.Uint248
+	// this proof's shard of users with Sharded, ShardCount must be non-zero
+	ShardIndex, ShardCount sdk.Uint248
+	// largest discount increase between adjacent tiers with AssertDiscountSteps
+	MaxDiscountStep sdk.Uint248
+	// with TierInclusive, 1 if vol equal to the tier's min amount reaches it
+	TierInclusive [TierNum]sdk.Uint248
+	// TierDiscount is in 1/DiscountDenom, default MaxDiscount ie. percentage*100
+	DiscountDenom sdk.Uint248
+	// tier level is number of tiers passed, 0 is none, TierNum is top tier
+	MinOutputTier sdk.Uint248
 
 	// User addresses of one batch, same addr must be adjacent for vol to be added together
 	Users [MaxUsrNum]sdk.Uint248
+
+	// known wash trading addresses, swaps from them never count. unused slots are 0
+	SelfTradeAddrs [MaxSelfTradeAddrs]sdk.Uint248
+	// max total volume rewarded in this batch, highest volume users are rewarded first
+	BatchVolumeCap sdk.Uint248
+	// per epoch secret for user commitments, users open their commitment with it
+	Salt sdk.Uint248
+	// single block volume above this share of total (bps) is concentrated, concentrated users keep PenaltyBps of volume
+	ConcentrationBps, ConcentrationPenaltyBps sdk.Uint248
+	// largest share of the batch's total rebate (bps) one user gets with CapRewardShare
+	MaxRewardShareBps sdk.Uint248
+	// PoolManager slot of this pool's liquidity, see LiquiditySlot
+	LiquiditySlot sdk.Bytes32
+	MinLiquidity  sdk.Uint248
+	// expected logic contract behind HookAddr proxy
+	HookImpl sdk.Uint248
+	// weight of primary (Fields[2]) and secondary (Fields[3]) swap amount
+	SwapLogWeightBps [2]sdk.Uint248
+	// pools besides PoolId and their hooks, unused slots are 0
+	ExtraPoolIds   [MaxPoolNum - 1]sdk.Bytes32
+	ExtraHookAddrs [MaxPoolNum - 1]sdk.Uint248
+	// tier level from which MinPools distinct pools are needed, 0 disables the gate
+	MultiPoolTier, MinPools sdk.Uint248
+	// data index of the amount holding the volume token for each pool, see pools()
+	PoolAmountIndex [MaxPoolNum]sdk.Uint248
+	MinUsers        sdk.Uint248
+	// users must prove a tx from them before this block to not be treated as fresh
+	AgeCutoffBlock  sdk.Uint32
+	FreshPenaltyBps sdk.Uint248
+	// permission bits hooks must have, within AllHookMask
+	HookFlags sdk.Uint248
+	// entity of each user slot, eg. an institution's wallets, 0 means the user is its own entity
+	EntityIds [MaxUsrNum]sdk.Uint248
+	// with RoundOutputVolume, eg. 1e18 for whole tokens
+	VolumePrecision sdk.Uint248
+	// hook tx.origin event and its topic index for each pool, see pools()
+	HookEventIds    [MaxPoolNum]sdk.Uint248
+	HookOriginIndex [MaxPoolNum]sdk.Uint248
+	// tiers of ExtraPoolIds with PerHookConfig, PoolId uses TierMinAmount and TierDiscount
+	ExtraTierMinAmount, ExtraTierDiscount [MaxPoolNum - 1][TierNum]sdk.Uint248
+	// abs amount a swap must exceed in at least one token to count
+	DustThreshold sdk.Uint248
+	// per receipt volume cap, blunts one huge swap
+	MaxSwapContribution sdk.Uint248
+	MaxUserSwaps        sdk.Uint248
+	// ceiling of any receipt's amount with AssertMaxSwapAmount, unlike MaxSwapContribution the proof fails above it
+	MaxSwapAmount sdk.Uint248
+	// v3 pool contracts with V3Pools, unused slots are 0
+	V3PoolAddrs [MaxV3PoolNum]sdk.Uint248
+	// swap senders with NoHookLog that call the pool manager for other accounts, no user may be one. unused slots are 0
+	Routers [MaxRouterNum]sdk.Uint248
+	// tier level of each user slot's user in the previous epoch with CapTierJump, 0 for new users
+	PriorTier [MaxUsrNum]sdk.Uint248
+	// only tag counted with RequireTag
+	RequiredTag sdk.Bytes32
+	// with OutputClaimHash, each user slot's user's claim nonce
+	ClaimNonce [MaxUsrNum]sdk.Uint248
+	// consecutive epochs each user slot's user has been active, including this one
+	StreakLength                      [MaxUsrNum]sdk.Uint248
+	StreakBonusBps, MaxStreakBonusBps sdk.Uint248
+	// weight of each pool's swaps in pools() order, v3 swaps are not weighted
+	PoolWeightBps [MaxPoolNum]sdk.Uint248
+	// weights of volume and swap count in the blended metric, and the volume one swap is worth
+	VolumeWeightBps, CountWeightBps, SwapCountScale sdk.Uint248
+	// users to output with OutputRequestedUsers, unused slots are 0
+	RequestedUsers [MaxRequestedUsers]sdk.Uint248
+	// with RequireOptIn, contract and slot of its mapping(address => uint256) of each user's opt-in block
+	OptInRegistry, OptInMappingSlot sdk.Uint248
+	// with NumeraireVolume, oracle contract and its storage slot holding the price of the counted token in numeraire
+	NumeraireOracle sdk.Uint248
+	NumeraireSlot   sdk.Bytes32
+	// with ReputationBoost, contract and slot of its mapping(address => uint256) of each user's reputation, and the
+	// tier volume one point is worth
+	ReputationRegistry, ReputationMappingSlot, ReputationScale sdk.Uint248
+	// with OutputFlowRate, fee the discount applies to, in pips, and the epoch's length in seconds
+	RebateFeePips, EpochSeconds sdk.Uint248
+	// with RequireMinBatchVolume, total volume of all users must be above it
+	MinBatchVolume sdk.Uint248
+	// inclusive tick band with TickRange
+	TickLower, TickUpper sdk.Int248
+	// with CountTiers, users with more counted swaps than CountTierMinSwaps[j] get CountTierMultiplier[j], in bps.
+	// below the first count tier the multiplier is BpsDenom
+	CountTierMinSwaps   [TierNum]sdk.Uint248
+	CountTierMultiplier [TierNum]sdk.Uint248
+	// with TxAllowlist, block and receipt trie key, rlp of the tx index, of each tx whose receipt counts. unused slots
+	// are 0, no receipt is in block 0
+	AllowedTxBlock, AllowedTxKey [MaxAllowedTxs]sdk.Uint32
 }
 
+// field positions of Swap(PoolId indexed id, address indexed sender, int128 amount0, ...) and TxOrigin(address indexed addr).
+// poolid position is configurable for hooks/versions emitting it as a data field or another topic
+const (
+	PoolIdIsTopic    = true
+	PoolIdFieldIndex = 1
+	AmountDataIndex  = 0
+	Amount1DataIndex = 1
+	OriginTopicIndex = 1
+	// Swap sender, user with NoHookLog
+	SwapSenderTopicIndex = 2
+	// resulting tick in the data of both v4 and v3 Swap, after amounts, sqrtPriceX96 and liquidity
+	TickDataIndex = 4
+	// gas used in the data of the hook log with OutputGasWeightedVolume, eg. TxOrigin(address indexed addr, uint256 gasUsed)
+	HookGasDataIndex = 0
+	// referral tag in the data of the hook log with RequireTag, eg. TxOrigin(address indexed addr, bytes32 tag)
+	HookTagDataIndex = 0
+	// v3 Swap(address indexed sender, address indexed recipient, int256 amount0, ...)
+	V3RecipientTopicIndex = 2
+)
+
 const (
-	UniSwapEv = "0x40e9cecb9f5f1f1c5b9c97dec2917b7ee92e57ba5563708daca94dd84ad7112f"
+	UniSwapEv   = "0x40e9cecb9f5f1f1c5b9c97dec2917b7ee92e57ba5563708daca94dd84ad7112f"
+	TxOriginEv  = "0x4f8272f9d756f2f56d6a05792b13469cba4d94669c54bf5b7014093a6af2a6a2"
+	UniSwapV3Ev = "0xc42079f94a6350d7e6235f29174924f928cc2ac818eb64fed8004e115fbcca67"
 )
 
 var (
-	EventIdUniSwap = sdk.ParseEventID(Hex2Bytes(UniSwapEv))
-	EventIdHook    = sdk.ParseEventID(Hex2Bytes("0x4f8272f9d756f2f56d6a05792b13469cba4d94669c54bf5b7014093a6af2a6a2"))
+	EventIdUniSwap   = sdk.ParseEventID(Hex2Bytes(UniSwapEv))
+	EventIdHook      = sdk.ParseEventID(Hex2Bytes(TxOriginEv))
+	EventIdUniSwapV3 = sdk.ParseEventID(Hex2Bytes(UniSwapV3Ev))
 )
 
 func (c *UniVipHookCircuit) Allocate() (maxReceipts, maxStorage, maxTransactions int) {
-	return MaxReceipts, 0, 0
+	return MaxReceipts, storageSlots().Total, transactionSlots().Total
 }
 
 // each receipt has 3 logs, one and two are same swap from pool(poolid and amount0), one misc from hook(tx.origin)
 // in.Receipts have MaxUsrNum segments, each seg has up to MaxPerUsr receipts
 // first we sum each segment, then if Users[i] == Users[i+1], we add vol to later
 func (c *UniVipHookCircuit) Define(api *sdk.CircuitAPI, in sdk.DataInput) error {
+	receipts := sdk.NewDataStream(api, in.Receipts)
+	// phase one runs every assertion, phase two only computes and outputs, so no output depends on an
+	// unchecked input. new checks go in assertInputs, unless they only need values output computes anyway
+	c.assertInputs(api, in, receipts)
+	return c.output(api, in, receipts)
+}
+
+// assertInputs asserts all receipt, state and config checks enabled options need
+func (c *UniVipHookCircuit) assertInputs(api *sdk.CircuitAPI, in sdk.DataInput, receipts *sdk.DataStream[sdk.Receipt]) {
 	api.AssertInputsAreUnique()
 
-	api.OutputUint32(32, c.Epoch)
-	receipts := sdk.NewDataStream(api, in.Receipts)
 	// for each receipt, make sure it's from expected pool
+	blockStart, blockEnd := c.acceptedBlocks()
 	sdk.AssertEach(receipts, func(r sdk.Receipt) sdk.Uint248 {
-		// Log index must be ascending order
-		hookLog := r.Fields[0]
-		swapLog := r.Fields[1]
-		swapLog2 := r.Fields[2]
-
-		return api.Uint248.And(
-			// BlockStart < r.BlockNum < BlockEnd
-			api.ToUint248(api.Uint32.And(
-				api.Uint32.IsLessThan(c.BlockStart, r.BlockNum),
-				api.Uint32.IsLessThan(r.BlockNum, c.BlockEnd),
-				api.Uint32.IsEqual(swapLog.LogPos, swapLog2.LogPos)),
-			),
-			// swap addr and eventid
-			api.Uint248.IsEqual(swapLog.Contract, c.PoolAddr),
-			api.Uint248.IsEqual(swapLog2.Contract, c.PoolAddr),
-			// poolid
-			api.Bytes32.IsEqual(swapLog.Value, c.PoolId),
-			// must be same event
-			api.Uint248.IsEqual(swapLog.EventID, swapLog2.EventID),
-			// eventid must equal uniswap
-			api.Uint248.IsEqual(swapLog.EventID, EventIdUniSwap),
-
-			// hook event
-			api.Uint248.IsEqual(hookLog.Contract, c.HookAddr),
-			api.Uint248.IsEqual(hookLog.EventID, EventIdHook),
-		)
+		var ok sdk.Uint248
+		if MultiPool {
+			// anyPool checks the hook log layout
+			ok = api.Uint248.And(swapLogsOK(api, r, c.PoolAddr, blockStart, blockEnd), c.anyPool(api, r))
+		} else {
+			ok = swapReceiptOK(api, r, c.PoolAddr, c.HookAddr, c.PoolId, blockStart, blockEnd)
+		}
+		if V3Pools {
+			ok = api.Uint248.Or(ok, c.v3SwapOK(api, r, blockStart, blockEnd))
+		}
+		return ok
 	})
+	if CheckHookImpl || LiquidityAtStateRef || RequireOptIn || NumeraireVolume || ReputationBoost {
+		api.Uint32.AssertIsEqual(api.Uint32.IsLessThan(c.BlockStart, c.StateRefBlock), sdk.ConstUint32(1))
+		api.Uint32.AssertIsLessOrEqual(c.StateRefBlock, c.BlockEnd)
+	}
+	if CheckHookImpl {
+		c.assertHookImpl(api, in)
+	}
+	if NumeraireVolume {
+		c.assertNumerairePrice(api, in)
+	}
+	if CheckHookFlags {
+		c.assertHookFlags(api)
+	}
+	if AssertSegmentLayout {
+		assertSegmentLayout(api, c.Users)
+	}
+	if RequireDistinctUsers {
+		assertDistinctUsers(api, c.Users)
+	}
+	if Sharded {
+		api.Uint248.AssertIsEqual(api.Uint248.IsLessThan(c.ShardIndex, c.ShardCount), sdk.ConstUint248(1))
+		for i := range MaxUsrNum {
+			_, shard := api.Uint248.Div(c.Users[i], c.ShardCount)
+			// padding is in every shard
+			outside := api.Uint248.And(api.Uint248.Not(api.Uint248.IsZero(c.Users[i])), api.Uint248.Not(api.Uint248.IsEqual(shard, c.ShardIndex)))
+			api.Uint248.AssertIsEqual(outside, sdk.ConstUint248(0))
+		}
+	}
+	if AssertDiscountSteps {
+		// padded tiers have discount 0, never a step up
+		prev := sdk.ConstUint248(0)
+		for j := range TierNum {
+			api.Uint248.AssertIsLessOrEqual(c.TierDiscount[j], api.Uint248.Add(prev, c.MaxDiscountStep))
+			prev = c.TierDiscount[j]
+		}
+	}
+	if AssertMaxSwapAmount {
+		for j, r := range in.Receipts.Raw {
+			above := api.Uint248.IsGreaterThan(c.receiptAmount(api, r), c.MaxSwapAmount)
+			api.Uint248.AssertIsEqual(api.Uint248.And(sdk.Uint248{Val: in.Receipts.Toggles[j]}, above), sdk.ConstUint248(0))
+		}
+	}
+	if AssertUsersNotProtocol {
+		c.assertUsersNotProtocol(api)
+	}
+	if NoHookLog {
+		c.assertUsersNotRouters(api)
+	}
+	if DeltaAddresses {
+		assertSortedUsers(api, c.Users)
+	}
+	if CapUserSwaps {
+		c.assertUserSwaps(api, in)
+	}
+	if AssertBlockOrder {
+		assertBlockOrder(api, in.Receipts)
+	}
+	if AssertEpochLength {
+		api.Uint32.AssertIsEqual(api.Uint32.Sub(c.BlockEnd, c.BlockStart), sdk.ConstUint32(EpochBlocks))
+	}
+	if RequireMinUsers {
+		// each distinct user has exactly one final slot
+		numUsers := sdk.ConstUint248(0)
+		for _, f := range finalSlots(api, c.Users) {
+			numUsers = api.Uint248.Add(numUsers, f)
+		}
+		api.Uint248.AssertIsLessOrEqual(c.MinUsers, numUsers)
+	}
+}
+
+// output computes user discounts and outputs header and per user fields, see DefaultOutputLayout
+func (c *UniVipHookCircuit) output(api *sdk.CircuitAPI, in sdk.DataInput, receipts *sdk.DataStream[sdk.Receipt]) error {
+	if EpochLabel {
+		api.OutputBytes32(c.EpochLabel)
+	} else {
+		api.OutputUint32(32, c.Epoch)
+	}
+	if Sharded {
+		api.OutputUint(16, c.ShardIndex)
+		api.OutputUint(16, c.ShardCount)
+	}
+	if OutputReceiptCount {
+		// every toggled receipt passed AssertEach above, padding is not counted
+		count := sdk.Count(receipts)
+		if OutputOutOfRangeCount {
+			count = sdk.ConstUint248(0)
+			for j := range in.Receipts.Raw {
+				count = api.Uint248.Add(count, c.inRange(api, in, j))
+			}
+		}
+		api.OutputUint(32, count)
+	}
+	if OutputOutOfRangeCount {
+		api.OutputUint(32, c.outOfRangeCount(api, in))
+	}
+	if OutputDiscountDenom {
+		api.OutputUint(16, c.DiscountDenom)
+	}
+	if OutputConfigHash {
+		api.OutputBytes32(c.configHash(api))
+	}
+	if OutputPoolAllowlist {
+		api.OutputBytes32(c.poolAllowlistHash(api))
+	}
+	if OutputTierTable {
+		// padded tiers are output too, with unreachable min amount and 0 discount
+		for j := range TierNum {
+			api.OutputUint(248, c.TierMinAmount[j])
+			api.OutputUint(16, c.TierDiscount[j])
+		}
+	}
+	if OutputAuditSample {
+		block, logPos, user := c.auditSample(api, in)
+		for k := range AuditSampleNum {
+			api.OutputUint32(32, block[k])
+			api.OutputUint32(32, logPos[k])
+			api.OutputAddress(user[k])
+		}
+	}
 
 	// usr trading vol
 	totalVol := [MaxUsrNum]sdk.Uint248{}
 	discount := [MaxUsrNum]sdk.Uint248{}
-	for i := range MaxUsrNum {
-		totalVol[i] = sdk.ConstUint248(0)
-		discount[i] = sdk.ConstUint248(0)
-
-		for j := range MaxPerUsr {
-			r := in.Receipts.Raw[MaxPerUsr*i+j]
-			amount := api.Int248.ABS(api.ToInt248(r.Fields[2].Value)) // swaplog2 value is amount
-			usrAddr := api.ToUint248(r.Fields[0].Value)               // hookLog value is tx.origin addr
-			totalVol[i] = api.Uint248.Select(
-				api.Uint248.IsEqual(usrAddr, c.Users[i]),
-				api.Uint248.Add(totalVol[i], amount),
-				totalVol[i])
+	volume := c.volumeMetric(api, in)
+	if OutputOtherVolume {
+		other := c.otherVolume(api, in, volume)
+		if RoundOutputVolume {
+			other = roundVolume(api, other, c.VolumePrecision)
 		}
+		api.OutputUint(248, other)
+	}
+	for i := range MaxUsrNum {
+		totalVol[i] = segmentVolume(api, in.Receipts.Raw, MaxPerUsr*i, MaxPerUsr, c.Users[i], volume)
 	}
 	// start from 2nd vol, if previous addr is the same, add prev to this
 	// so if a user has 3 segments, last one has full total vol
@@ -109,25 +578,1156 @@ func (c *UniVipHookCircuit) Define(api *sdk.CircuitAPI, in sdk.DataInput) error
 			api.Uint248.Add(totalVol[i], totalVol[i-1]),
 			totalVol[i])
 	}
+	if RequireMinBatchVolume {
+		// asserted here rather than in assertInputs to reuse the users' volumes, before penalties and tier volume
+		// gates. it only depends on receipts assertInputs checked
+		api.Uint248.AssertIsEqual(api.Uint248.IsGreaterThan(batchVolume(api, c.Users, totalVol), c.MinBatchVolume), sdk.ConstUint248(1))
+	}
+	// volume before caps and penalties
+	rawVol := totalVol
+	if OutputClampFlag && CapSwapContribution {
+		rawVol = c.userVolumes(api, in.Receipts.Raw, c.countedMetric(api, in, false))
+	}
+	if PenalizeBlockConcentration {
+		totalVol = c.penalizeConcentration(api, in.Receipts.Raw, totalVol, volume)
+	}
+	// set where a cap or penalty lowers a user's volume, each compared to its own input so a later boost or entity
+	// sum can't hide it
+	var lowered [MaxUsrNum]sdk.Uint248
+	if OutputClampFlag {
+		lowered = markLowered(api, lowered, rawVol, totalVol)
+	}
+
+	var index [MaxUsrNum]sdk.Uint248
+	if OutputUserIndex {
+		index = userIndex(api, c.Users)
+	}
 
-	// decide discount based on vol, output addr and discount
+	// vol used for tier decision, gates may lower it below a tier's min amount
+	tierVol := totalVol
+	var otherMember [MaxUsrNum]sdk.Uint248
+	if AggregateEntities {
+		tierVol, otherMember = c.entityVolume(api, totalVol)
+	}
+	if GateTierByPools {
+		adjusted := c.gateByPools(api, in.Receipts.Raw, tierVol, volume)
+		if OutputClampFlag {
+			lowered = markLowered(api, lowered, tierVol, adjusted)
+		}
+		tierVol = adjusted
+	}
+	if PenalizeFreshUsers {
+		adjusted := c.penalizeFresh(api, in, tierVol)
+		if OutputClampFlag {
+			lowered = markLowered(api, lowered, tierVol, adjusted)
+		}
+		tierVol = adjusted
+	}
+	if ReputationBoost {
+		tierVol = c.boostReputation(api, in, tierVol)
+	}
+	if CapTierJump {
+		tierVol = c.capTierJump(api, tierVol)
+	}
+
+	// decide discount based on vol
+	minAmount := c.tierMins(api)
 	for i := range MaxUsrNum {
-		for j := range TierNum {
-			discount[i] = api.Uint248.Select(
-				// if totalVol > tiermin, set discount to this tier, otherwise, keep discount unchanged
-				api.Uint248.IsGreaterThan(totalVol[i], c.TierMinAmount[j]),
-				c.TierDiscount[j],
-				discount[i])
+		discount[i] = tierDiscount(api, tierVol[i], minAmount, c.TierDiscount)
+	}
+	if PerHookConfig {
+		discount = c.perHookDiscount(api, in.Receipts.Raw, volume)
+	}
+	if StreakBonus {
+		discount = c.streakBonus(api, discount)
+	}
+	var over [MaxUsrNum]sdk.Uint248
+	if CapBatchVolume {
+		over = c.overBatchCap(api, totalVol)
+		for i := range MaxUsrNum {
+			discount[i] = api.Uint248.Select(over[i], sdk.ConstUint248(0), discount[i])
 		}
+	}
+
+	// users as output, filtered ones become padding
+	outUser := c.Users
+	if FilterMinOutputTier {
+		for i := range MaxUsrNum {
+			below := api.Uint248.IsLessThan(tierLevel(api, tierVol[i], minAmount), c.MinOutputTier)
+			outUser[i] = api.Uint248.Select(below, sdk.ConstUint248(0), outUser[i])
+			discount[i] = api.Uint248.Select(below, sdk.ConstUint248(0), discount[i])
+		}
+	}
+	if AggregateEntities {
+		// one row per entity, its other members are padding
+		for i := range MaxUsrNum {
+			outUser[i] = api.Uint248.Select(otherMember[i], sdk.ConstUint248(0), outUser[i])
+			discount[i] = api.Uint248.Select(otherMember[i], sdk.ConstUint248(0), discount[i])
+		}
+	}
+	var shareCapped [MaxUsrNum]sdk.Uint248
+	if CapRewardShare {
+		discount, shareCapped = c.capRewardShare(api, totalVol, discount)
+	}
+
+	var share [MaxUsrNum]sdk.Uint248
+	if OutputVolumeShare {
+		share = volumeShare(api, c.Users, totalVol)
+	}
+	var score [MaxUsrNum]sdk.Uint248
+	if OutputVolumeScore {
+		score = volumeScore(api, totalVol)
+	}
+
+	var clamped [MaxUsrNum]sdk.Uint248
+	if OutputClampFlag {
+		for i := range MaxUsrNum {
+			clamped[i] = api.Uint248.Or(lowered[i], over[i])
+			if CapRewardShare {
+				clamped[i] = api.Uint248.Or(clamped[i], shareCapped[i])
+			}
+		}
+	}
+	var matched [MaxUsrNum]sdk.Uint248
+	if OutputMatchedVolume {
+		matched = c.matchedVolume(api, in.Receipts.Raw, volume)
+	}
+	var gasVol [MaxUsrNum]sdk.Uint248
+	if OutputGasWeightedVolume {
+		gasVol = c.userVolumes(api, in.Receipts.Raw, func(idx int, r sdk.Receipt) sdk.Uint248 {
+			return api.Uint248.Mul(volume(idx, r), hookGas(api, r))
+		})
+	}
+	var multiplier [MaxUsrNum]sdk.Uint248
+	if CountTiers {
+		multiplier = c.countMultipliers(api, in)
+	}
+	var effective [MaxUsrNum]sdk.Uint248
+	if OutputEffectiveDiscount {
+		effective = c.effectiveDiscounts(api, discount, multiplier)
+	}
+	var qualified [MaxUsrNum]sdk.Uint248
+	if outputQualifiedTiers {
+		for i := range MaxUsrNum {
+			qualified[i] = qualifiedTiers(api, tierVol[i], minAmount)
+		}
+	}
+	var firstBlock, lastBlock [MaxUsrNum]sdk.Uint248
+	if OutputBlockRange {
+		firstBlock, lastBlock = c.userBlockRanges(api, in.Receipts)
+	}
+	var gap [MaxUsrNum]sdk.Uint248
+	if OutputNextTierGap {
+		for i := range MaxUsrNum {
+			gap[i] = nextTierGap(api, tierVol[i], minAmount)
+		}
+	}
+
+	if OutputTierHistogram {
+		var level [MaxUsrNum]sdk.Uint248
+		for i := range MaxUsrNum {
+			level[i] = tierLevel(api, tierVol[i], minAmount)
+			if CapBatchVolume {
+				// capped users get no discount, count them as no tier
+				level[i] = api.Uint248.Select(over[i], sdk.ConstUint248(0), level[i])
+			}
+		}
+		for _, n := range tierHistogram(api, c.Users, level) {
+			api.OutputUint(32, n)
+		}
+	}
+
+	if DeltaAddresses {
+		api.OutputAddress(c.Users[0])
+	}
+
+	if OutputTotalDiscount {
+		// earlier slots of a split user have partial totals, only its final slot counts
+		total := sdk.ConstUint248(0)
+		for i, final := range finalSlots(api, c.Users) {
+			if TopTierOnly {
+				// only users who get a row below
+				final = api.Uint248.And(final, api.Uint248.IsEqual(tierLevel(api, tierVol[i], minAmount), sdk.ConstUint248(TierNum)))
+			}
+			total = api.Uint248.Add(total, api.Uint248.Select(final, discount[i], sdk.ConstUint248(0)))
+		}
+		api.OutputUint(32, total)
+	}
+
+	var flowRate [MaxUsrNum]sdk.Uint248
+	if OutputFlowRate {
+		flowRate = c.flowRates(api, totalVol, discount)
+	}
+
+	nonce := c.ClaimNonce
+
+	var keep [MaxUsrNum]sdk.Uint248
+	if GateLowestTier || OutputResultCount || TopTierOnly {
+		// one row per eligible user at its final slot, in slot order, padding rows follow
+		final := finalSlots(api, c.Users)
+		for i := range MaxUsrNum {
+			keep[i] = api.Uint248.And(final[i], api.Uint248.Not(api.Uint248.IsZero(outUser[i])))
+			if GateLowestTier {
+				keep[i] = api.Uint248.And(keep[i], api.Uint248.Not(api.Uint248.IsZero(tierLevel(api, tierVol[i], minAmount))))
+			}
+			if TopTierOnly {
+				keep[i] = api.Uint248.And(keep[i], api.Uint248.IsEqual(tierLevel(api, tierVol[i], minAmount), sdk.ConstUint248(TierNum)))
+			}
+		}
+		outUser, discount, tierVol = compact(api, keep, outUser), compact(api, keep, discount), compact(api, keep, tierVol)
+		if OutputUserIndex {
+			index = compact(api, keep, index)
+		}
+		if OutputVolumeShare {
+			share = compact(api, keep, share)
+		}
+		if OutputNextTierGap {
+			gap = compact(api, keep, gap)
+		}
+		if OutputVolumeScore {
+			score = compact(api, keep, score)
+		}
+		if OutputClampFlag {
+			clamped = compact(api, keep, clamped)
+		}
+		if OutputBlockRange {
+			firstBlock, lastBlock = compact(api, keep, firstBlock), compact(api, keep, lastBlock)
+		}
+		if OutputMatchedVolume {
+			matched = compact(api, keep, matched)
+		}
+		if CountTiers {
+			multiplier = compact(api, keep, multiplier)
+		}
+		if OutputEffectiveDiscount {
+			effective = compact(api, keep, effective)
+		}
+		if OutputGasWeightedVolume {
+			gasVol = compact(api, keep, gasVol)
+		}
+		if OutputClaimHash {
+			nonce = compact(api, keep, nonce)
+		}
+		if outputQualifiedTiers {
+			qualified = compact(api, keep, qualified)
+		}
+		if OutputFlowRate {
+			flowRate = compact(api, keep, flowRate)
+		}
+	}
+
+	// last header outputs, they need final discounts
+	if OutputMerkleRoot {
+		api.OutputBytes32(userMerkleRoot(api, outUser, discount, tierVol))
+	}
+	if OutputResultCount {
+		count := sdk.ConstUint248(0)
+		for _, k := range keep {
+			count = api.Uint248.Add(count, k)
+		}
+		api.OutputUint(32, count)
+	}
+
+	if OutputRequestedUsers {
+		for _, u := range c.RequestedUsers {
+			api.OutputAddress(u)
+			api.OutputUint(16, requestedValue(api, outUser, u, discount))
+		}
+		return nil
+	}
+
+	// output addr and discount
+	for i := range MaxUsrNum {
 		fmt.Println("account: ", c.Users[i], "total volume: ", totalVol[i])
 
-		api.OutputAddress(c.Users[i])
+		if OutputUserCommitment {
+			api.OutputBytes32(userCommitment(api, outUser[i], c.Salt))
+		} else if DeltaAddresses {
+			api.OutputUint(AddressDeltaBits, addressDelta(api, c.Users, i))
+		} else {
+			api.OutputAddress(outUser[i])
+		}
+		if OutputUserIndex {
+			api.OutputUint(32, index[i])
+		}
 		api.OutputUint(16, discount[i])
+		if OutputMerkleRoot && MerkleLeafEncoding == LeafAddressDiscountVolume {
+			api.OutputUint(248, tierVol[i])
+		}
+		if OutputVolumeShare {
+			api.OutputUint(16, share[i])
+		}
+		if OutputNextTierGap {
+			api.OutputUint(248, gap[i])
+		}
+		if OutputVolumeScore {
+			api.OutputUint(16, score[i])
+		}
+		if OutputClampFlag {
+			api.OutputUint(8, clamped[i])
+		}
+		if OutputBlockRange {
+			api.OutputUint(32, firstBlock[i])
+			api.OutputUint(32, lastBlock[i])
+		}
+		if OutputMatchedVolume {
+			api.OutputUint(248, matched[i])
+		}
+		if CountTiers {
+			api.OutputUint(16, multiplier[i])
+		}
+		if outputQualifiedTiers {
+			api.OutputUint(qualifiedTiersBits, qualified[i])
+		}
+		if OutputFlowRate {
+			api.OutputUint(96, flowRate[i])
+		}
+		if OutputEffectiveDiscount {
+			api.OutputUint(16, effective[i])
+		}
+		if OutputGasWeightedVolume {
+			api.OutputUint(248, gasVol[i])
+		}
+		if OutputClaimHash {
+			api.OutputBytes32(c.claimHash(api, outUser[i], discount[i], nonce[i]))
+		}
 	}
 
 	return nil
 }
 
+// tierMins returns TierMinAmount as compared with vol > min: inclusive tiers' min amount is lowered by 1, Validate
+// rejects inclusive tiers with min amount 0
+func (c *UniVipHookCircuit) tierMins(api *sdk.CircuitAPI) [TierNum]sdk.Uint248 {
+	return inclusiveMins(api, c.TierMinAmount, c.TierInclusive)
+}
+
+// configHash is keccak256 of configInputs, see ConfigHash
+func (c *UniVipHookCircuit) configHash(api *sdk.CircuitAPI) sdk.Bytes32 {
+	return c.configInputs().keccak(api)
+}
+
+// batchInputs are the inputs Assign lays out per user slot from the receipts, they're the batch rather than its config
+var batchInputs = map[string]bool{"Users": true, "EntityIds": true, "PriorTier": true, "ClaimNonce": true, "StreakLength": true}
+
+// configInputs packs every input of c but batchInputs in field order, then OptionFlags() as a uint248. fields are
+// walked by reflection, so an input added to the circuit is hashed without being listed here
+func (c *UniVipHookCircuit) configInputs() *packed {
+	p := new(packed)
+	v := reflect.ValueOf(c).Elem()
+	for i := range v.NumField() {
+		if !batchInputs[v.Type().Field(i).Name] {
+			p.value(v.Field(i))
+		}
+	}
+	return p.uint(sdk.ConstUint248(OptionFlags()), 248)
+}
+
+// claimHash is keccak256(abi.encodePacked(address account, uint16 discount, uint32 epoch, uint64 nonce)), with
+// EpochLabel bytes32 epoch is the label the header outputs. 0 for padding like userCommitment
+func (c *UniVipHookCircuit) claimHash(api *sdk.CircuitAPI, addr, discount, nonce sdk.Uint248) sdk.Bytes32 {
+	p := new(packed).uint(addr, 160).uint(discount, 16)
+	if EpochLabel {
+		p.bytes32(c.EpochLabel)
+	} else {
+		p.uint32(c.Epoch)
+	}
+	return api.Bytes32.Select(api.Uint248.IsZero(addr), sdk.ConstBytes32(nil), p.uint(nonce, 64).keccak(api))
+}
+
+// poolAllowlistHash is keccak256 of pools() ids packed, unused slots are 0. receipts of any other pool already fail
+// the receipt checks. it's a commitment only, no signature is checked: the contract compares it with the allowlist
+// the program signed off chain
+func (c *UniVipHookCircuit) poolAllowlistHash(api *sdk.CircuitAPI) sdk.Bytes32 {
+	ids, _ := c.pools()
+	p := new(packed)
+	for _, id := range ids {
+		p.bytes32(id)
+	}
+	return p.keccak(api)
+}
+
+// acceptedBlocks is the block range receipts are asserted in. with OutputOutOfRangeCount any block passes,
+// receiptFilter drops the ones outside (BlockStart, BlockEnd) instead
+func (c *UniVipHookCircuit) acceptedBlocks() (blockStart, blockEnd sdk.Uint32) {
+	if OutputOutOfRangeCount {
+		return sdk.ConstUint32(0), sdk.ConstUint32(math.MaxUint32)
+	}
+	return c.BlockStart, c.BlockEnd
+}
+
+// inRange returns 1 if receipt j is toggled and in the block range, what counts as a receipt of the batch. without
+// OutputOutOfRangeCount that's every toggled receipt, AssertEach checked the range
+func (c *UniVipHookCircuit) inRange(api *sdk.CircuitAPI, in sdk.DataInput, j int) sdk.Uint248 {
+	on := sdk.Uint248{Val: in.Receipts.Toggles[j]}
+	if !OutputOutOfRangeCount {
+		return on
+	}
+	return api.Uint248.And(on, api.ToUint248(inBlockRange(api, in.Receipts.Raw[j].BlockNum, c.BlockStart, c.BlockEnd)))
+}
+
+// outOfRangeCount returns the number of toggled receipts outside the block range, which passed every other receipt
+// check. a non-zero count usually means the fetching window doesn't match the epoch
+func (c *UniVipHookCircuit) outOfRangeCount(api *sdk.CircuitAPI, in sdk.DataInput) sdk.Uint248 {
+	count := sdk.ConstUint248(0)
+	for j, r := range in.Receipts.Raw {
+		out := api.Uint248.And(
+			sdk.Uint248{Val: in.Receipts.Toggles[j]},
+			api.Uint248.Not(api.ToUint248(inBlockRange(api, r.BlockNum, c.BlockStart, c.BlockEnd))))
+		count = api.Uint248.Add(count, out)
+	}
+	return count
+}
+
+// auditSample returns block, swap log position and user of AuditSampleNum receipts, see inRange. sample k targets block
+// BlockStart + keccak256(epoch|blockStart|blockEnd|uint8 k) % (BlockEnd - BlockStart) and picks the first receipt at
+// or after it, wrapping around at 2^32, ties going to the lower index. the same batch always samples the same receipts,
+// and Simulate computes them the same way. samples are all 0 if there are no receipts, and may repeat
+func (c *UniVipHookCircuit) auditSample(api *sdk.CircuitAPI, in sdk.DataInput) (block, logPos [AuditSampleNum]sdk.Uint32, user [AuditSampleNum]sdk.Uint248) {
+	span := api.ToUint248(api.Uint32.Sub(c.BlockEnd, c.BlockStart))
+	for k := range AuditSampleNum {
+		seed := new(packed).uint32(c.Epoch).uint32(c.BlockStart).uint32(c.BlockEnd).uint(sdk.ConstUint248(k), 8).keccak(api)
+		_, offset := api.Uint248.Div(sdk.Uint248{Val: seed.Val[0]}, span)
+		target := api.Uint248.Add(api.ToUint248(c.BlockStart), offset)
+		best := sdk.ConstUint248(maxUint248)
+		block[k], logPos[k], user[k] = sdk.ConstUint32(0), sdk.ConstUint32(0), sdk.ConstUint248(0)
+		for j, r := range in.Receipts.Raw {
+			b := api.ToUint248(r.BlockNum)
+			wrap := api.Uint248.Select(api.Uint248.IsLessThan(b, target), sdk.ConstUint248(1<<32), sdk.ConstUint248(0))
+			dist := api.Uint248.Sub(api.Uint248.Add(b, wrap), target)
+			key := api.Uint248.Add(api.Uint248.Mul(dist, sdk.ConstUint248(MaxReceipts)), sdk.ConstUint248(j))
+			better := api.Uint248.And(c.inRange(api, in, j), api.Uint248.IsLessThan(key, best))
+			best = api.Uint248.Select(better, key, best)
+			block[k] = api.Uint32.Select(api.ToUint32(better), r.BlockNum, block[k])
+			logPos[k] = api.Uint32.Select(api.ToUint32(better), r.Fields[1].LogPos, logPos[k])
+			user[k] = api.Uint248.Select(better, receiptUser(api, r), user[k])
+		}
+	}
+	return block, logPos, user
+}
+
+// v3SwapOK returns 1 if r is a v3 Swap of one of V3PoolAddrs in the block range. Fields[0] and [1] are
+// both its recipient topic, the user, and Fields[2] its amount0, all from the same log
+func (c *UniVipHookCircuit) v3SwapOK(api *sdk.CircuitAPI, r sdk.Receipt, blockStart, blockEnd sdk.Uint32) sdk.Uint248 {
+	user, dup, amount := r.Fields[0], r.Fields[1], r.Fields[2]
+	known := sdk.ConstUint248(0)
+	for _, p := range c.V3PoolAddrs {
+		// unused slots are 0, no log comes from addr 0
+		known = api.Uint248.Or(known, api.Uint248.IsEqual(user.Contract, p))
+	}
+	sameLog := func(f sdk.LogField) sdk.Uint248 {
+		return api.Uint248.And(
+			api.ToUint248(api.Uint32.IsEqual(f.LogPos, user.LogPos)),
+			api.Uint248.IsEqual(f.Contract, user.Contract),
+			api.Uint248.IsEqual(f.EventID, EventIdUniSwapV3))
+	}
+	return api.Uint248.And(
+		api.ToUint248(inBlockRange(api, r.BlockNum, blockStart, blockEnd)),
+		known,
+		sameLog(user), sameLog(dup), sameLog(amount),
+		api.Uint248.IsEqual(user.IsTopic, sdk.ConstUint248(1)),
+		api.Uint248.IsEqual(user.Index, sdk.ConstUint248(V3RecipientTopicIndex)),
+		api.Uint248.IsEqual(dup.IsTopic, sdk.ConstUint248(1)),
+		api.Uint248.IsEqual(dup.Index, sdk.ConstUint248(V3RecipientTopicIndex)),
+		api.Uint248.IsZero(amount.IsTopic),
+		api.Uint248.IsEqual(amount.Index, sdk.ConstUint248(AmountDataIndex)),
+	)
+}
+
+// pools returns all configured pools and hooks, PoolId first
+func (c *UniVipHookCircuit) pools() (ids [MaxPoolNum]sdk.Bytes32, hooks [MaxPoolNum]sdk.Uint248) {
+	ids[0], hooks[0] = c.PoolId, c.HookAddr
+	for m := 1; m < MaxPoolNum; m++ {
+		ids[m], hooks[m] = c.ExtraPoolIds[m-1], c.ExtraHookAddrs[m-1]
+	}
+	return ids, hooks
+}
+
+// anyPool returns 1 if r is from one of the configured pools with its hook, the hook's event layout and the pool's
+// amount field. unused slots are 0 which never matches as no hook log comes from addr 0
+func (c *UniVipHookCircuit) anyPool(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	ids, hooks := c.pools()
+	ret := sdk.ConstUint248(0)
+	for m := range MaxPoolNum {
+		amountIdx := sdk.ConstUint248(AmountDataIndex)
+		if CanonicalVolumeToken {
+			amountIdx = c.PoolAmountIndex[m]
+		}
+		eventId, originIdx := EventIdHook, sdk.ConstUint248(OriginTopicIndex)
+		if PerHookConfig {
+			eventId, originIdx = c.HookEventIds[m], c.HookOriginIndex[m]
+		}
+		ret = api.Uint248.Or(ret, api.Uint248.And(
+			isPool(api, r, ids[m], hooks[m]),
+			hookLogOK(api, r, eventId, originIdx),
+			api.Uint248.IsEqual(r.Fields[2].Index, amountIdx)))
+	}
+	return ret
+}
+
+// poolVolumes returns each user's summed metric per pool, see pools(). like totalVol, a split user's last slot
+// holds its full total
+func (c *UniVipHookCircuit) poolVolumes(api *sdk.CircuitAPI, raw []sdk.Receipt, metric Metric) (vol [MaxPoolNum][MaxUsrNum]sdk.Uint248) {
+	ids, hooks := c.pools()
+	for m := range MaxPoolNum {
+		vol[m] = c.userVolumes(api, raw, func(idx int, r sdk.Receipt) sdk.Uint248 {
+			return api.Uint248.Select(isPool(api, r, ids[m], hooks[m]), metric(idx, r), sdk.ConstUint248(0))
+		})
+	}
+	return vol
+}
+
+// matchedVolume returns each slot's min(bought, sold), summing metric of swaps with amount0 >= 0 as bought and < 0
+// as sold, with a split user's slots carried like totalVol. any buy pairs with any sell of the same epoch regardless
+// of order, so this is volume that went both ways
+func (c *UniVipHookCircuit) matchedVolume(api *sdk.CircuitAPI, raw []sdk.Receipt, metric Metric) (matched [MaxUsrNum]sdk.Uint248) {
+	zero := sdk.ConstInt248(big.NewInt(0))
+	side := func(sold bool) Metric {
+		return func(idx int, r sdk.Receipt) sdk.Uint248 {
+			neg := api.Int248.IsLessThan(api.ToInt248(r.Fields[2].Value), zero)
+			if !sold {
+				neg = api.Uint248.Not(neg)
+			}
+			return api.Uint248.Select(neg, metric(idx, r), sdk.ConstUint248(0))
+		}
+	}
+	bought, sold := c.userVolumes(api, raw, side(false)), c.userVolumes(api, raw, side(true))
+	for i := range MaxUsrNum {
+		matched[i] = api.Uint248.Select(api.Uint248.IsLessThan(bought[i], sold[i]), bought[i], sold[i])
+	}
+	return matched
+}
+
+// userBlockRanges returns each slot's first and last receipt block, over all slots of a split user up to it like
+// totalVol. 0 if the user has no receipts
+func (c *UniVipHookCircuit) userBlockRanges(api *sdk.CircuitAPI, receipts sdk.DataPoints[sdk.Receipt]) (first, last [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		f, l := segmentBlockRange(api, receipts, MaxPerUsr*i, MaxPerUsr, c.Users[i])
+		first[i], last[i] = api.ToUint248(f), api.ToUint248(l)
+		if i > 0 {
+			same := api.Uint248.IsEqual(c.Users[i-1], c.Users[i])
+			// 0 is no receipts, the other slot decides
+			prevFirst := api.Uint248.And(same, api.Uint248.Not(api.Uint248.IsZero(first[i-1])),
+				api.Uint248.Or(api.Uint248.IsZero(first[i]), api.Uint248.IsLessThan(first[i-1], first[i])))
+			first[i] = api.Uint248.Select(prevFirst, first[i-1], first[i])
+			last[i] = api.Uint248.Select(api.Uint248.And(same, api.Uint248.IsGreaterThan(last[i-1], last[i])), last[i-1], last[i])
+		}
+	}
+	return first, last
+}
+
+// userVolumes returns each slot's summed metric, carried over a split user's slots like totalVol
+func (c *UniVipHookCircuit) userVolumes(api *sdk.CircuitAPI, raw []sdk.Receipt, metric Metric) (vol [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		vol[i] = segmentVolume(api, raw, MaxPerUsr*i, MaxPerUsr, c.Users[i], metric)
+		if i > 0 {
+			vol[i] = api.Uint248.Select(
+				api.Uint248.IsEqual(c.Users[i-1], c.Users[i]),
+				api.Uint248.Add(vol[i], vol[i-1]),
+				vol[i])
+		}
+	}
+	return vol
+}
+
+// perHookDiscount tiers each user's volume in every pool on that pool's tier table and returns the best discount
+func (c *UniVipHookCircuit) perHookDiscount(api *sdk.CircuitAPI, raw []sdk.Receipt, metric Metric) (discount [MaxUsrNum]sdk.Uint248) {
+	poolVol := c.poolVolumes(api, raw, metric)
+	minAmount := c.tierMins(api)
+	for i := range MaxUsrNum {
+		discount[i] = tierDiscount(api, poolVol[0][i], minAmount, c.TierDiscount)
+		for m := 1; m < MaxPoolNum; m++ {
+			d := tierDiscount(api, poolVol[m][i], c.ExtraTierMinAmount[m-1], c.ExtraTierDiscount[m-1])
+			discount[i] = api.Uint248.Select(api.Uint248.IsGreaterThan(d, discount[i]), d, discount[i])
+		}
+	}
+	return discount
+}
+
+// gateByPools clamps tierVol of users who traded in fewer than MinPools distinct pools to the min amount of
+// tier MultiPoolTier, so they stay below it. a pool counts if the user has non-zero volume in it
+func (c *UniVipHookCircuit) gateByPools(api *sdk.CircuitAPI, raw []sdk.Receipt, tierVol [MaxUsrNum]sdk.Uint248, metric Metric) [MaxUsrNum]sdk.Uint248 {
+	// min amount of the gated tier, level is 1 based
+	gateMin := sdk.ConstUint248(0)
+	minAmount := c.tierMins(api)
+	for j := range TierNum {
+		gateMin = api.Uint248.Select(api.Uint248.IsEqual(c.MultiPoolTier, sdk.ConstUint248(j+1)), minAmount[j], gateMin)
+	}
+	numPools := [MaxUsrNum]sdk.Uint248{}
+	for _, poolVol := range c.poolVolumes(api, raw, metric) {
+		for i := range MaxUsrNum {
+			numPools[i] = api.Uint248.Add(numPools[i], api.Uint248.Not(api.Uint248.IsZero(poolVol[i])))
+		}
+	}
+	for i := range MaxUsrNum {
+		gated := api.Uint248.And(
+			api.Uint248.Not(api.Uint248.IsZero(c.MultiPoolTier)),
+			api.Uint248.IsLessThan(numPools[i], c.MinPools),
+			api.Uint248.IsGreaterThan(tierVol[i], gateMin))
+		tierVol[i] = api.Uint248.Select(gated, gateMin, tierVol[i])
+	}
+	return tierVol
+}
+
+// capTierJump clamps tierVol of users above level PriorTier+1 to the min amount of the tier after it, like
+// gateByPools, so they land at PriorTier+1. users at or below it, or with nothing above it, keep theirs
+func (c *UniVipHookCircuit) capTierJump(api *sdk.CircuitAPI, tierVol [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	minAmount := c.tierMins(api)
+	for i := range MaxUsrNum {
+		// level PriorTier+1 is at most minAmount[PriorTier+1]
+		capAmount := sdk.ConstUint248(maxUint248)
+		for j := 0; j+1 < TierNum; j++ {
+			capAmount = api.Uint248.Select(api.Uint248.IsEqual(c.PriorTier[i], sdk.ConstUint248(j)), minAmount[j+1], capAmount)
+		}
+		tierVol[i] = api.Uint248.Select(api.Uint248.IsGreaterThan(tierVol[i], capAmount), capAmount, tierVol[i])
+	}
+	return tierVol
+}
+
+// penalizeFresh scales tierVol by FreshPenaltyBps for users with no transaction proof, at any of their slots' index,
+// of a tx they sent before AgeCutoffBlock. an address with such a tx existed and was active before the cutoff
+func (c *UniVipHookCircuit) penalizeFresh(api *sdk.CircuitAPI, in sdk.DataInput, tierVol [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	start := transactionSlots().AgeProof
+	established := [MaxUsrNum]sdk.Uint248{}
+	for i := range MaxUsrNum {
+		tx := in.Transactions.Raw[start+i]
+		established[i] = api.Uint248.And(
+			sdk.Uint248{Val: in.Transactions.Toggles[start+i]},
+			api.Uint248.IsEqual(tx.From, c.Users[i]),
+			api.ToUint248(api.Uint32.IsLessThan(tx.BlockNum, c.AgeCutoffBlock)),
+		)
+		// carry to later slots of the same user, last slot decides tier
+		if i > 0 {
+			established[i] = api.Uint248.Or(established[i],
+				api.Uint248.And(api.Uint248.IsEqual(c.Users[i-1], c.Users[i]), established[i-1]))
+		}
+		penalized, _ := api.Uint248.Div(api.Uint248.Mul(tierVol[i], c.FreshPenaltyBps), sdk.ConstUint248(BpsDenom))
+		tierVol[i] = api.Uint248.Select(established[i], tierVol[i], penalized)
+	}
+	return tierVol
+}
+
+// countMultipliers tiers each user's number of counted swaps on the count tier table, independently of the volume
+// tiers. a split user's slots carry its count like totalVol. count tiers are never marginal
+func (c *UniVipHookCircuit) countMultipliers(api *sdk.CircuitAPI, in sdk.DataInput) (multiplier [MaxUsrNum]sdk.Uint248) {
+	counted := c.receiptFilter(api, in)
+	swaps := c.userVolumes(api, in.Receipts.Raw, func(idx int, r sdk.Receipt) sdk.Uint248 {
+		return api.Uint248.And(sdk.Uint248{Val: in.Receipts.Toggles[idx]}, counted(idx, r))
+	})
+	for i := range MaxUsrNum {
+		multiplier[i] = sdk.ConstUint248(BpsDenom)
+		for j := range TierNum {
+			multiplier[i] = api.Uint248.Select(
+				api.Uint248.IsGreaterThan(swaps[i], c.CountTierMinSwaps[j]), c.CountTierMultiplier[j], multiplier[i])
+		}
+	}
+	return multiplier
+}
+
+// effectiveDiscounts scales each final discount by its multiplier in bps, capped at DiscountDenom like streakBonus.
+// discount must be final, after caps and output filters, so a zero discount stays zero
+func (c *UniVipHookCircuit) effectiveDiscounts(api *sdk.CircuitAPI, discount, multiplier [MaxUsrNum]sdk.Uint248) (effective [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		scaled, _ := api.Uint248.Div(api.Uint248.Mul(discount[i], multiplier[i]), sdk.ConstUint248(BpsDenom))
+		effective[i] = api.Uint248.Select(api.Uint248.IsGreaterThan(scaled, c.DiscountDenom), c.DiscountDenom, scaled)
+	}
+	return effective
+}
+
+// eoaUsers returns 1 for user slots with a transaction proof, at any of their slots' index, of a tx the user sent.
+// only an EOA can send a tx, the SDK has no account proofs to read code directly
+func (c *UniVipHookCircuit) eoaUsers(api *sdk.CircuitAPI, in sdk.DataInput) (eoa [MaxUsrNum]sdk.Uint248) {
+	start := transactionSlots().EOAProof
+	for i := range MaxUsrNum {
+		eoa[i] = api.Uint248.And(
+			sdk.Uint248{Val: in.Transactions.Toggles[start+i]},
+			api.Uint248.IsEqual(in.Transactions.Raw[start+i].From, c.Users[i]))
+		if i > 0 {
+			eoa[i] = api.Uint248.Or(eoa[i], api.Uint248.And(api.Uint248.IsEqual(c.Users[i-1], c.Users[i]), eoa[i-1]))
+		}
+	}
+	// carried forward to a user's last slot, then back to its earlier ones
+	return propagateBack(api, c.Users, eoa)
+}
+
+// otherVolume sums metric over toggled receipts whose tx.origin isn't their segment's user, ie. non-VIP volume
+func (c *UniVipHookCircuit) otherVolume(api *sdk.CircuitAPI, in sdk.DataInput, metric Metric) sdk.Uint248 {
+	vol := sdk.ConstUint248(0)
+	for j, r := range in.Receipts.Raw {
+		other := api.Uint248.And(
+			sdk.Uint248{Val: in.Receipts.Toggles[j]},
+			api.Uint248.Not(api.Uint248.IsEqual(receiptUser(api, r), c.Users[j/MaxPerUsr])))
+		vol = api.Uint248.Select(other, api.Uint248.Add(vol, metric(j, r)), vol)
+	}
+	return vol
+}
+
+// entityVolume returns, for slots with an entity, the summed volume of all users of that entity, using their last
+// slots. an entity is output once, by the member with its last slot, and other is 1 at other members' slots
+func (c *UniVipHookCircuit) entityVolume(api *sdk.CircuitAPI, totalVol [MaxUsrNum]sdk.Uint248) (vol, other [MaxUsrNum]sdk.Uint248) {
+	final := finalSlots(api, c.Users)
+	for i := range MaxUsrNum {
+		sum, lead := sdk.ConstUint248(0), c.Users[i]
+		for j := range MaxUsrNum {
+			same := api.Uint248.And(final[j], api.Uint248.IsEqual(c.EntityIds[i], c.EntityIds[j]))
+			sum = api.Uint248.Select(same, api.Uint248.Add(sum, totalVol[j]), sum)
+			lead = api.Uint248.Select(same, c.Users[j], lead)
+		}
+		single := api.Uint248.IsZero(c.EntityIds[i])
+		vol[i] = api.Uint248.Select(single, totalVol[i], sum)
+		other[i] = api.Uint248.And(api.Uint248.Not(single), api.Uint248.Not(api.Uint248.IsEqual(c.Users[i], lead)))
+	}
+	return vol, other
+}
+
+// streakBonus scales each discount by 1 + min(StreakLength * StreakBonusBps, MaxStreakBonusBps)/BpsDenom, capped at
+// DiscountDenom. a streak of 1, only this epoch, gets no bonus
+func (c *UniVipHookCircuit) streakBonus(api *sdk.CircuitAPI, discount [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	for i := range MaxUsrNum {
+		past := api.Uint248.Select(api.Uint248.IsZero(c.StreakLength[i]), sdk.ConstUint248(0), api.Uint248.Sub(c.StreakLength[i], sdk.ConstUint248(1)))
+		bonus := api.Uint248.Mul(past, c.StreakBonusBps)
+		bonus = api.Uint248.Select(api.Uint248.IsGreaterThan(bonus, c.MaxStreakBonusBps), c.MaxStreakBonusBps, bonus)
+		scaled, _ := api.Uint248.Div(api.Uint248.Mul(discount[i], api.Uint248.Add(sdk.ConstUint248(BpsDenom), bonus)), sdk.ConstUint248(BpsDenom))
+		discount[i] = api.Uint248.Select(api.Uint248.IsGreaterThan(scaled, c.DiscountDenom), c.DiscountDenom, scaled)
+	}
+	return discount
+}
+
+// isSelfTrade returns 1 if addr is one of SelfTradeAddrs. zero slots only match zero addr which is never a real origin
+func (c *UniVipHookCircuit) isSelfTrade(api *sdk.CircuitAPI, addr sdk.Uint248) sdk.Uint248 {
+	ret := sdk.ConstUint248(0)
+	for k := range MaxSelfTradeAddrs {
+		ret = api.Uint248.Or(ret, api.Uint248.IsEqual(addr, c.SelfTradeAddrs[k]))
+	}
+	return ret
+}
+
+// overBatchCap returns 1 for users whose volume, added after all higher ranked users, goes past BatchVolumeCap.
+// only last slot of each user has full vol so only those are ranked, equal vol is ranked by slot order
+func (c *UniVipHookCircuit) overBatchCap(api *sdk.CircuitAPI, totalVol [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	final := finalSlots(api, c.Users)
+	over := [MaxUsrNum]sdk.Uint248{}
+	for i := range MaxUsrNum {
+		before := sdk.ConstUint248(0)
+		for j := range MaxUsrNum {
+			if j == i {
+				continue
+			}
+			higher := api.Uint248.IsGreaterThan(totalVol[j], totalVol[i])
+			if j < i {
+				higher = api.Uint248.Or(higher, api.Uint248.IsEqual(totalVol[j], totalVol[i]))
+			}
+			before = api.Uint248.Select(api.Uint248.And(final[j], higher), api.Uint248.Add(before, totalVol[j]), before)
+		}
+		over[i] = api.Uint248.IsGreaterThan(api.Uint248.Add(before, totalVol[i]), c.BatchVolumeCap)
+	}
+	return propagateBack(api, c.Users, over)
+}
+
+// penalizeConcentration scales totalVol by ConcentrationPenaltyBps for users whose max single block volume
+// is more than ConcentrationBps of their total. blocks are only merged within a segment, for a split user
+// the max over its segments is used
+func (c *UniVipHookCircuit) penalizeConcentration(api *sdk.CircuitAPI, raw []sdk.Receipt, totalVol [MaxUsrNum]sdk.Uint248, metric Metric) [MaxUsrNum]sdk.Uint248 {
+	maxBlock := [MaxUsrNum]sdk.Uint248{}
+	for i := range MaxUsrNum {
+		maxBlock[i] = segmentMaxBlockVolume(api, raw, MaxPerUsr*i, MaxPerUsr, c.Users[i], metric)
+		if i > 0 {
+			maxBlock[i] = api.Uint248.Select(
+				api.Uint248.And(api.Uint248.IsEqual(c.Users[i-1], c.Users[i]), api.Uint248.IsGreaterThan(maxBlock[i-1], maxBlock[i])),
+				maxBlock[i-1],
+				maxBlock[i])
+		}
+	}
+	for i := range MaxUsrNum {
+		concentrated := api.Uint248.IsGreaterThan(
+			api.Uint248.Mul(maxBlock[i], sdk.ConstUint248(BpsDenom)),
+			api.Uint248.Mul(totalVol[i], c.ConcentrationBps))
+		penalized, _ := api.Uint248.Div(api.Uint248.Mul(totalVol[i], c.ConcentrationPenaltyBps), sdk.ConstUint248(BpsDenom))
+		totalVol[i] = api.Uint248.Select(concentrated, penalized, totalVol[i])
+	}
+	return totalVol
+}
+
+// receiptFilter returns 1 for receipts that count towards user totals, 0 for ones filtered out by enabled options
+func (c *UniVipHookCircuit) receiptFilter(api *sdk.CircuitAPI, in sdk.DataInput) func(idx int, r sdk.Receipt) sdk.Uint248 {
+	var liquid []sdk.Uint248
+	if CheckPoolLiquidity {
+		liquid = c.liquidityOK(api, in)
+	}
+	var optedIn, optInBlock [MaxUsrNum]sdk.Uint248
+	if RequireOptIn {
+		optedIn, optInBlock = c.optIns(api, in)
+	}
+	var eoa [MaxUsrNum]sdk.Uint248
+	if EOAUsersOnly {
+		eoa = c.eoaUsers(api, in)
+	}
+	return func(idx int, r sdk.Receipt) sdk.Uint248 {
+		ok := sdk.ConstUint248(1)
+		if ExcludeSelfTrades {
+			ok = api.Uint248.And(ok, api.Uint248.Not(c.isSelfTrade(api, receiptUser(api, r))))
+		}
+		if CheckPoolLiquidity {
+			ok = api.Uint248.And(ok, liquid[idx])
+		}
+		if FilterDustSwaps {
+			ok = api.Uint248.And(ok, c.aboveDust(api, r))
+		}
+		if TickRange {
+			ok = api.Uint248.And(ok, c.inTickRange(api, r))
+		}
+		if OutputOutOfRangeCount {
+			ok = api.Uint248.And(ok, api.ToUint248(inBlockRange(api, r.BlockNum, c.BlockStart, c.BlockEnd)))
+		}
+		if EOAUsersOnly {
+			ok = api.Uint248.And(ok, eoa[idx/MaxPerUsr])
+		}
+		if TxAllowlist {
+			ok = api.Uint248.And(ok, c.allowedTx(api, r))
+		}
+		if RequireTag {
+			isTag, tag := hookDataField(api, r, HookTagDataIndex)
+			ok = api.Uint248.And(ok, isTag, api.Bytes32.IsEqual(tag, c.RequiredTag))
+		}
+		if RequireOptIn {
+			i := idx / MaxPerUsr
+			ok = api.Uint248.And(ok, optedIn[i],
+				api.Uint248.IsGreaterThan(api.ToUint248(r.BlockNum), optInBlock[i]))
+		}
+		return ok
+	}
+}
+
+// hookDataField returns 1 if Fields[3] of r is data word index of the hook log in Fields[0], and its value
+func hookDataField(api *sdk.CircuitAPI, r sdk.Receipt, index int) (sdk.Uint248, sdk.Bytes32) {
+	hookLog, field := r.Fields[0], r.Fields[3]
+	return api.Uint248.And(
+		api.ToUint248(api.Uint32.IsEqual(field.LogPos, hookLog.LogPos)),
+		api.Uint248.IsEqual(field.Contract, hookLog.Contract),
+		api.Uint248.IsEqual(field.EventID, hookLog.EventID),
+		api.Uint248.IsZero(field.IsTopic),
+		api.Uint248.IsEqual(field.Index, sdk.ConstUint248(index)),
+	), field.Value
+}
+
+// hookGas returns the gas used r's hook log reports in Fields[3], 0 if the field isn't data word HookGasDataIndex of
+// the hook log, or is 2^GasBits or more
+func hookGas(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	ok, gas := hookDataField(api, r, HookGasDataIndex)
+	v := api.ToUint248(gas)
+	ok = api.Uint248.And(ok, api.Uint248.IsLessThan(v, sdk.ConstUint248(new(big.Int).Lsh(big.NewInt(1), GasBits))))
+	return api.Uint248.Select(ok, v, sdk.ConstUint248(0))
+}
+
+// capRewardShare lowers each discount to at most MaxRewardShareBps of the batch's total rebate divided by the user's
+// volume, rounded down, so its rebate stays within that share, and returns which slots it lowered. the total is of
+// final slots, before any discount is lowered, and a split user is capped on its full volume at every slot. a lone
+// rewarded user is the whole total, so the cap only applies with at least 2 users with a non-zero rebate
+func (c *UniVipHookCircuit) capRewardShare(api *sdk.CircuitAPI, totalVol, discount [MaxUsrNum]sdk.Uint248) (capped, lowered [MaxUsrNum]sdk.Uint248) {
+	total, rewarded := sdk.ConstUint248(0), sdk.ConstUint248(0)
+	for i, final := range finalSlots(api, c.Users) {
+		rebate := api.Uint248.Mul(totalVol[i], discount[i])
+		total = api.Uint248.Add(total, api.Uint248.Select(final, rebate, sdk.ConstUint248(0)))
+		rewarded = api.Uint248.Add(rewarded, api.Uint248.And(final, api.Uint248.Not(api.Uint248.IsZero(rebate))))
+	}
+	active := api.Uint248.IsGreaterThan(rewarded, sdk.ConstUint248(1))
+	maxRebate, _ := api.Uint248.Div(api.Uint248.Mul(total, c.MaxRewardShareBps), sdk.ConstUint248(BpsDenom))
+	fullVol := totalVol
+	for i := MaxUsrNum - 2; i >= 0; i-- {
+		fullVol[i] = api.Uint248.Select(api.Uint248.IsEqual(c.Users[i], c.Users[i+1]), fullVol[i+1], fullVol[i])
+	}
+	for i := range MaxUsrNum {
+		// no volume is no rebate, 1 keeps the division defined
+		vol := api.Uint248.Select(api.Uint248.IsZero(fullVol[i]), sdk.ConstUint248(1), fullVol[i])
+		maxDiscount, _ := api.Uint248.Div(maxRebate, vol)
+		lowered[i] = api.Uint248.And(active, api.Uint248.IsGreaterThan(discount[i], maxDiscount))
+		capped[i] = api.Uint248.Select(lowered[i], maxDiscount, discount[i])
+	}
+	return capped, lowered
+}
+
+// allowedTx returns 1 if r is the receipt of one of AllowedTxs. the SDK has no tx hash in circuit, a block and
+// receipt trie key pair is one tx
+func (c *UniVipHookCircuit) allowedTx(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	ok := sdk.ConstUint32(0)
+	for t := range MaxAllowedTxs {
+		ok = api.Uint32.Or(ok, api.Uint32.And(
+			api.Uint32.IsEqual(r.BlockNum, c.AllowedTxBlock[t]), api.Uint32.IsEqual(r.MptKeyPath, c.AllowedTxKey[t])))
+	}
+	return api.ToUint248(ok)
+}
+
+// optIns returns per user slot whether its user opted in, and the opt-in block, see userMappingValues
+func (c *UniVipHookCircuit) optIns(api *sdk.CircuitAPI, in sdk.DataInput) (optedIn, block [MaxUsrNum]sdk.Uint248) {
+	return c.userMappingValues(api, in, storageSlots().OptIn, c.OptInRegistry, c.OptInMappingSlot)
+}
+
+// flowRates returns each user's rebate, totalVol * RebateFeePips / FeePipsDenom * discount / DiscountDenom, divided by
+// EpochSeconds and rounded down, ie. token units per second like a Superfluid flow rate. discount must be final
+func (c *UniVipHookCircuit) flowRates(api *sdk.CircuitAPI, totalVol, discount [MaxUsrNum]sdk.Uint248) (rate [MaxUsrNum]sdk.Uint248) {
+	denom := api.Uint248.Mul(api.Uint248.Mul(sdk.ConstUint248(FeePipsDenom), c.DiscountDenom), c.EpochSeconds)
+	for i := range MaxUsrNum {
+		rate[i], _ = api.Uint248.Div(api.Uint248.Mul(api.Uint248.Mul(totalVol[i], c.RebateFeePips), discount[i]), denom)
+	}
+	return rate
+}
+
+// boostReputation adds ReputationScale * reputation to each user's tierVol, reputation is the user's entry in
+// ReputationRegistry, see userMappingValues. users without a proof, or with a score of ReputationBits or more, get none
+func (c *UniVipHookCircuit) boostReputation(api *sdk.CircuitAPI, in sdk.DataInput, tierVol [MaxUsrNum]sdk.Uint248) [MaxUsrNum]sdk.Uint248 {
+	_, score := c.userMappingValues(api, in, storageSlots().Reputation, c.ReputationRegistry, c.ReputationMappingSlot)
+	limit := sdk.ConstUint248(new(big.Int).Lsh(big.NewInt(1), ReputationBits))
+	for i := range MaxUsrNum {
+		s := api.Uint248.Select(api.Uint248.IsLessThan(score[i], limit), score[i], sdk.ConstUint248(0))
+		tierVol[i] = api.Uint248.Add(tierVol[i], api.Uint248.Mul(s, c.ReputationScale))
+	}
+	return tierVol
+}
+
+// userMappingValues returns, per user slot, whether the storage proof at start + the slot's index is the user's entry
+// of registry's mapping(address => uint256) at mappingSlot at StateRefBlock, and its value. a missing proof or a 0
+// value is not proven and its value is 0. one proof per user is enough, at its first slot, later slots of the same
+// user carry it
+func (c *UniVipHookCircuit) userMappingValues(api *sdk.CircuitAPI, in sdk.DataInput, start int, registry, mappingSlot sdk.Uint248) (proven, value [MaxUsrNum]sdk.Uint248) {
+	for i := range MaxUsrNum {
+		slot := in.StorageSlots.Raw[start+i]
+		key := new(packed).uint(sdk.ConstUint248(0), 96).uint(c.Users[i], 160).uint256(mappingSlot).keccak(api)
+		v := api.ToUint248(slot.Value)
+		proven[i] = api.Uint248.And(
+			sdk.Uint248{Val: in.StorageSlots.Toggles[start+i]},
+			api.ToUint248(api.Uint32.IsEqual(slot.BlockNum, c.StateRefBlock)),
+			api.Uint248.IsEqual(slot.Contract, registry),
+			api.Bytes32.IsEqual(slot.Slot, key),
+			api.Uint248.Not(api.Uint248.IsZero(v)),
+		)
+		value[i] = api.Uint248.Select(proven[i], v, sdk.ConstUint248(0))
+		if i > 0 {
+			carry := api.Uint248.And(api.Uint248.IsEqual(c.Users[i-1], c.Users[i]), api.Uint248.Not(proven[i]))
+			proven[i] = api.Uint248.Select(carry, proven[i-1], proven[i])
+			value[i] = api.Uint248.Select(carry, value[i-1], value[i])
+		}
+	}
+	return proven, value
+}
+
+// inTickRange returns 1 if Fields[3] is the tick of r's swap log and it's in [TickLower, TickUpper]
+func (c *UniVipHookCircuit) inTickRange(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	swapLog, tick := r.Fields[1], r.Fields[3]
+	t := api.ToInt248(tick.Value)
+	return api.Uint248.And(
+		api.ToUint248(api.Uint32.IsEqual(tick.LogPos, swapLog.LogPos)),
+		api.Uint248.IsEqual(tick.Contract, swapLog.Contract),
+		api.Uint248.IsEqual(tick.EventID, swapLog.EventID),
+		api.Uint248.IsZero(tick.IsTopic),
+		api.Uint248.IsEqual(tick.Index, sdk.ConstUint248(TickDataIndex)),
+		api.Uint248.Not(api.Int248.IsLessThan(t, c.TickLower)),
+		api.Uint248.Not(api.Int248.IsLessThan(c.TickUpper, t)),
+	)
+}
+
+// aboveDust returns 1 if either amount of r's swap is above DustThreshold. Fields[3] must be the other amount of the
+// same swap log, otherwise r doesn't count
+func (c *UniVipHookCircuit) aboveDust(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	swapLog, amount, other := r.Fields[1], r.Fields[2], r.Fields[3]
+	otherIdx := api.Uint248.Sub(sdk.ConstUint248(AmountDataIndex+Amount1DataIndex), amount.Index)
+	layoutOK := api.Uint248.And(
+		api.ToUint248(api.Uint32.IsEqual(other.LogPos, swapLog.LogPos)),
+		api.Uint248.IsEqual(other.Contract, swapLog.Contract),
+		api.Uint248.IsEqual(other.EventID, swapLog.EventID),
+		api.Uint248.IsZero(other.IsTopic),
+		api.Uint248.IsEqual(other.Index, otherIdx),
+	)
+	return api.Uint248.And(layoutOK, api.Uint248.Or(
+		api.Uint248.IsGreaterThan(swapAmount(api, r), c.DustThreshold),
+		api.Uint248.IsGreaterThan(amountValue(api, other.Value), c.DustThreshold),
+	))
+}
+
+// volumeMetric is swap amount of each counted receipt, capped at MaxSwapContribution with CapSwapContribution
+func (c *UniVipHookCircuit) volumeMetric(api *sdk.CircuitAPI, in sdk.DataInput) Metric {
+	return c.countedMetric(api, in, CapSwapContribution)
+}
+
+// countedMetric is volumeMetric, with the per swap cap only if capSwaps. with BlendedMetric each swap is
+// (amount*VolumeWeightBps + SwapCountScale*CountWeightBps) / BpsDenom, so the sum blends volume and count
+func (c *UniVipHookCircuit) countedMetric(api *sdk.CircuitAPI, in sdk.DataInput, capSwaps bool) Metric {
+	counted := c.receiptFilter(api, in)
+	var price sdk.Uint248
+	if NumeraireVolume {
+		price = api.ToUint248(in.StorageSlots.Raw[storageSlots().Numeraire].Value)
+	}
+	return func(idx int, r sdk.Receipt) sdk.Uint248 {
+		amount := c.receiptAmount(api, r)
+		if NumeraireVolume {
+			amount, _ = api.Uint248.Div(api.Uint248.Mul(amount, price), sdk.ConstUint248(new(big.Int).Lsh(big.NewInt(1), NumeraireShift)))
+		}
+		if PoolWeights {
+			amount = c.poolWeighted(api, r, amount)
+		}
+		if capSwaps {
+			amount = api.Uint248.Select(api.Uint248.IsGreaterThan(amount, c.MaxSwapContribution), c.MaxSwapContribution, amount)
+		}
+		if BlendedMetric {
+			amount, _ = api.Uint248.Div(
+				api.Uint248.Add(api.Uint248.Mul(amount, c.VolumeWeightBps), api.Uint248.Mul(c.SwapCountScale, c.CountWeightBps)),
+				sdk.ConstUint248(BpsDenom))
+		}
+		return api.Uint248.Select(counted(idx, r), amount, sdk.ConstUint248(0))
+	}
+}
+
+// poolWeighted returns amount * PoolWeightBps / BpsDenom of r's pool, amount as is for a receipt of no configured pool
+func (c *UniVipHookCircuit) poolWeighted(api *sdk.CircuitAPI, r sdk.Receipt, amount sdk.Uint248) sdk.Uint248 {
+	ids, hooks := c.pools()
+	weight := sdk.ConstUint248(BpsDenom)
+	for m := range MaxPoolNum {
+		weight = api.Uint248.Select(isPool(api, r, ids[m], hooks[m]), c.PoolWeightBps[m], weight)
+	}
+	weighted, _ := api.Uint248.Div(api.Uint248.Mul(amount, weight), sdk.ConstUint248(BpsDenom))
+	return weighted
+}
+
+// receiptAmount is swap amount of r, or weighted sum of both swap logs with WeightedSwapLogs, or their net with
+// NetSwapLogs
+func (c *UniVipHookCircuit) receiptAmount(api *sdk.CircuitAPI, r sdk.Receipt) sdk.Uint248 {
+	if !WeightedSwapLogs {
+		return swapAmount(api, r)
+	}
+	// second log is optional, it only counts if it's another swap amount from the same pool manager
+	swapLog, second := r.Fields[1], r.Fields[3]
+	isSwap := api.Uint248.And(
+		api.Uint248.IsEqual(second.Contract, c.PoolAddr),
+		api.Uint248.IsEqual(second.EventID, EventIdUniSwap),
+		api.Uint248.IsZero(second.IsTopic),
+		api.Uint248.IsEqual(second.Index, sdk.ConstUint248(AmountDataIndex)),
+		api.Uint248.Not(api.ToUint248(api.Uint32.IsEqual(second.LogPos, swapLog.LogPos))),
+	)
+	if NetSwapLogs {
+		return netAmount(api, api.ToInt248(r.Fields[2].Value), api.ToInt248(second.Value), isSwap)
+	}
+	primary := api.Uint248.Mul(swapAmount(api, r), c.SwapLogWeightBps[0])
+	secondary := api.Uint248.Select(
+		isSwap,
+		api.Uint248.Mul(amountValue(api, second.Value), c.SwapLogWeightBps[1]),
+		sdk.ConstUint248(0))
+	weighted, _ := api.Uint248.Div(api.Uint248.Add(primary, secondary), sdk.ConstUint248(BpsDenom))
+	return weighted
+}
+
+// liquidityOK returns 1 for each receipt whose storage slot at the same index proves pool liquidity
+// at the receipt's block is at least MinLiquidity. a missing slot means the receipt doesn't count.
+// with LiquidityAtStateRef, the only slot is at StateRefBlock and decides for all receipts
+func (c *UniVipHookCircuit) liquidityOK(api *sdk.CircuitAPI, in sdk.DataInput) []sdk.Uint248 {
+	start := storageSlots().Liquidity
+	ok := make([]sdk.Uint248, MaxReceipts)
+	for k := range MaxReceipts {
+		idx, block := start+k, in.Receipts.Raw[k].BlockNum
+		if LiquidityAtStateRef {
+			if k > 0 {
+				ok[k] = ok[0]
+				continue
+			}
+			block = c.StateRefBlock
+		}
+		slot := in.StorageSlots.Raw[idx]
+		ok[k] = api.Uint248.And(
+			sdk.Uint248{Val: in.StorageSlots.Toggles[idx]},
+			api.ToUint248(api.Uint32.IsEqual(slot.BlockNum, block)),
+			api.Uint248.IsEqual(slot.Contract, c.PoolAddr),
+			api.Bytes32.IsEqual(slot.Slot, c.LiquiditySlot),
+			// liquidity is uint128 in the low bits of the slot
+			api.Uint248.Not(api.Uint248.IsLessThan(api.ToUint248(slot.Value), c.MinLiquidity)),
+		)
+	}
+	return ok
+}
+
+// assertHookFlags asserts each non-zero hook address has every bit of HookFlags set.
+// v4 decides which hook functions are called from these bits, a hook missing beforeSwap never emits TxOrigin
+func (c *UniVipHookCircuit) assertHookFlags(api *sdk.CircuitAPI) {
+	want := api.Uint248.ToBinary(c.HookFlags, 14)
+	_, hooks := c.pools()
+	for m := range MaxPoolNum {
+		if m > 0 && !MultiPool {
+			break
+		}
+		bits := api.Uint248.ToBinary(hooks[m], 160)
+		missing := sdk.ConstUint248(0)
+		for k := range 14 {
+			missing = api.Uint248.Or(missing, api.Uint248.And(want[k], api.Uint248.Not(bits[k])))
+		}
+		api.Uint248.AssertIsEqual(api.Uint248.And(api.Uint248.Not(api.Uint248.IsZero(hooks[m])), missing), sdk.ConstUint248(0))
+	}
+}
+
+// assertUserSwaps asserts each user's receipt count, summed over its slots, is at most MaxUserSwaps
+func (c *UniVipHookCircuit) assertUserSwaps(api *sdk.CircuitAPI, in sdk.DataInput) {
+	toggled := func(idx int, _ sdk.Receipt) sdk.Uint248 {
+		return c.inRange(api, in, idx)
+	}
+	count := sdk.ConstUint248(0)
+	for i := range MaxUsrNum {
+		n := segmentVolume(api, in.Receipts.Raw, MaxPerUsr*i, MaxPerUsr, c.Users[i], toggled)
+		if i > 0 {
+			count = api.Uint248.Select(api.Uint248.IsEqual(c.Users[i-1], c.Users[i]), api.Uint248.Add(count, n), n)
+		} else {
+			count = n
+		}
+		api.Uint248.AssertIsLessOrEqual(count, c.MaxUserSwaps)
+	}
+}
+
+// assertUsersNotProtocol asserts no user is PoolAddr or a hook, extra hooks only with MultiPool
+func (c *UniVipHookCircuit) assertUsersNotProtocol(api *sdk.CircuitAPI) {
+	_, hooks := c.pools()
+	protocol := []sdk.Uint248{c.PoolAddr, hooks[0]}
+	if MultiPool {
+		protocol = append(protocol, hooks[1:]...)
+	}
+	for i := range MaxUsrNum {
+		for _, a := range protocol {
+			api.Uint248.AssertIsEqual(api.Uint248.IsEqual(c.Users[i], a), sdk.ConstUint248(0))
+		}
+	}
+}
+
+// assertUsersNotRouters asserts no user is one of Routers, whose swaps with NoHookLog are for someone else
+func (c *UniVipHookCircuit) assertUsersNotRouters(api *sdk.CircuitAPI) {
+	for i := range MaxUsrNum {
+		// unused router slots and padding users are both 0
+		user := api.Uint248.Not(api.Uint248.IsZero(c.Users[i]))
+		for _, r := range c.Routers {
+			api.Uint248.AssertIsEqual(api.Uint248.And(user, api.Uint248.IsEqual(c.Users[i], r)), sdk.ConstUint248(0))
+		}
+	}
+}
+
+// assertHookImpl asserts the hook proxy's implementation slot at StateRefBlock holds HookImpl.
+// an upgraded proxy points to different code and fails this
+func (c *UniVipHookCircuit) assertHookImpl(api *sdk.CircuitAPI, in sdk.DataInput) {
+	idx := storageSlots().HookImpl
+	slot := in.StorageSlots.Raw[idx]
+	api.Uint248.AssertIsEqual(sdk.Uint248{Val: in.StorageSlots.Toggles[idx]}, sdk.ConstUint248(1))
+	api.Uint32.AssertIsEqual(slot.BlockNum, c.StateRefBlock)
+	api.Uint248.AssertIsEqual(slot.Contract, c.HookAddr)
+	api.Bytes32.AssertIsEqual(slot.Slot, sdk.ConstFromBigEndianBytes(Hex2Bytes(ImplementationSlot)))
+	api.Uint248.AssertIsEqual(api.ToUint248(slot.Value), c.HookImpl)
+}
+
+// assertNumerairePrice asserts the NumeraireVolume price is a proof of NumeraireOracle's NumeraireSlot at StateRefBlock,
+// and fits NumerairePriceBits so amount * price can't overflow
+func (c *UniVipHookCircuit) assertNumerairePrice(api *sdk.CircuitAPI, in sdk.DataInput) {
+	idx := storageSlots().Numeraire
+	slot := in.StorageSlots.Raw[idx]
+	api.Uint248.AssertIsEqual(sdk.Uint248{Val: in.StorageSlots.Toggles[idx]}, sdk.ConstUint248(1))
+	api.Uint32.AssertIsEqual(slot.BlockNum, c.StateRefBlock)
+	api.Uint248.AssertIsEqual(slot.Contract, c.NumeraireOracle)
+	api.Bytes32.AssertIsEqual(slot.Slot, c.NumeraireSlot)
+	api.Uint248.AssertIsEqual(
+		api.Uint248.IsLessThan(api.ToUint248(slot.Value), sdk.ConstUint248(new(big.Int).Lsh(big.NewInt(1), NumerairePriceBits))),
+		sdk.ConstUint248(1))
+}
+
 func DefaultUniCircuit() *UniVipHookCircuit {
 	ret := &UniVipHookCircuit{
 		PoolAddr:   sdk.ConstUint248(0),
@@ -139,10 +1739,105 @@ func DefaultUniCircuit() *UniVipHookCircuit {
 	for i := range TierNum {
 		ret.TierDiscount[i] = sdk.ConstUint248(0)
 		ret.TierMinAmount[i] = sdk.ConstUint248(0)
+		ret.TierInclusive[i] = sdk.ConstUint248(0)
 	}
 	for i := range MaxUsrNum {
 		ret.Users[i] = sdk.ConstUint248(0)
 	}
+	for i := range MaxSelfTradeAddrs {
+		ret.SelfTradeAddrs[i] = sdk.ConstUint248(0)
+	}
+	ret.DiscountDenom = sdk.ConstUint248(MaxDiscount)
+	ret.MaxDiscountStep = sdk.ConstUint248(MaxDiscount)
+	ret.ShardIndex = sdk.ConstUint248(0)
+	ret.ShardCount = sdk.ConstUint248(1)
+	ret.MinOutputTier = sdk.ConstUint248(0)
+	ret.BatchVolumeCap = sdk.ConstUint248(0)
+	ret.Salt = sdk.ConstUint248(0)
+	ret.ConcentrationBps = sdk.ConstUint248(BpsDenom)
+	ret.ConcentrationPenaltyBps = sdk.ConstUint248(BpsDenom)
+	ret.MaxRewardShareBps = sdk.ConstUint248(BpsDenom)
+	ret.LiquiditySlot = sdk.ConstFromBigEndianBytes(Hex2Bytes("0x0000000000000000000000000000000000000000000000000000000000000000"))
+	ret.MinLiquidity = sdk.ConstUint248(0)
+	ret.HookImpl = sdk.ConstUint248(0)
+	for i := range ret.SwapLogWeightBps {
+		ret.SwapLogWeightBps[i] = sdk.ConstUint248(BpsDenom)
+	}
+	for i := range MaxPoolNum - 1 {
+		ret.ExtraPoolIds[i] = sdk.ConstFromBigEndianBytes(Hex2Bytes("0x0000000000000000000000000000000000000000000000000000000000000000"))
+		ret.ExtraHookAddrs[i] = sdk.ConstUint248(0)
+	}
+	for i := range MaxPoolNum {
+		ret.PoolAmountIndex[i] = sdk.ConstUint248(AmountDataIndex)
+		ret.PoolWeightBps[i] = sdk.ConstUint248(BpsDenom)
+	}
+	ret.MinUsers = sdk.ConstUint248(0)
+	ret.MinBatchVolume = sdk.ConstUint248(0)
+	ret.ReputationRegistry = sdk.ConstUint248(0)
+	ret.ReputationMappingSlot = sdk.ConstUint248(0)
+	ret.ReputationScale = sdk.ConstUint248(0)
+	ret.RebateFeePips = sdk.ConstUint248(0)
+	ret.EpochSeconds = sdk.ConstUint248(1)
+	ret.AgeCutoffBlock = sdk.ConstUint32(0)
+	ret.StateRefBlock = sdk.ConstUint32(0)
+	ret.EpochLabel = sdk.ConstFromBigEndianBytes(make([]byte, 32))
+	ret.FreshPenaltyBps = sdk.ConstUint248(BpsDenom)
+	ret.HookFlags = sdk.ConstUint248(AfterInitializeFlag | BeforeSwapFlag)
+	for i := range MaxUsrNum {
+		ret.EntityIds[i] = sdk.ConstUint248(0)
+	}
+	ret.VolumePrecision = sdk.ConstUint248(1)
+	ret.DustThreshold = sdk.ConstUint248(0)
+	ret.MaxSwapContribution = sdk.ConstUint248(maxUint248)
+	ret.MaxSwapAmount = sdk.ConstUint248(maxUint248)
+	ret.MaxUserSwaps = sdk.ConstUint248(MaxReceipts)
+	for i := range MaxV3PoolNum {
+		ret.V3PoolAddrs[i] = sdk.ConstUint248(0)
+	}
+	for i := range MaxRouterNum {
+		ret.Routers[i] = sdk.ConstUint248(0)
+	}
+	for k := range MaxRequestedUsers {
+		ret.RequestedUsers[k] = sdk.ConstUint248(0)
+	}
+	ret.OptInRegistry = sdk.ConstUint248(0)
+	ret.OptInMappingSlot = sdk.ConstUint248(0)
+	ret.NumeraireOracle = sdk.ConstUint248(0)
+	ret.NumeraireSlot = sdk.ConstFromBigEndianBytes(make([]byte, 32))
+	ret.RequiredTag = sdk.ConstFromBigEndianBytes(make([]byte, 32))
+	for j := range TierNum {
+		ret.CountTierMinSwaps[j] = sdk.ConstUint248(maxUint248)
+		ret.CountTierMultiplier[j] = sdk.ConstUint248(0)
+	}
+	for t := range MaxAllowedTxs {
+		ret.AllowedTxBlock[t] = sdk.ConstUint32(0)
+		ret.AllowedTxKey[t] = sdk.ConstUint32(0)
+	}
+	ret.TickLower = sdk.ConstInt248(big.NewInt(0))
+	ret.TickUpper = sdk.ConstInt248(big.NewInt(0))
+	for i := range MaxUsrNum {
+		ret.StreakLength[i] = sdk.ConstUint248(0)
+		ret.PriorTier[i] = sdk.ConstUint248(0)
+		ret.ClaimNonce[i] = sdk.ConstUint248(0)
+	}
+	// volume only
+	ret.VolumeWeightBps = sdk.ConstUint248(BpsDenom)
+	ret.CountWeightBps = sdk.ConstUint248(0)
+	ret.SwapCountScale = sdk.ConstUint248(0)
+	ret.StreakBonusBps = sdk.ConstUint248(0)
+	ret.MaxStreakBonusBps = sdk.ConstUint248(0)
+	for m := range MaxPoolNum {
+		ret.HookEventIds[m] = EventIdHook
+		ret.HookOriginIndex[m] = sdk.ConstUint248(OriginTopicIndex)
+	}
+	for m := range MaxPoolNum - 1 {
+		for j := range TierNum {
+			ret.ExtraTierMinAmount[m][j] = sdk.ConstUint248(0)
+			ret.ExtraTierDiscount[m][j] = sdk.ConstUint248(0)
+		}
+	}
+	ret.MultiPoolTier = sdk.ConstUint248(0)
+	ret.MinPools = sdk.ConstUint248(0)
 	return ret
 }
 
@@ -158,3 +1853,35 @@ func Hex2Bytes(s string) (b []byte) {
 	b, _ = hex.DecodeString(s)
 	return b
 }
+
+// Endianness is byte order of a hex or byte input, circuit fields are big endian
+type Endianness int
+
+const (
+	BigEndian Endianness = iota
+	LittleEndian
+)
+
+// ToBigEndian returns b in big endian order, b is not modified
+func ToBigEndian(b []byte, e Endianness) []byte {
+	ret := slices.Clone(b)
+	if e == LittleEndian {
+		slices.Reverse(ret)
+	}
+	return ret
+}
+
+// Hex2BytesEndian is Hex2Bytes of s given in byte order e, result is big endian
+func Hex2BytesEndian(s string, e Endianness) []byte {
+	return ToBigEndian(Hex2Bytes(s), e)
+}
+
+// ParseBytes32 parses s in byte order e into a Bytes32, eg. a PoolId from little endian tooling
+func ParseBytes32(s string, e Endianness) sdk.Bytes32 {
+	return sdk.ConstFromBigEndianBytes(Hex2BytesEndian(s, e))
+}
+
+// ParseUint248 parses s in byte order e into a Uint248, eg. an address
+func ParseUint248(s string, e Endianness) sdk.Uint248 {
+	return sdk.ConstUint248(new(big.Int).SetBytes(Hex2BytesEndian(s, e)))
+}
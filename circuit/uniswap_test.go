@@ -0,0 +1,1575 @@
+package circuit
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestCapRewardShareLoneUser(t *testing.T) {
+	cfg, ch := optionTest(t, "CapRewardShare")
+	requireSimulated(t)
+	cfg.MaxRewardShareBps = 5_000
+	out, err := cfg.Simulate([]Receipt{ch.swap(cfg, 110, user(1), 20_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the whole rebate is the lone user's, it's not capped to half of itself
+	if got := resultOf(t, decodeResults(t, out), user(1)).Values["discount"].Uint64(); got != 300 {
+		t.Fatalf("lone user discount %d, want uncapped 300", got)
+	}
+}
+
+func TestCapRewardShareClampFlag(t *testing.T) {
+	cfg, ch := optionTest(t, "CapRewardShare", "OutputClampFlag")
+	cfg.MaxRewardShareBps = 5_000
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 200_000), ch.swap(cfg, 120, user(2), 2_000)}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+
+	// rebates 200000*500 and 2000*100, half the total over user 1's volume is 250
+	heavy, light := resultOf(t, rs, user(1)), resultOf(t, rs, user(2))
+	if d, c := heavy.Values["discount"].Uint64(), heavy.Values["clamped"].Uint64(); d != 250 || c != 1 {
+		t.Fatalf("user 1 discount %d clamped %d, want 250 and 1", d, c)
+	}
+	if d, c := light.Values["discount"].Uint64(), light.Values["clamped"].Uint64(); d != 100 || c != 0 {
+		t.Fatalf("user 2 discount %d clamped %d, want 100 and 0", d, c)
+	}
+}
+
+func TestQualifiedTiers(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputQualifiedTiers")
+	requireSimulated(t)
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 500),
+		ch.swap(cfg, 110, user(2), 5_000),
+		ch.swap(cfg, 110, user(3), 500_000),
+	}
+	out, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs := decodeResults(t, out)
+	// no tier, the first tier, all three
+	for n, want := range map[int]uint64{1: 0, 2: 0b1, 3: 0b111} {
+		if got := resultOf(t, rs, user(n)).Values["qualifiedTiers"].Uint64(); got != want {
+			t.Errorf("user %d qualified tiers %b, want %b", n, got, want)
+		}
+	}
+	if proven := proveInMemory(t, ch, cfg, receipts); !bytes.Equal(proven, out) {
+		t.Fatal("proven output differs from Simulate")
+	}
+}
+
+func TestAggregateEntitiesThreeSubAccounts(t *testing.T) {
+	cfg, ch := optionTest(t, "AggregateEntities")
+	cfg.Entities = map[common.Address]uint64{user(1): 9, user(2): 9, user(3): 9}
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 4_000),
+		ch.swap(cfg, 120, user(2), 4_000),
+		ch.swap(cfg, 130, user(3), 4_000),
+		ch.swap(cfg, 140, user(4), 4_000),
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+
+	// 12000 together reaches the second tier, each alone only the first
+	wantValue(t, rs, user(3), "discount", 300, "entity")
+	wantValue(t, rs, user(4), "discount", 100, "unmapped user")
+	for _, r := range rs {
+		if r.Address == user(1) || r.Address == user(2) {
+			t.Fatalf("entity member %s has its own row", r.Address.Hex())
+		}
+	}
+}
+
+func TestOutOfRangeCountedSeparately(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputOutOfRangeCount", "OutputReceiptCount")
+	requireSimulated(t)
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 150, user(1), 5_000),
+		// fetched with a window past BlockEnd
+		ch.swap(cfg, 250, user(1), 90_000),
+		ch.swap(cfg, 260, user(2), 90_000),
+	}
+	out, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proven := proveInMemory(t, ch, cfg, receipts); !bytes.Equal(proven, out) {
+		t.Fatal("proven output differs from Simulate")
+	}
+	header := decodeHeader(t, out)
+	if n := header["receiptCount"].Uint64(); n != 2 {
+		t.Errorf("receipt count %d, want the 2 in range", n)
+	}
+	if n := header["outOfRangeCount"].Uint64(); n != 2 {
+		t.Errorf("out of range count %d, want 2", n)
+	}
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 300, "in range volume only")
+}
+
+func TestMinBatchVolume(t *testing.T) {
+	cfg, ch := optionTest(t, "RequireMinBatchVolume")
+	requireSimulated(t)
+	cfg.MinBatchVolume = big.NewInt(10_000)
+	below := []Receipt{ch.swap(cfg, 110, user(1), 4_000), ch.swap(cfg, 120, user(2), 6_000)}
+	if _, err := cfg.Simulate(below); err == nil {
+		t.Fatal("Simulate accepted a batch of exactly MinBatchVolume")
+	}
+	a, err := cfg.Assign(below)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+
+	above := append(below, ch.swap(cfg, 130, user(2), 1))
+	proveInMemory(t, ch, cfg, above)
+}
+
+func TestEOAProofAtLaterSlot(t *testing.T) {
+	cfg, ch := optionTest(t, "EOAUsersOnly")
+	requireSimulated(t)
+	cfg.EOAProofTxs = map[common.Address]common.Hash{user(1): ch.tx(105, user(1))}
+	// one more than a segment, so user 1 has two slots
+	var receipts []Receipt
+	for k := range MaxPerUsr + 1 {
+		receipts = append(receipts, ch.swap(cfg, 110+uint64(k%80), user(1), 100))
+	}
+	want, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Assign puts the proof at the first slot, any slot of the user must do
+	start := transactionSlots().EOAProof
+	a.Txs[start+1] = a.Txs[start]
+	delete(a.Txs, start)
+	if got := proveAssigned(t, ch, a); !bytes.Equal(got, want) {
+		t.Fatal("proof at the second slot changes the output")
+	}
+	if d := resultOf(t, decodeResults(t, want), user(1)).Values["discount"].Uint64(); d != 300 {
+		t.Fatalf("discount %d, want 300", d)
+	}
+}
+
+func TestV3AndV4VolumeOneTier(t *testing.T) {
+	cfg, ch := optionTest(t, "V3Pools")
+	cfg.V3PoolAddrs = []common.Address{common.HexToAddress("0x88e6a0c2ddd26feeb64f039a2c41296fcb3f5640")}
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 6_000),
+		ch.v3Swap(cfg, 0, 120, user(1), -6_000),
+		ch.v3Swap(cfg, 0, 130, user(2), 6_000),
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	// 6000 on each protocol is one 12000 total, past the second tier
+	wantValue(t, rs, user(1), "discount", 300, "v3 and v4 user")
+	wantValue(t, rs, user(2), "discount", 100, "v3 only user")
+}
+
+func TestMatchedVolumeBuyThenSell(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputMatchedVolume")
+	requireSimulated(t)
+	receipts := []Receipt{
+		// buys 5000 then sells 3000 of it, and a user who only buys
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(1), -3_000),
+		ch.swap(cfg, 130, user(2), 7_000),
+	}
+	out, err := cfg.Simulate(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proven := proveInMemory(t, ch, cfg, receipts); !bytes.Equal(proven, out) {
+		t.Fatal("proven output differs from Simulate")
+	}
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "matchedVolume", 3_000, "buy then sell")
+	wantValue(t, rs, user(2), "matchedVolume", 0, "buy only")
+}
+
+func TestUserIndexSorted(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputUserIndex")
+	requireSimulated(t)
+	// first appearance isn't address order
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(3), 5_000),
+		ch.swap(cfg, 120, user(1), 5_000),
+		ch.swap(cfg, 130, user(2), 5_000),
+		ch.swap(cfg, 140, user(1), 5_000),
+	}
+	out := proveInMemory(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for i, n := range []int{1, 2, 3} {
+		if idx := resultOf(t, rs, user(n)).Values["index"].Uint64(); idx != uint64(i) {
+			t.Errorf("user %d index %d, want %d", n, idx, i)
+		}
+	}
+	if sim, err := cfg.Simulate(receipts); err != nil || !bytes.Equal(sim, out) {
+		t.Fatalf("proven output differs from Simulate, err %v", err)
+	}
+}
+
+func TestExcludeSelfTrades(t *testing.T) {
+	cfg, ch := optionTest(t, "ExcludeSelfTrades")
+	requireSimulated(t)
+	cfg.SelfTradeAddrs = []common.Address{user(8), user(9)}
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		// user 8 and 9 trade back and forth
+		ch.swap(cfg, 120, user(8), 500_000),
+		ch.swap(cfg, 121, user(9), -500_000),
+		ch.swap(cfg, 130, user(8), 500_000),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 100, "user 1")
+	for _, n := range []int{8, 9} {
+		if d := resultOf(t, rs, user(n)).Values["discount"].Uint64(); d != 0 {
+			t.Errorf("self trading user %d discount %d, want 0", n, d)
+		}
+	}
+}
+
+func TestCapBatchVolumeTruncatesLowest(t *testing.T) {
+	cfg, ch := optionTest(t, "CapBatchVolume")
+	requireSimulated(t)
+	cfg.BatchVolumeCap = big.NewInt(72_000)
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 130, user(3), 20_000),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	// 50000 and 20000 fit the cap, user 1's 5000 crosses it
+	for n, want := range map[int]uint64{1: 0, 2: 300, 3: 300} {
+		if d := resultOf(t, rs, user(n)).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %d discount %d, want %d", n, d, want)
+		}
+	}
+}
+
+func TestRejectsPoolIdAndAmountOfDifferentLogs(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	other := common.HexToHash("0x8c6a1a2f2c2a5c5f1d6ee0f7b1a9e3cb0b5d1d9e6c2f8a3e4b7d0c1a2f3e4d5c")
+	ch := newChain()
+	// a small swap in the configured pool, then a large one in another pool of the same tx
+	h := ch.tx(110, user(1),
+		hookLog(cfg.HookAddr, TxOriginEv, user(1)),
+		swapLog(cfg.PoolAddr, cfg.PoolId, user(1), big.NewInt(500), 0),
+		swapLog(cfg.PoolAddr, other, user(1), big.NewInt(500_000), 0))
+	r := Receipt{
+		TxHash: h, BlockNum: 110, User: user(1), HookLogPos: 0, SwapLogPos: 1, Amount: big.NewInt(500),
+		SwapContract: cfg.PoolAddr, HookContract: cfg.HookAddr, PoolId: cfg.PoolId,
+	}
+	a, err := cfg.Assign([]Receipt{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// poolId of the configured pool's log, amount of the other's
+	for idx, data := range a.Receipts {
+		if data.TxHash == h {
+			data.Fields[2].LogPos = 2
+			a.Receipts[idx] = data
+		}
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestReceiptCount(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputReceiptCount")
+	requireSimulated(t)
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 130, user(1), -700),
+	}
+	out := proveInMemory(t, ch, cfg, receipts)
+	if n := decodeHeader(t, out)["receiptCount"].Uint64(); n != uint64(len(receipts)) {
+		t.Fatalf("receipt count %d, want %d", n, len(receipts))
+	}
+}
+
+func TestBlockConcentrationPenalized(t *testing.T) {
+	cfg, ch := optionTest(t, "PenalizeBlockConcentration")
+	requireSimulated(t)
+	cfg.ConcentrationBps, cfg.ConcentrationPenaltyBps = 8_000, 5_000
+	// 12000 each, user 1 in one block, user 2 over two
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 7_000),
+		ch.swap(cfg, 110, user(1), -5_000),
+		ch.swap(cfg, 120, user(2), 6_000),
+		ch.swap(cfg, 130, user(2), 6_000),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 100, "concentrated user, half its volume")
+	wantValue(t, rs, user(2), "discount", 300, "distributed user")
+}
+
+func TestThinLiquiditySwapExcluded(t *testing.T) {
+	requireOptions(t, "CheckPoolLiquidity")
+	if LiquidityAtStateRef {
+		t.Skip("liquidity is proven once, not per swap")
+	}
+	cfg := testConfig()
+	cfg.MinLiquidity = big.NewInt(1_000_000)
+	ch := newChain()
+	slot := LiquiditySlot(cfg.PoolId)
+	ch.setStorage(cfg.PoolAddr, slot, common.BigToHash(big.NewInt(5_000_000)))
+	// liquidity is pulled for block 125 only
+	ch.setStorageFrom(cfg.PoolAddr, slot, 125, common.BigToHash(big.NewInt(10)))
+	ch.setStorageFrom(cfg.PoolAddr, slot, 126, common.BigToHash(big.NewInt(5_000_000)))
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 125, user(1), 50_000),
+		ch.swap(cfg, 130, user(2), 50_000),
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	wantValue(t, rs, user(1), "discount", 100, "user 1 without its thin liquidity swap")
+	wantValue(t, rs, user(2), "discount", 300, "user 2")
+}
+
+func TestDiscountDenomOutput(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputDiscountDenom")
+	r := ch.swap(cfg, 110, user(1), 5_000)
+	if d := decodeHeader(t, proveInMemory(t, ch, cfg, []Receipt{r}))["discountDenom"].Uint64(); d != MaxDiscount {
+		t.Errorf("default denom %d, want %d", d, MaxDiscount)
+	}
+	cfg.DiscountDenom = 1_000
+	if d := decodeHeader(t, proveInMemory(t, ch, cfg, []Receipt{r}))["discountDenom"].Uint64(); d != 1_000 {
+		t.Errorf("denom %d, want configured 1000", d)
+	}
+}
+
+func TestMarginalTiersBlend(t *testing.T) {
+	cfg, ch := optionTest(t, "MarginalTiers")
+	requireSimulated(t)
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 200_000)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	// (9000*100 + 90000*300 + 100000*500) / 200000, 1000 below the first band blends in as 0
+	if d := resultOf(t, decodeResults(t, out), user(1)).Values["discount"].Uint64(); d != 389 {
+		t.Fatalf("blended discount %d, want 389", d)
+	}
+}
+
+// poolIdLog is a Swap log of amount0 with id wherever PoolIdIsTopic and PoolIdFieldIndex put it, the rest laid out
+// like swapLog
+func poolIdLog(cfg *Config, id common.Hash, sender common.Address, amount0 int64) *types.Log {
+	l := &types.Log{
+		Address: cfg.PoolAddr,
+		Topics:  []common.Hash{common.HexToHash(UniSwapEv), {}, {}},
+	}
+	l.Topics[SwapSenderTopicIndex] = common.BytesToHash(sender.Bytes())
+	data := make([]common.Hash, 6)
+	if PoolIdIsTopic {
+		for len(l.Topics) <= PoolIdFieldIndex {
+			l.Topics = append(l.Topics, common.Hash{})
+		}
+		l.Topics[PoolIdFieldIndex] = id
+	} else {
+		for len(data) <= PoolIdFieldIndex {
+			data = append(data, common.Hash{})
+		}
+		data[PoolIdFieldIndex] = id
+	}
+	data[AmountDataIndex] = common.BytesToHash(word(big.NewInt(amount0)))
+	data[Amount1DataIndex] = common.BytesToHash(word(big.NewInt(-amount0)))
+	for _, d := range data {
+		l.Data = append(l.Data, d.Bytes()...)
+	}
+	return l
+}
+
+func TestPoolIdFieldPosition(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	ch := newChain()
+	swap := func(block uint64, u common.Address, id common.Hash, amount int64) Receipt {
+		h := ch.tx(block, u, hookLog(cfg.HookAddr, TxOriginEv, u), poolIdLog(cfg, id, u, amount))
+		return Receipt{
+			TxHash: h, BlockNum: block, User: u, SwapLogPos: 1, Amount: big.NewInt(amount),
+			SwapContract: cfg.PoolAddr, HookContract: cfg.HookAddr, PoolId: id,
+		}
+	}
+	r := swap(110, user(1), cfg.PoolId, 5_000)
+	if d := resultOf(t, decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{r})), user(1)).Values["discount"].Uint64(); d != 100 {
+		t.Fatalf("discount %d, want 100 from the poolId field", d)
+	}
+
+	other := common.HexToHash("0x8c6a1a2f2c2a5c5f1d6ee0f7b1a9e3cb0b5d1d9e6c2f8a3e4b7d0c1a2f3e4d5c")
+	a, err := cfg.Assign([]Receipt{swap(120, user(2), other, 5_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestMinOutputTierSentinels(t *testing.T) {
+	cfg, ch := optionTest(t, "FilterMinOutputTier")
+	requireSimulated(t)
+	cfg.MinOutputTier = 2
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 130, user(3), 500),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	// users 1 and 3 are below tier level 2, their rows are zero padding
+	rs := decodeResults(t, out)
+	if len(rs) != 1 || rs[0].Address != user(2) || rs[0].Values["discount"].Uint64() != 300 {
+		t.Fatalf("results %+v, want only user 2 with discount 300", rs)
+	}
+}
+
+func TestHookImplMismatchRejected(t *testing.T) {
+	cfg, ch := optionTest(t, "CheckHookImpl")
+	impl := common.HexToAddress("0x5b3e2bd1fbd5b1f3c4869e7d3b1c3b7d1c7ef2a1")
+	ch.setStorage(cfg.HookAddr, common.HexToHash(ImplementationSlot), common.BytesToHash(impl.Bytes()))
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	cfg.HookImpl = impl
+	proveInMemory(t, ch, cfg, receipts)
+
+	// the hook was upgraded from what the program expects
+	cfg.HookImpl = common.HexToAddress("0x9d2f0e5b4c4a0d3b1e8f7a6c5b4d3e2f1a0b9c8d")
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestWeightedSwapLogs(t *testing.T) {
+	requireOptions(t, "WeightedSwapLogs")
+	if NoHookLog {
+		t.Skip("tx below has a hook log")
+	}
+	cfg := testConfig()
+	cfg.SwapLogWeightBps = [2]uint64{10_000, 5_000}
+	ch := newChain()
+	// 10000 alone isn't above tier 1's min amount, half of the second swap takes it there
+	ch.tx(110, user(1),
+		hookLog(cfg.HookAddr, TxOriginEv, user(1)),
+		swapLog(cfg.PoolAddr, cfg.PoolId, user(1), big.NewInt(10_000), 0),
+		swapLog(cfg.PoolAddr, cfg.PoolId, user(1), big.NewInt(-4_000), 0))
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 1 || receipts[0].SecondSwapLogPos == nil || *receipts[0].SecondSwapLogPos != 2 {
+		t.Fatalf("fetched %+v, want one receipt with a second swap log at 2", receipts)
+	}
+	if d := resultOf(t, decodeResults(t, proveInMemory(t, ch, cfg, receipts)), user(1)).Values["discount"].Uint64(); d != 300 {
+		t.Fatalf("discount %d, want 300 of 10000 + 4000/2", d)
+	}
+}
+
+func TestNetSwapLogsRoundTrip(t *testing.T) {
+	requireOptions(t, "WeightedSwapLogs", "NetSwapLogs")
+	requireSimulated(t)
+	if NoHookLog {
+		t.Skip("txs below have a hook log")
+	}
+	cfg := testConfig()
+	ch := newChain()
+	roundTrip := func(block uint64, u common.Address, buy, sell int64) {
+		ch.tx(block, u,
+			hookLog(cfg.HookAddr, TxOriginEv, u),
+			swapLog(cfg.PoolAddr, cfg.PoolId, u, big.NewInt(buy), 0),
+			swapLog(cfg.PoolAddr, cfg.PoolId, u, big.NewInt(sell), 0))
+	}
+	// bought back exactly, and all but 2000
+	roundTrip(110, user(1), 50_000, -50_000)
+	roundTrip(120, user(2), 50_000, -48_000)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []int64{0, 2_000} {
+		if got := cfg.simAmount(receipts[i]); got.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("receipt %d counts %v, want net %d", i, got, want)
+		}
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 0, user(2): 100} {
+		if d := resultOf(t, rs, u).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %s discount %d, want %d", u.Hex(), d, want)
+		}
+	}
+}
+
+func TestGateTierByPools(t *testing.T) {
+	cfg, ch := optionTest(t, "MultiPool", "GateTierByPools")
+	cfg.ExtraPools = []PoolConfig{testExtraPool}
+	cfg.MultiPoolTier, cfg.MinPools = 2, 2
+	// 50000 each, user 1 in one pool, user 2 in both
+	receipts := []Receipt{
+		ch.poolSwap(cfg, 0, 110, user(1), 50_000),
+		ch.poolSwap(cfg, 0, 120, user(2), 25_000),
+		ch.poolSwap(cfg, 1, 130, user(2), 25_000),
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	wantValue(t, rs, user(1), "discount", 100, "single pool user, below the gated tier")
+	wantValue(t, rs, user(2), "discount", 300, "two pool user")
+}
+
+func TestNativeAndWrappedAggregate(t *testing.T) {
+	requireOptions(t, "MultiPool", "CanonicalVolumeToken")
+	if NoHookLog {
+		t.Skip("txs below have a hook log")
+	}
+	usdc := common.HexToAddress("0xa0b86991c6218b36c1d19d4a2e9eb0ce3606eb48")
+	weth := common.HexToAddress("0xc02aaa39b223fe8d0a0e5c4f27ead9083c756cc2")
+	cfg := testConfig()
+	// an ETH/USDC pool and a USDC/WETH one, volume counted in native ETH
+	cfg.Currency0, cfg.Currency1 = common.Address{}, usdc
+	extra := testExtraPool
+	extra.Currency0, extra.Currency1 = usdc, weth
+	cfg.ExtraPools = []PoolConfig{extra}
+	cfg.VolumeToken = common.Address{}
+	cfg.TokenAliases = map[common.Address]common.Address{weth: {}}
+	ch := newChain()
+	swap := func(block uint64, id common.Hash, hook common.Address, amount0, amount1 int64) {
+		l := swapLog(cfg.PoolAddr, id, user(1), big.NewInt(amount0), 0)
+		copy(l.Data[32:64], word(big.NewInt(amount1)))
+		ch.tx(block, user(1), hookLog(hook, TxOriginEv, user(1)), l)
+	}
+	// 6000 ETH then 6000 WETH, each against far more USDC
+	swap(110, cfg.PoolId, cfg.HookAddr, 6_000, -900_000_000)
+	swap(120, extra.PoolId, extra.HookAddr, 900_000_000, -6_000)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 || receipts[1].Pool != 1 || receipts[1].Amount.Int64() != -6_000 {
+		t.Fatalf("fetched %+v, want the WETH amount of the second pool", receipts)
+	}
+	if d := resultOf(t, decodeResults(t, proveInMemory(t, ch, cfg, receipts)), user(1)).Values["discount"].Uint64(); d != 300 {
+		t.Fatalf("discount %d, want 300 of 12000 ETH", d)
+	}
+}
+
+func TestMinUsersRejectsSingleUser(t *testing.T) {
+	cfg, ch := optionTest(t, "RequireMinUsers")
+	cfg.MinUsers = 3
+	single := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(1), 5_000)}
+	if _, err := cfg.Assign(single); err == nil {
+		t.Fatal("single user batch assigned with MinUsers 3")
+	}
+	proveInMemory(t, ch, cfg, append(single, ch.swap(cfg, 130, user(2), 500), ch.swap(cfg, 140, user(3), 500)))
+
+	// past Validate, as a prover assigning its own circuit could
+	cfg.MinUsers = 1
+	a, err := cfg.Assign(single)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.MinUsers = sdk.ConstUint248(3)
+	rejectInMemory(t, ch, a)
+}
+
+func TestVolumeSharesSum(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputVolumeShare")
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 20_000),
+		ch.swap(cfg, 130, user(3), -7_000),
+	}))
+	sum := uint64(0)
+	for _, r := range rs {
+		sum += r.Values["volumeShareBps"].Uint64()
+	}
+	// each share rounds down by less than 1
+	if sum > BpsDenom || sum <= BpsDenom-uint64(len(rs)) {
+		t.Errorf("shares sum to %d, want 10000 less rounding of %d users", sum, len(rs))
+	}
+
+	// no volume, no division by it
+	rs = decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 140, user(4), 0)}))
+	wantValue(t, rs, user(4), "volumeShareBps", 0, "batch without volume")
+}
+
+func TestFreshUserPenalized(t *testing.T) {
+	cfg, ch := optionTest(t, "PenalizeFreshUsers")
+	cfg.AgeCutoffBlock = 90
+	cfg.FreshPenaltyBps = 5_000
+	// user 1 sent a tx before the cutoff, user 2 is new
+	cfg.AgeProofTxs = map[common.Address]common.Hash{user(1): ch.tx(50, user(1))}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 12_000),
+		ch.swap(cfg, 120, user(2), 12_000),
+	}))
+	wantValue(t, rs, user(1), "discount", 300, "established user")
+	wantValue(t, rs, user(2), "discount", 100, "fresh user, half its volume")
+}
+
+func TestOtherVolumeOfUnlistedUsers(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputOtherVolume")
+	cfg.Users = []common.Address{user(1)}
+	out := proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 7_000),
+		ch.swap(cfg, 130, user(3), -900),
+	})
+	if v := decodeHeader(t, out)["otherVolume"].Uint64(); v != 7_900 {
+		t.Errorf("other volume %d, want 7900 of users 2 and 3", v)
+	}
+	rs := decodeResults(t, out)
+	if len(rs) != 1 || rs[0].Address != user(1) {
+		t.Fatalf("results %+v, want only listed user 1", rs)
+	}
+}
+
+func TestDustSwapExcluded(t *testing.T) {
+	requireOptions(t, "FilterDustSwaps")
+	if NoHookLog {
+		t.Skip("tx below has a hook log")
+	}
+	cfg := testConfig()
+	cfg.DustThreshold = big.NewInt(10)
+	ch := newChain()
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 995),
+		// both amounts dust
+		ch.swap(cfg, 111, user(1), 8),
+		ch.swap(cfg, 120, user(2), 995),
+	}
+	// dust amount0, but amount1 isn't
+	l := swapLog(cfg.PoolAddr, cfg.PoolId, user(2), big.NewInt(8), 0)
+	copy(l.Data[32:64], word(big.NewInt(-500)))
+	h := ch.tx(121, user(2), hookLog(cfg.HookAddr, TxOriginEv, user(2)), l)
+	receipts = append(receipts, Receipt{
+		TxHash: h, BlockNum: 121, User: user(2), SwapLogPos: 1, Amount: big.NewInt(8),
+		SwapContract: cfg.PoolAddr, HookContract: cfg.HookAddr, PoolId: cfg.PoolId,
+	})
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	wantValue(t, rs, user(1), "discount", 0, "user 1, 995 without its dust swap")
+	wantValue(t, rs, user(2), "discount", 100, "user 2, 1003")
+}
+
+func TestNextTierGap(t *testing.T) {
+	requireOptions(t, "OutputNextTierGap")
+	requireSimulated(t)
+	if MarginalTiers {
+		t.Skip("gaps below are of plain tiers")
+	}
+	cfg := testConfig()
+	ch := newChain()
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 20_000), ch.swap(cfg, 120, user(2), 200_000)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "nextTierGap", 80_000, "mid tier user, to tier 2")
+	wantValue(t, rs, user(2), "nextTierGap", 0, "top tier user")
+}
+
+func TestCapSwapContribution(t *testing.T) {
+	cfg, ch := optionTest(t, "CapSwapContribution")
+	requireSimulated(t)
+	cfg.MaxSwapContribution = big.NewInt(20_000)
+	receipts := []Receipt{
+		// one whale swap adds 20000, the others in full: 25000
+		ch.swap(cfg, 110, user(1), 500_000),
+		ch.swap(cfg, 111, user(1), 3_000),
+		ch.swap(cfg, 112, user(1), -2_000),
+		// 10500 of swaps under the cap
+		ch.swap(cfg, 120, user(2), 9_000),
+		ch.swap(cfg, 121, user(2), 1_500),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 300, "whale, clamped to 25000")
+	wantValue(t, rs, user(2), "discount", 300, "small swaps, 10500")
+}
+
+func TestTierHistogramCountsUsers(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputTierHistogram")
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 500),
+		ch.swap(cfg, 111, user(2), 5_000),
+		ch.swap(cfg, 112, user(3), 50_000),
+		ch.swap(cfg, 113, user(4), 500_000),
+	}
+	// split across two slots, counted once at level 1
+	for i := range MaxPerUsr + 1 {
+		receipts = append(receipts, ch.swap(cfg, 120+uint64(i%50), user(5), 10))
+	}
+	header := decodeHeader(t, proveInMemory(t, ch, cfg, receipts))
+	want := []uint64{1, 2, 1, 1}
+	sum := uint64(0)
+	for j := range TierNum + 1 {
+		n := header[fmt.Sprintf("tier%dUsers", j)].Uint64()
+		sum += n
+		if j < len(want) && n != want[j] {
+			t.Errorf("%d users at level %d, want %d", n, j, want[j])
+		}
+	}
+	if sum != 5 {
+		t.Errorf("histogram sums to %d, want 5 users", sum)
+	}
+}
+
+func TestParseEndianness(t *testing.T) {
+	id := testPoolId.Bytes()
+	le := slices.Clone(id)
+	slices.Reverse(le)
+	for _, tc := range []struct {
+		name string
+		hex  string
+		e    Endianness
+	}{
+		{"big endian", hex.EncodeToString(id), BigEndian},
+		{"little endian", "0x" + hex.EncodeToString(le), LittleEndian},
+	} {
+		if got := Hex2BytesEndian(tc.hex, tc.e); !bytes.Equal(got, id) {
+			t.Errorf("%s pool id bytes %x, want %x", tc.name, got, id)
+		}
+		if got, want := ParseBytes32(tc.hex, tc.e), sdk.ConstFromBigEndianBytes(id); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s pool id field %v, want %v", tc.name, got, want)
+		}
+	}
+
+	addr := user(7)
+	leAddr := slices.Clone(addr.Bytes())
+	slices.Reverse(leAddr)
+	if got, want := ParseUint248(hex.EncodeToString(leAddr), LittleEndian), sdk.ConstUint248(addr.Big()); !reflect.DeepEqual(got, want) {
+		t.Errorf("little endian address field %v, want %v", got, want)
+	}
+	if got, want := ParseUint248(addr.Hex(), BigEndian), sdk.ConstUint248(addr.Big()); !reflect.DeepEqual(got, want) {
+		t.Errorf("big endian address field %v, want %v", got, want)
+	}
+	if ToBigEndian(le, LittleEndian); !bytes.Equal(le[:1], id[31:]) {
+		t.Error("ToBigEndian modified its input")
+	}
+}
+
+func TestStreakBonus(t *testing.T) {
+	cfg, ch := optionTest(t, "StreakBonus")
+	cfg.Streaks = map[common.Address]uint64{user(1): 5, user(2): 1}
+	cfg.StreakBonusBps, cfg.MaxStreakBonusBps = 1_000, 5_000
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 12_000),
+		ch.swap(cfg, 120, user(2), 12_000),
+	}))
+	// 4 epochs beyond the first add 40%
+	wantValue(t, rs, user(1), "discount", 420, "5 epoch streak")
+	wantValue(t, rs, user(2), "discount", 300, "1 epoch streak")
+}
+
+func TestVolumeScoreScales(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputVolumeScore")
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 40_000),
+		ch.swap(cfg, 120, user(2), -10_000),
+		ch.swap(cfg, 130, user(3), 2_500),
+	}))
+	for n, want := range map[int]uint64{1: 1000, 2: 250, 3: 62} {
+		if s := resultOf(t, rs, user(n)).Values["volumeScore"].Uint64(); s != want {
+			t.Errorf("user %d score %d, want %d", n, s, want)
+		}
+	}
+
+	// no volume, no division by the max
+	rs = decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 140, user(4), 0)}))
+	wantValue(t, rs, user(4), "volumeScore", 0, "batch without volume")
+}
+
+func TestCapUserSwapsRejects(t *testing.T) {
+	cfg, ch := optionTest(t, "CapUserSwaps")
+	cfg.MaxUserSwaps = 2
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(1), 5_000),
+		ch.swap(cfg, 130, user(2), 5_000),
+		ch.swap(cfg, 140, user(1), 5_000),
+	}
+	if _, err := cfg.Assign(receipts); err == nil {
+		t.Fatal("user with 3 swaps assigned with MaxUserSwaps 2")
+	}
+	proveInMemory(t, ch, cfg, receipts[:3])
+
+	// past Assign, as a prover assigning its own circuit could
+	cfg.MaxUserSwaps = 3
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.MaxUserSwaps = sdk.ConstUint248(2)
+	rejectInMemory(t, ch, a)
+}
+
+func TestClampFlagOfCappedUser(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputClampFlag", "CapSwapContribution")
+	cfg.MaxSwapContribution = big.NewInt(20_000)
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 500_000),
+		ch.swap(cfg, 120, user(2), 5_000),
+	}))
+	wantValue(t, rs, user(1), "clamped", 1, "capped user")
+	wantValue(t, rs, user(2), "clamped", 0, "uncapped user")
+}
+
+func TestClampFlagOfBoostedCappedUser(t *testing.T) {
+	cfg, ch := optionTest(t, "CapSwapContribution", "ReputationBoost", "OutputClampFlag")
+	cfg.MaxSwapContribution = big.NewInt(20_000)
+	cfg.ReputationMappingSlot = 5
+	cfg.ReputationScale = big.NewInt(1_000_000)
+	cfg.Reputation = map[common.Address]uint64{user(1): 1}
+	ch.setStorage(cfg.ReputationRegistry, AddressMappingSlot(user(1), cfg.ReputationMappingSlot), common.BigToHash(big.NewInt(1)))
+	// user 1 is capped to 20000, then boosted to 1020000, above its raw 500000
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 500_000),
+		ch.swap(cfg, 120, user(2), 5_000),
+	}))
+	wantValue(t, rs, user(1), "clamped", 1, "capped boosted user")
+	wantValue(t, rs, user(2), "clamped", 0, "uncapped user")
+}
+
+func TestStateProofsAtRefBlock(t *testing.T) {
+	requireOptions(t, "CheckHookImpl")
+	if CheckPoolLiquidity && !LiquidityAtStateRef {
+		t.Skip("liquidity is proven at each receipt's block")
+	}
+	cfg := testConfig()
+	cfg.StateRefBlock = 160
+	impl := common.HexToAddress("0x5b3e2bd1fbd5b1f3c4869e7d3b1c3b7d1c7ef2a1")
+	cfg.HookImpl = impl
+	ch := newChain()
+	ch.setStorage(cfg.HookAddr, common.HexToHash(ImplementationSlot), common.BytesToHash(impl.Bytes()))
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for idx, s := range a.Storage {
+		if s.BlockNum.Uint64() != 160 {
+			t.Errorf("storage %d is at block %d, want StateRefBlock 160", idx, s.BlockNum)
+		}
+	}
+	proveAssigned(t, ch, a)
+
+	// the same state, proven at another block
+	for idx, s := range a.Storage {
+		s.BlockNum = big.NewInt(150)
+		a.Storage[idx] = s
+	}
+	rejectInMemory(t, ch, a)
+
+	cfg.StateRefBlock = cfg.BlockEnd + 1
+	if err := cfg.Validate(); err == nil {
+		t.Error("StateRefBlock after the epoch accepted")
+	}
+}
+
+func TestTierTableOutput(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputTierTable")
+	header := decodeHeader(t, proveInMemory(t, ch, cfg, []Receipt{ch.swap(cfg, 110, user(1), 5_000)}))
+	for j := range TierNum {
+		minAmount, discount := maxUint248, uint64(0)
+		if j < len(cfg.Tiers) {
+			minAmount, discount = cfg.Tiers[j].MinAmount, uint64(cfg.Tiers[j].Discount)
+		}
+		if got := header[fmt.Sprintf("tier%dMinAmount", j)]; got.Cmp(minAmount) != 0 {
+			t.Errorf("tier %d min amount %s, want %s", j, got, minAmount)
+		}
+		if got := header[fmt.Sprintf("tier%dDiscount", j)].Uint64(); got != discount {
+			t.Errorf("tier %d discount %d, want %d", j, got, discount)
+		}
+	}
+}
+
+func TestOnlyRequestedUsersOutput(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputRequestedUsers")
+	// user 9 isn't in the batch
+	cfg.RequestedUsers = []common.Address{user(3), user(9), user(1)}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 130, user(3), 50_000),
+	}))
+	want := []struct {
+		u        common.Address
+		discount uint64
+	}{{user(3), 300}, {user(9), 0}, {user(1), 100}}
+	if len(rs) != len(want) {
+		t.Fatalf("%d rows, want the %d requested", len(rs), len(want))
+	}
+	for i, w := range want {
+		if rs[i].Address != w.u || rs[i].Values["discount"].Uint64() != w.discount {
+			t.Errorf("row %d is %s with %d, want %s with %d", i, rs[i].Address.Hex(), rs[i].Values["discount"], w.u.Hex(), w.discount)
+		}
+	}
+}
+
+func TestEpochLabelRoundTrip(t *testing.T) {
+	cfg, ch := optionTest(t, "EpochLabel")
+	requireSimulated(t)
+	cfg.EpochLabel = crypto.Keccak256Hash([]byte("2024-W05"))
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	out := proveInMemory(t, ch, cfg, receipts)
+	if got := common.BigToHash(decodeHeader(t, out)["epoch"]); got != cfg.EpochLabel {
+		t.Fatalf("epoch %x, want label %x", got, cfg.EpochLabel)
+	}
+	if sim, err := cfg.Simulate(receipts); err != nil || !bytes.Equal(sim, out) {
+		t.Fatalf("proven output differs from Simulate, err %v", err)
+	}
+}
+
+func TestBlendedMetricWeights(t *testing.T) {
+	cfg, ch := optionTest(t, "BlendedMetric")
+	requireSimulated(t)
+	cfg.SwapCountScale = big.NewInt(5_000)
+	// a whale's one swap, and 20 small ones
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 60_000)}
+	for i := range 20 {
+		receipts = append(receipts, ch.swap(cfg, 120+uint64(i), user(2), 500))
+	}
+	for _, tc := range []struct {
+		name                string
+		volumeBps, countBps uint64
+		whaleDisc, freqDisc uint64
+	}{
+		{"volume", BpsDenom, 0, 300, 100},
+		{"count", 0, BpsDenom, 100, 300},
+	} {
+		cfg.VolumeWeightBps, cfg.CountWeightBps = tc.volumeBps, tc.countBps
+		out := proveInMemory(t, ch, cfg, receipts)
+		if sim, err := cfg.Simulate(receipts); err != nil || !bytes.Equal(sim, out) {
+			t.Fatalf("%s weighted: proven output differs from Simulate, err %v", tc.name, err)
+		}
+		rs := decodeResults(t, out)
+		if d := resultOf(t, rs, user(1)).Values["discount"].Uint64(); d != tc.whaleDisc {
+			t.Errorf("%s weighted: whale discount %d, want %d", tc.name, d, tc.whaleDisc)
+		}
+		if d := resultOf(t, rs, user(2)).Values["discount"].Uint64(); d != tc.freqDisc {
+			t.Errorf("%s weighted: frequent trader discount %d, want %d", tc.name, d, tc.freqDisc)
+		}
+	}
+}
+
+func TestOutOfOrderSegmentRejected(t *testing.T) {
+	cfg, ch := optionTest(t, "AssertBlockOrder")
+	// Assign sorts these by block
+	receipts := []Receipt{ch.swap(cfg, 130, user(1), 5_000), ch.swap(cfg, 110, user(1), 5_000)}
+	proveInMemory(t, ch, cfg, receipts)
+
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// past Assign, as a prover assigning its own circuit could
+	a.Receipts[0], a.Receipts[1] = a.Receipts[1], a.Receipts[0]
+	if a.Receipts[0].BlockNum.Uint64() != 130 {
+		t.Fatalf("segment starts at block %d, want 130 after the swap", a.Receipts[0].BlockNum)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestPoolWeightsScaleSwaps(t *testing.T) {
+	cfg, ch := optionTest(t, "MultiPool", "PoolWeights")
+	extra := testExtraPool
+	extra.WeightBps = 30_000
+	cfg.ExtraPools = []PoolConfig{extra}
+	cfg.PoolWeightBps = BpsDenom
+	// the same swap, 5000 in the main pool and 15000 in the weighted one
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, []Receipt{
+		ch.poolSwap(cfg, 0, 110, user(1), 5_000),
+		ch.poolSwap(cfg, 1, 120, user(2), 5_000),
+	}))
+	wantValue(t, rs, user(1), "discount", 100, "main pool swap")
+	wantValue(t, rs, user(2), "discount", 300, "weighted pool swap")
+}
+
+func TestTotalDiscountSumsUsers(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputTotalDiscount")
+	requireSimulated(t)
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 500_000)}
+	// split across two slots, counted once
+	for i := range MaxPerUsr + 1 {
+		receipts = append(receipts, ch.swap(cfg, 130+uint64(i%50), user(3), 100))
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	want := uint64(0)
+	seen := make(map[common.Address]bool)
+	// a split user's last slot has its final discount
+	rs := decodeResults(t, out)
+	for i := len(rs) - 1; i >= 0; i-- {
+		if !seen[rs[i].Address] {
+			want += rs[i].Values["discount"].Uint64()
+			seen[rs[i].Address] = true
+		}
+	}
+	if got := decodeHeader(t, out)["totalDiscount"].Uint64(); got != want || want != 100+500+300 {
+		t.Fatalf("total discount %d, want %d, the sum of 100, 500 and 300", got, want)
+	}
+}
+
+func TestAbsurdSwapAmountRejected(t *testing.T) {
+	cfg, ch := optionTest(t, "AssertMaxSwapAmount")
+	cfg.MaxSwapAmount = big.NewInt(1e12)
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		// a decoding error's worth of volume
+		ch.swap(cfg, 120, user(2), 1e15),
+	}
+	if _, err := cfg.Simulate(receipts); err == nil {
+		t.Error("Simulate accepted a swap above MaxSwapAmount")
+	}
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestGateLowestTierOmitsUsers(t *testing.T) {
+	cfg, ch := optionTest(t, "GateLowestTier")
+	cfg.Tiers[0].Discount = 0
+	out := proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), 500),
+		ch.swap(cfg, 120, user(2), 5_000),
+		ch.swap(cfg, 130, user(3), 500),
+		ch.swap(cfg, 140, user(4), 50_000),
+	})
+	slots := MaxUsrNum
+	if OutputRequestedUsers {
+		slots = MaxRequestedUsers
+	}
+	// users 1 and 3 don't reach tier 0, the others' rows are packed at the front
+	rows := decodeRows(t, out, slots)
+	for i, want := range []common.Address{user(2), user(4), {}} {
+		if got := common.BigToAddress(rows[i]["address"]); got != want {
+			t.Errorf("row %d is %s, want %s", i, got.Hex(), want.Hex())
+		}
+	}
+	for _, r := range decodeResults(t, out) {
+		if r.Address == user(1) || r.Address == user(3) {
+			t.Errorf("user %s below tier 0 has a row", r.Address.Hex())
+		}
+	}
+}
+
+func TestBlockRangeOfUsers(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputBlockRange")
+	requireSimulated(t)
+	receipts := []Receipt{
+		ch.swap(cfg, 104, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), 50_000),
+		ch.swap(cfg, 150, user(1), 600),
+		ch.swap(cfg, 190, user(1), -700),
+	}
+	// split across two slots, the final one covers both
+	for i := range MaxPerUsr + 1 {
+		receipts = append(receipts, ch.swap(cfg, 101+uint64(i%80), user(3), 100))
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	first, last := make(map[common.Address]uint64), make(map[common.Address]uint64)
+	for _, r := range receipts {
+		if f, ok := first[r.User]; !ok || r.BlockNum < f {
+			first[r.User] = r.BlockNum
+		}
+		last[r.User] = max(last[r.User], r.BlockNum)
+	}
+	// a split user's last slot has its full range
+	rs := decodeResults(t, out)
+	seen := make(map[common.Address]bool)
+	for i := len(rs) - 1; i >= 0; i-- {
+		u := rs[i].Address
+		if seen[u] {
+			continue
+		}
+		seen[u] = true
+		if got, want := rs[i].Values["firstBlock"].Uint64(), first[u]; got != want {
+			t.Errorf("user %s first block %d, want %d", u.Hex(), got, want)
+		}
+		if got, want := rs[i].Values["lastBlock"].Uint64(), last[u]; got != want {
+			t.Errorf("user %s last block %d, want %d", u.Hex(), got, want)
+		}
+	}
+	if len(seen) != 3 {
+		t.Fatalf("%d users in the output, want 3", len(seen))
+	}
+}
+
+func TestUsersOutsideShardRejected(t *testing.T) {
+	cfg, ch := optionTest(t, "Sharded")
+	// odd addresses
+	cfg.ShardIndex, cfg.ShardCount = 1, 2
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(3), 50_000)}
+	out := proveInMemory(t, ch, cfg, receipts)
+	if h := decodeHeader(t, out); h["shardIndex"].Uint64() != 1 || h["shardCount"].Uint64() != 2 {
+		t.Fatalf("shard %v of %v, want 1 of 2", h["shardIndex"], h["shardCount"])
+	}
+
+	if _, err := cfg.Assign(append(receipts, ch.swap(cfg, 130, user(2), 5_000))); err == nil {
+		t.Error("Assign accepted an even user in the odd shard")
+	}
+	// claiming the other shard gets past Validate, not the circuit
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.ShardIndex = sdk.ConstUint248(0)
+	rejectInMemory(t, ch, a)
+}
+
+func TestUnsignedAmountHighBit(t *testing.T) {
+	cfg, ch := optionTest(t, "UnsignedAmounts")
+	requireSimulated(t)
+	// top tier is above 2^247, read as a signed int248 the amount below is 2^247 - 5 and only reaches tier 1
+	cfg.Tiers[2].MinAmount = new(big.Int).Lsh(big.NewInt(1), 247)
+	amount := new(big.Int).Add(cfg.Tiers[2].MinAmount, big.NewInt(5))
+	var logs []*types.Log
+	if !NoHookLog {
+		logs = append(logs, hookLog(cfg.HookAddr, TxOriginEv, user(1)))
+	}
+	ch.tx(110, user(1), append(logs, swapLog(cfg.PoolAddr, cfg.PoolId, user(1), amount, 0))...)
+	ch.swap(cfg, 120, user(2), 5_000)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(receipts) != 2 || receipts[0].User != user(1) || receipts[0].Amount.Cmp(amount) != 0 {
+		t.Fatalf("fetched %+v, want user 1's amount %v first", receipts, amount)
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	if d := resultOf(t, decodeResults(t, out), user(1)).Values["discount"].Uint64(); d != 500 {
+		t.Fatalf("discount %d, want 500 of the unsigned amount", d)
+	}
+}
+
+func TestSwapsBeforeOptInExcluded(t *testing.T) {
+	cfg, ch := optionTest(t, "RequireOptIn")
+	requireSimulated(t)
+	cfg.OptInRegistry = common.HexToAddress("0x7d2c3e1f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d")
+	cfg.OptInMappingSlot = 3
+	cfg.OptInBlocks = map[common.Address]uint64{user(1): 130}
+	ch.setStorage(cfg.OptInRegistry, AddressMappingSlot(user(1), cfg.OptInMappingSlot), common.BigToHash(big.NewInt(130)))
+	receipts := []Receipt{
+		// before and at the opt-in block, not counted
+		ch.swap(cfg, 110, user(1), 50_000),
+		ch.swap(cfg, 130, user(1), 50_000),
+		ch.swap(cfg, 140, user(1), 5_000),
+		// never opted in
+		ch.swap(cfg, 150, user(2), 50_000),
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 100, "only the swap after opting in")
+	wantValue(t, rs, user(2), "discount", 0, "user that didn't opt in")
+}
+
+func TestNumeraireVolumeOfProvenPrice(t *testing.T) {
+	cfg, ch := optionTest(t, "NumeraireVolume")
+	requireSimulated(t)
+	cfg.NumeraireOracle = common.HexToAddress("0x3a9c1e5b7d2f4a6c8e0b1d3f5a7c9e1b3d5f7a9c")
+	cfg.NumeraireSlot = common.BigToHash(big.NewInt(2))
+	// 2.5 numeraire per token
+	cfg.NumerairePrice = new(big.Int).Lsh(big.NewInt(5), NumeraireShift-1)
+	ch.setStorage(cfg.NumeraireOracle, cfg.NumeraireSlot, common.BigToHash(cfg.NumerairePrice))
+	// 6000 tokens is tier 0, its 15000 numeraire tier 1
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 6_000), ch.swap(cfg, 120, user(2), 300)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	wantValue(t, rs, user(1), "discount", 300, "15000 numeraire")
+	wantValue(t, rs, user(2), "discount", 0, "750 numeraire")
+}
+
+func TestTickRangeExcludesOutOfBand(t *testing.T) {
+	cfg, ch := optionTest(t, "TickRange")
+	requireSimulated(t)
+	cfg.TickLower, cfg.TickUpper = -100, 100
+	swapAt := func(block uint64, u common.Address, amount int64, tick int32) {
+		var logs []*types.Log
+		if !NoHookLog {
+			logs = append(logs, hookLog(cfg.HookAddr, TxOriginEv, u))
+		}
+		ch.tx(block, u, append(logs, swapLog(cfg.PoolAddr, cfg.PoolId, u, big.NewInt(amount), tick))...)
+	}
+	swapAt(110, user(1), 5_000, 50)
+	swapAt(120, user(1), 50_000, 200)
+	swapAt(130, user(2), 50_000, -150)
+	// bounds are inclusive
+	swapAt(140, user(3), 5_000, -100)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 100, user(2): 0, user(3): 100} {
+		if d := resultOf(t, rs, u).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %s discount %d, want %d", u.Hex(), d, want)
+		}
+	}
+}
+
+func TestResultCountPrefix(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputResultCount")
+	cfg.Tiers[0].Discount = 0
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000)}
+	// split across two slots, one row
+	for i := range MaxPerUsr + 1 {
+		receipts = append(receipts, ch.swap(cfg, 120+uint64(i%50), user(2), 100))
+	}
+	receipts = append(receipts, ch.swap(cfg, 180, user(3), 50_000))
+	out := proveInMemory(t, ch, cfg, receipts)
+	n := decodeHeader(t, out)["resultCount"].Uint64()
+	if rs := decodeResults(t, out); n != 3 || len(rs) != 3 {
+		t.Fatalf("result count %d of %d results, want 3", n, len(rs))
+	}
+	for i, row := range decodeRows(t, out, MaxUsrNum) {
+		if padding := common.BigToAddress(row["address"]) == (common.Address{}); padding != (uint64(i) >= n) {
+			t.Errorf("row %d padding %v with result count %d", i, padding, n)
+		}
+	}
+}
+
+func TestCountTiersIndependentOfVolume(t *testing.T) {
+	cfg, ch := optionTest(t, "CountTiers")
+	cfg.CountTiers = []CountTierConfig{{MinSwaps: 2, MultiplierBps: 12_000}, {MinSwaps: 5, MultiplierBps: 15_000}}
+	// a whale with two large swaps and a user with many small ones
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 200_000), ch.swap(cfg, 120, user(1), 200_000)}
+	for i := range 6 {
+		receipts = append(receipts, ch.swap(cfg, 130+uint64(i), user(2), 300))
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	for u, want := range map[common.Address][2]uint64{user(1): {500, BpsDenom}, user(2): {100, 15_000}} {
+		r := resultOf(t, rs, u)
+		if d, m := r.Values["discount"].Uint64(), r.Values["multiplierBps"].Uint64(); d != want[0] || m != want[1] {
+			t.Errorf("user %s discount %d multiplier %d, want %d and %d", u.Hex(), d, m, want[0], want[1])
+		}
+	}
+}
+
+func TestQualifiedTiersOfMidTierUser(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputQualifiedTiers")
+	requireSimulated(t)
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 50_000), ch.swap(cfg, 120, user(2), 500), ch.swap(cfg, 130, user(3), 500_000)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 0b011, user(2): 0, user(3): 0b111} {
+		if got := resultOf(t, rs, u).Values["qualifiedTiers"].Uint64(); got != want {
+			t.Errorf("user %s qualified tiers %b, want %b", u.Hex(), got, want)
+		}
+	}
+}
+
+func TestReputationRaisesTier(t *testing.T) {
+	cfg, ch := optionTest(t, "ReputationBoost")
+	requireSimulated(t)
+	cfg.ReputationRegistry = common.HexToAddress("0x6e4d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d")
+	cfg.ReputationMappingSlot = 5
+	cfg.ReputationScale = big.NewInt(1_000)
+	cfg.Reputation = map[common.Address]uint64{user(1): 10, user(2): 1}
+	for u, score := range cfg.Reputation {
+		ch.setStorage(cfg.ReputationRegistry, AddressMappingSlot(u, cfg.ReputationMappingSlot), common.BigToHash(new(big.Int).SetUint64(score)))
+	}
+	// equal volume, 5000 + 10000 and 5000 + 1000
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 5_000)}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	if high, low := resultOf(t, rs, user(1)).Values["discount"].Uint64(), resultOf(t, rs, user(2)).Values["discount"].Uint64(); high != 300 || low != 100 {
+		t.Fatalf("discounts %d and %d of high and low reputation, want 300 and 100", high, low)
+	}
+}
+
+func TestFlowRateOfKnownRebate(t *testing.T) {
+	requireOptions(t, "OutputFlowRate")
+	requireSimulated(t)
+	if SecondsPerBlock != 12 {
+		t.Skip("expected rates assume 12s blocks")
+	}
+	cfg := testConfig()
+	cfg.RebateFeePips = 3_000
+	ch := newChain()
+	// a rebate of 1.2e12 * 0.3% * 5% = 1.8e8
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 1_200_000_000_000)}
+	for seconds, want := range map[uint64]uint64{
+		// default, 100 blocks of 12s
+		0: 150_000,
+		7: 25_714_285,
+	} {
+		cfg.EpochSeconds = seconds
+		out := proveSimulated(t, ch, cfg, receipts)
+		if got := resultOf(t, decodeResults(t, out), user(1)).Values["flowRate"].Uint64(); got != want {
+			t.Errorf("flow rate %d over %d seconds, want %d", got, seconds, want)
+		}
+	}
+}
+
+func TestCapTierJump(t *testing.T) {
+	cfg, ch := optionTest(t, "CapTierJump")
+	requireSimulated(t)
+	// user 3 was at tier 0 last epoch, the others are new or had no tier
+	cfg.PriorTiers = map[common.Address]uint8{user(2): 0, user(3): 1}
+	receipts := []Receipt{
+		// each would reach the top tier, three levels up from none
+		ch.swap(cfg, 110, user(1), 500_000),
+		ch.swap(cfg, 120, user(2), 500_000),
+		ch.swap(cfg, 130, user(3), 500_000),
+		// dropping isn't limited
+		ch.swap(cfg, 140, user(4), 50),
+	}
+	cfg.PriorTiers[user(4)] = 3
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 100, user(2): 100, user(3): 300, user(4): 0} {
+		if d := resultOf(t, rs, u).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %s discount %d, want %d", u.Hex(), d, want)
+		}
+	}
+}
+
+func TestEffectiveDiscountAppliesMultiplier(t *testing.T) {
+	cfg, ch := optionTest(t, "CountTiers", "OutputEffectiveDiscount")
+	cfg.DiscountDenom = 1_000
+	cfg.CountTiers = []CountTierConfig{{MinSwaps: 2, MultiplierBps: 15_000}, {MinSwaps: 4, MultiplierBps: 30_000}}
+	receipts := []Receipt{ch.swap(cfg, 110, user(2), 50_000)}
+	for i := range 3 {
+		receipts = append(receipts, ch.swap(cfg, 120+uint64(i), user(1), 5_000))
+	}
+	for i := range 5 {
+		receipts = append(receipts, ch.swap(cfg, 130+uint64(i), user(3), 100_000))
+	}
+	rs := decodeResults(t, proveInMemory(t, ch, cfg, receipts))
+	for u, want := range map[common.Address][2]uint64{
+		// 300 of tier 1 and 1.5x
+		user(1): {300, 450},
+		// no multiplier
+		user(2): {300, 300},
+		// 500 of the top tier and 3x, capped at the denom
+		user(3): {500, 1_000},
+	} {
+		r := resultOf(t, rs, u)
+		if d, e := r.Values["discount"].Uint64(), r.Values["effectiveDiscount"].Uint64(); d != want[0] || e != want[1] {
+			t.Errorf("user %s discount %d effective %d, want %d and %d", u.Hex(), d, e, want[0], want[1])
+		}
+	}
+}
+
+func TestTxAllowlistExcludesUnlisted(t *testing.T) {
+	cfg, ch := optionTest(t, "TxAllowlist")
+	requireSimulated(t)
+	listed := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 110, user(2), 5_000)}
+	receipts := append(listed,
+		// another tx of a listed user in a listed block, and a user with none listed
+		ch.swap(cfg, 120, user(1), 50_000),
+		ch.swap(cfg, 130, user(3), 50_000))
+	refs, err := FetchTxRefs(context.Background(), ch, []common.Hash{listed[0].TxHash, listed[1].TxHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refs[0].BlockNum != 110 || refs[1].BlockNum != 110 || refs[0].TxIndex == refs[1].TxIndex {
+		t.Fatalf("refs %+v, want two txs of block 110", refs)
+	}
+	cfg.AllowedTxs = refs
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 100, user(2): 100, user(3): 0} {
+		if d := resultOf(t, rs, u).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %s discount %d, want %d", u.Hex(), d, want)
+		}
+	}
+}
+
+func TestGasWeightedVolume(t *testing.T) {
+	cfg, ch := optionTest(t, "OutputGasWeightedVolume")
+	requireSimulated(t)
+	gas := func(g int64) []common.Hash {
+		data := make([]common.Hash, HookGasDataIndex+1)
+		data[HookGasDataIndex] = common.BigToHash(big.NewInt(g))
+		return data
+	}
+	// equal swaps, user 2's costing three times the gas
+	ch.swap(cfg, 110, user(1), 5_000, gas(100_000)...)
+	ch.swap(cfg, 120, user(2), 5_000, gas(300_000)...)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]int64{user(1): 5_000 * 100_000, user(2): 5_000 * 300_000} {
+		if got := resultOf(t, rs, u).Values["gasWeightedVolume"]; got.Cmp(big.NewInt(want)) != 0 {
+			t.Errorf("user %s gas weighted volume %v, want %d", u.Hex(), got, want)
+		}
+	}
+}
+
+func TestDuplicateUserSlotsRejected(t *testing.T) {
+	requireOptions(t, "RequireDistinctUsers")
+	if DeltaAddresses {
+		t.Skip("slots below are laid out unsorted")
+	}
+	cfg := testConfig()
+	ch := newChain()
+	var receipts []Receipt
+	for i := range MaxPerUsr {
+		receipts = append(receipts, ch.swap(cfg, 101+uint64(i%90), user(1), 100))
+	}
+	extra := ch.swap(cfg, 195, user(1), 100)
+	if _, err := cfg.Assign(append(receipts, extra)); err == nil {
+		t.Error("Assign gave a user a second slot")
+	}
+
+	// slot 1 taken over by user 1, past Validate
+	a, err := cfg.Assign(append(receipts, ch.swap(cfg, 196, user(2), 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.Circuit.Users[1] = sdk.ConstUint248(user(1).Big())
+	if a.Receipts[MaxPerUsr], err = cfg.receiptData(extra); err != nil {
+		t.Fatal(err)
+	}
+	rejectInMemory(t, ch, a)
+}
+
+func TestRequiredTagCountsTaggedSwaps(t *testing.T) {
+	cfg, ch := optionTest(t, "RequireTag")
+	requireSimulated(t)
+	cfg.RequiredTag = common.HexToHash("0x70617274")
+	tag := func(h common.Hash) []common.Hash {
+		data := make([]common.Hash, HookTagDataIndex+1)
+		data[HookTagDataIndex] = h
+		return data
+	}
+	ch.swap(cfg, 110, user(1), 5_000, tag(cfg.RequiredTag)...)
+	ch.swap(cfg, 120, user(1), 50_000)
+	ch.swap(cfg, 130, user(2), 50_000, tag(common.HexToHash("0x6f74686572"))...)
+	ch.swap(cfg, 140, user(3), 50_000, tag(cfg.RequiredTag)...)
+	receipts, err := FetchReceipts(context.Background(), ch, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := proveSimulated(t, ch, cfg, receipts)
+	rs := decodeResults(t, out)
+	for u, want := range map[common.Address]uint64{user(1): 100, user(2): 0, user(3): 300} {
+		if d := resultOf(t, rs, u).Values["discount"].Uint64(); d != want {
+			t.Errorf("user %s discount %d, want %d", u.Hex(), d, want)
+		}
+	}
+}
+
+func TestTopTierOnlyOutput(t *testing.T) {
+	cfg, ch := optionTest(t, "TopTierOnly")
+	cfg.Tiers = nil
+	for j := range TierNum {
+		cfg.Tiers = append(cfg.Tiers, TierConfig{MinAmount: big.NewInt(int64(1_000) << (2 * j)), Discount: uint16(100 * (j + 1))})
+	}
+	top := cfg.Tiers[TierNum-1].MinAmount.Int64()
+	out := proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), top/2),
+		ch.swap(cfg, 120, user(2), top+1),
+		ch.swap(cfg, 130, user(3), 5_000),
+		ch.swap(cfg, 140, user(4), top*2),
+	})
+	rs := decodeResults(t, out)
+	if len(rs) != 2 || rs[0].Address != user(2) || rs[1].Address != user(4) {
+		t.Fatalf("results %+v, want only top tier users 2 and 4", rs)
+	}
+	for _, r := range rs {
+		if d := r.Values["discount"].Uint64(); d != uint64(cfg.Tiers[TierNum-1].Discount) {
+			t.Errorf("user %s discount %d, not the top tier's", r.Address.Hex(), d)
+		}
+	}
+}
+
+func TestTopTierOnlyTotalDiscount(t *testing.T) {
+	cfg, ch := optionTest(t, "TopTierOnly", "OutputTotalDiscount")
+	cfg.Tiers = nil
+	for j := range TierNum {
+		cfg.Tiers = append(cfg.Tiers, TierConfig{MinAmount: big.NewInt(int64(1_000) << (2 * j)), Discount: uint16(100 * (j + 1))})
+	}
+	top := cfg.Tiers[TierNum-1].MinAmount.Int64()
+	out := proveInMemory(t, ch, cfg, []Receipt{
+		ch.swap(cfg, 110, user(1), top/2),
+		ch.swap(cfg, 120, user(2), top+1),
+		ch.swap(cfg, 130, user(3), 5_000),
+		ch.swap(cfg, 140, user(4), top*2),
+	})
+	// users 1 and 3 have discounts 400 and 200 but no row
+	if got := decodeHeader(t, out)["totalDiscount"].Uint64(); got != 1_000 {
+		t.Errorf("total discount %d, want 1000 of the two top tier rows", got)
+	}
+	if rs := decodeResults(t, out); len(rs) != 2 {
+		t.Errorf("%d rows, want the 2 top tier users", len(rs))
+	}
+}
+
+func TestAuditSampleDeterministic(t *testing.T) {
+	requireOptions(t, "OutputAuditSample")
+	requireSimulated(t)
+	if AuditSampleNum != 4 {
+		t.Skip("expected samples are of 4 targets")
+	}
+	cfg := testConfig()
+	// epoch 7 over (100, 200) targets blocks 124, 177, 134 and 195
+	batch := func(moved uint64) (*chain, []Receipt) {
+		ch := newChain()
+		return ch, []Receipt{
+			ch.swap(cfg, 110, user(1), 5_000),
+			ch.swap(cfg, 125, user(1), 5_000),
+			ch.swap(cfg, moved, user(1), 5_000),
+			ch.swap(cfg, 130, user(2), 5_000),
+			ch.swap(cfg, 180, user(2), 5_000),
+			ch.swap(cfg, 140, user(3), 5_000),
+			ch.swap(cfg, 196, user(3), 5_000),
+		}
+	}
+	samples := func(out []byte) []uint64 {
+		h := decodeHeader(t, out)
+		var blocks []uint64
+		for k := range AuditSampleNum {
+			blocks = append(blocks, h[fmt.Sprintf("sample%dBlock", k)].Uint64())
+		}
+		return blocks
+	}
+	ch, receipts := batch(150)
+	out := proveInMemory(t, ch, cfg, receipts)
+	if again := proveInMemory(t, ch, cfg, receipts); !bytes.Equal(again, out) {
+		t.Fatal("proving the same receipts twice sampled differently")
+	}
+	if sim, err := cfg.Simulate(receipts); err != nil || !bytes.Equal(sim, out) {
+		t.Fatalf("proven output differs from Simulate, err %v", err)
+	}
+	_, pos, err := cfg.layout(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint64{125, 180, 140, 196}
+	for k, r := range cfg.AuditSample(pos) {
+		if r.BlockNum != want[k] {
+			t.Errorf("AuditSample %d at block %d, want %d", k, r.BlockNum, want[k])
+		}
+	}
+	if got := samples(out); !slices.Equal(got, want) {
+		t.Fatalf("sampled blocks %v, want %v", got, want)
+	}
+
+	// an unsampled receipt moved, still no closer to any target
+	ch, receipts = batch(160)
+	if got := samples(proveInMemory(t, ch, cfg, receipts)); !slices.Equal(got, want) {
+		t.Errorf("moving an unsampled receipt changed samples to %v", got)
+	}
+	// and moved right after target 177
+	ch, receipts = batch(178)
+	if got := samples(proveInMemory(t, ch, cfg, receipts)); got[1] != 178 {
+		t.Errorf("sample 1 at block %d, want the moved receipt's 178", got[1])
+	}
+}
@@ -0,0 +1,74 @@
+package circuit
+
+import (
+	"github.com/brevis-network/brevis-sdk/sdk"
+)
+
+// UniVipUserCircuit proves volume and discount of a single user, eg. a user checking own tier.
+// same receipt layout and checks as UniVipHookCircuit, but with only one segment of MaxPerUsr receipts
+type UniVipUserCircuit struct {
+	Epoch                sdk.Uint32
+	PoolAddr, HookAddr   sdk.Uint248
+	PoolId               sdk.Bytes32
+	BlockStart, BlockEnd sdk.Uint32
+
+	// same as UniVipHookCircuit, sorted from LOWEST to HIGHEST
+	TierMinAmount, TierDiscount [TierNum]sdk.Uint248
+	// with TierInclusive, same as UniVipHookCircuit
+	TierInclusive [TierNum]sdk.Uint248
+
+	User sdk.Uint248
+	// with RoundOutputVolume, output volume is rounded to a multiple of this
+	VolumePrecision sdk.Uint248
+}
+
+func (c *UniVipUserCircuit) Allocate() (maxReceipts, maxStorage, maxTransactions int) {
+	return MaxPerUsr, 0, 0
+}
+
+// output epoch:address:volume:discount
+func (c *UniVipUserCircuit) Define(api *sdk.CircuitAPI, in sdk.DataInput) error {
+	api.AssertInputsAreUnique()
+
+	receipts := sdk.NewDataStream(api, in.Receipts)
+	sdk.AssertEach(receipts, func(r sdk.Receipt) sdk.Uint248 {
+		return api.Uint248.And(
+			swapReceiptOK(api, r, c.PoolAddr, c.HookAddr, c.PoolId, c.BlockStart, c.BlockEnd),
+			// all receipts must be from the user
+			api.Uint248.IsEqual(receiptUser(api, r), c.User),
+		)
+	})
+
+	vol := segmentVolume(api, in.Receipts.Raw, 0, MaxPerUsr, c.User, func(_ int, r sdk.Receipt) sdk.Uint248 {
+		return swapAmount(api, r)
+	})
+
+	api.OutputUint32(32, c.Epoch)
+	api.OutputAddress(c.User)
+	discount := tierDiscount(api, vol, inclusiveMins(api, c.TierMinAmount, c.TierInclusive), c.TierDiscount)
+	if RoundOutputVolume {
+		vol = roundVolume(api, vol, c.VolumePrecision)
+	}
+	api.OutputUint(248, vol)
+	api.OutputUint(16, discount)
+	return nil
+}
+
+func DefaultUserCircuit() *UniVipUserCircuit {
+	ret := &UniVipUserCircuit{
+		PoolAddr:   sdk.ConstUint248(0),
+		HookAddr:   sdk.ConstUint248(0),
+		BlockStart: sdk.ConstUint32(0),
+		BlockEnd:   sdk.ConstUint32(0),
+		PoolId:     sdk.ConstFromBigEndianBytes(Hex2Bytes("0x0000000000000000000000000000000000000000000000000000000000000000")),
+		User:       sdk.ConstUint248(0),
+
+		VolumePrecision: sdk.ConstUint248(1),
+	}
+	for i := range TierNum {
+		ret.TierDiscount[i] = sdk.ConstUint248(0)
+		ret.TierMinAmount[i] = sdk.ConstUint248(0)
+		ret.TierInclusive[i] = sdk.ConstUint248(0)
+	}
+	return ret
+}
@@ -0,0 +1,129 @@
+package circuit
+
+import (
+	"encoding/binary"
+	"math/big"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/brevis-network/brevis-sdk/test"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// userCircuit is the user circuit of u with cfg's pool, blocks and tiers, padded like NewCircuit
+func userCircuit(cfg *Config, u common.Address) *UniVipUserCircuit {
+	c := DefaultUserCircuit()
+	c.Epoch = sdk.ConstUint32(cfg.Epoch)
+	c.PoolAddr = sdk.ConstUint248(cfg.PoolAddr.Big())
+	c.HookAddr = sdk.ConstUint248(cfg.HookAddr.Big())
+	c.PoolId = sdk.ConstFromBigEndianBytes(cfg.PoolId.Bytes())
+	c.BlockStart = sdk.ConstUint32(uint32(cfg.BlockStart))
+	c.BlockEnd = sdk.ConstUint32(uint32(cfg.BlockEnd))
+	c.User = sdk.ConstUint248(u.Big())
+	for i := range TierNum {
+		if i < len(cfg.Tiers) {
+			c.TierMinAmount[i] = sdk.ConstUint248(cfg.Tiers[i].MinAmount)
+			c.TierDiscount[i] = sdk.ConstUint248(uint64(cfg.Tiers[i].Discount))
+			c.TierInclusive[i] = boolConst(cfg.Tiers[i].Inclusive)
+		} else {
+			c.TierMinAmount[i] = sdk.ConstUint248(maxUint248)
+		}
+	}
+	return c
+}
+
+// userInput builds circuit input of c with receipts of ch in order
+func userInput(t *testing.T, ch *chain, cfg *Config, c *UniVipUserCircuit, receipts []Receipt) sdk.CircuitInput {
+	t.Helper()
+	app := newApp(t, ch)
+	for i, r := range receipts {
+		data, err := cfg.receiptData(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.AddReceipt(data, i)
+	}
+	in, err := app.BuildCircuitInput(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return in
+}
+
+func TestUserCircuitInclusiveTier(t *testing.T) {
+	cfg, ch := optionTest(t, "TierInclusive")
+	requireSimulated(t)
+	cfg.Tiers[0].Inclusive = true
+	// exactly the inclusive tier's min amount
+	r := ch.swap(cfg, 110, user(1), 1_000)
+
+	c := userCircuit(cfg, user(1))
+	data, err := cfg.receiptData(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := newApp(t, ch)
+	app.AddReceipt(data, 0)
+	in, err := app.BuildCircuitInput(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	test.IsSolved(t, c, c, in)
+	out := in.GetAbiPackedOutput()
+	got := binary.BigEndian.Uint16(out[len(out)-2:])
+
+	sim, err := cfg.Simulate([]Receipt{r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := resultOf(t, decodeResults(t, sim), user(1)).Values["discount"].Uint64()
+	if uint64(got) != want {
+		t.Fatalf("user circuit discount %d, batch circuit %d", got, want)
+	}
+	if !MarginalTiers && got != cfg.Tiers[0].Discount {
+		t.Fatalf("discount %d at inclusive min amount, want %d", got, cfg.Tiers[0].Discount)
+	}
+}
+
+func TestUserCircuitTier(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	ch := newChain()
+	c := userCircuit(cfg, user(1))
+	// 12000 in all, tier 1
+	in := userInput(t, ch, cfg, c, []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 111, user(1), -4_000),
+		ch.swap(cfg, 112, user(1), 3_000),
+	})
+	test.ProverSucceeded(t, DefaultUserCircuit(), c, in)
+	out := in.GetAbiPackedOutput()
+	if len(out) != 4+20+31+2 {
+		t.Fatalf("output of %d bytes, want epoch:address:volume:discount", len(out))
+	}
+	if a := common.BytesToAddress(out[4:24]); a != user(1) {
+		t.Errorf("address %s, want user 1", a.Hex())
+	}
+	if v := new(big.Int).SetBytes(out[24:55]); v.Int64() != 12_000 {
+		t.Errorf("volume %d, want 12000", v)
+	}
+	if d := binary.BigEndian.Uint16(out[55:]); !MarginalTiers && d != 300 {
+		t.Errorf("discount %d, want 300", d)
+	}
+}
+
+func TestUserCircuitRoundsOutputVolume(t *testing.T) {
+	cfg, ch := optionTest(t, "RoundOutputVolume")
+	c := userCircuit(cfg, user(1))
+	c.VolumePrecision = sdk.ConstUint248(1_000)
+	// 10400 is above tier 1's 10000, rounded it wouldn't be
+	in := userInput(t, ch, cfg, c, []Receipt{ch.swap(cfg, 110, user(1), 10_400)})
+	test.IsSolved(t, c, c, in)
+	out := in.GetAbiPackedOutput()
+	if v := new(big.Int).SetBytes(out[24:55]); v.Int64() != 10_000 {
+		t.Errorf("output volume %d, want 10400 rounded to 10000", v)
+	}
+	if d := binary.BigEndian.Uint16(out[55:]); !MarginalTiers && d != 300 {
+		t.Errorf("discount %d, want 300 of full precision volume", d)
+	}
+}
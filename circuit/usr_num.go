@@ -0,0 +1,5 @@
+//go:build !CuratedBatch
+
+package circuit
+
+const maxUsrNum = 32
@@ -0,0 +1,33 @@
+package circuit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// VKFingerprint returns keccak256 of vk's binary encoding, eg. the verifying key sdk.Compile returns. it only changes
+// when the compiled constraints change, ie. circuit code or constants, so a known fingerprint tells whether the
+// on-chain verifier must be redeployed
+func VKFingerprint(vk io.WriterTo) (common.Hash, error) {
+	var buf bytes.Buffer
+	if _, err := vk.WriteTo(&buf); err != nil {
+		return common.Hash{}, fmt.Errorf("encode vk: %w", err)
+	}
+	return crypto.Keccak256Hash(buf.Bytes()), nil
+}
+
+// CheckVKFingerprint returns error if vk's fingerprint isn't want, eg. the one recorded when the verifier was deployed
+func CheckVKFingerprint(vk io.WriterTo, want common.Hash) error {
+	got, err := VKFingerprint(vk)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("vk fingerprint %s doesn't match deployed %s, circuit changed and the verifier needs redeploying", got.Hex(), want.Hex())
+	}
+	return nil
+}
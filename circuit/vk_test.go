@@ -0,0 +1,71 @@
+package circuit
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/consensys/gnark-crypto/ecc"
+	"github.com/consensys/gnark/frontend"
+	"github.com/consensys/gnark/frontend/cs/scs"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// compiledFingerprint is VKFingerprint of app's compiled constraint system. the verifying key is set up from it
+// with a fixed SRS, so it changes exactly when the constraints do, and compiling needs no SRS
+func compiledFingerprint(t *testing.T, app sdk.AppCircuit) common.Hash {
+	t.Helper()
+	ccs, err := frontend.Compile(ecc.BN254.ScalarField(), scs.NewBuilder, sdk.DefaultHostCircuit(app))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fp, err := VKFingerprint(ccs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fp
+}
+
+func TestVKFingerprintFollowsStructure(t *testing.T) {
+	requireValidConfig(t)
+	want := compiledFingerprint(t, DefaultUniCircuit())
+	// another batch's config only changes the witness
+	c, err := testConfig().NewCircuit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := compiledFingerprint(t, c); got != want {
+		t.Errorf("fingerprint of a configured circuit %x, default %x", got, want)
+	}
+	if got := compiledFingerprint(t, DefaultUserCircuit()); got == want {
+		t.Error("user circuit has the batch circuit's fingerprint")
+	}
+}
+
+type fixedVK []byte
+
+func (vk fixedVK) WriteTo(w io.Writer) (int64, error) {
+	n, err := w.Write(vk)
+	return int64(n), err
+}
+
+type failingVK struct{}
+
+func (failingVK) WriteTo(io.Writer) (int64, error) { return 0, errors.New("no vk") }
+
+func TestCheckVKFingerprint(t *testing.T) {
+	deployed, err := VKFingerprint(fixedVK("vk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckVKFingerprint(fixedVK("vk"), deployed); err != nil {
+		t.Errorf("same vk: %v", err)
+	}
+	if err := CheckVKFingerprint(fixedVK("vk2"), deployed); err == nil {
+		t.Error("changed vk matches the deployed fingerprint")
+	}
+	if err := CheckVKFingerprint(failingVK{}, deployed); err == nil {
+		t.Error("vk that can't be encoded passed")
+	}
+}
@@ -0,0 +1,350 @@
+package circuit
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math/big"
+	"slices"
+
+	"github.com/brevis-network/brevis-sdk/sdk"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Receipt is one swap tx to prove, with positions of its hook TxOrigin log and pool Swap log
+type Receipt struct {
+	TxHash   common.Hash
+	BlockNum uint64
+	// tx.origin emitted by the hook, or the swap sender with NoHookLog
+	User common.Address
+	// unused with NoHookLog
+	HookLogPos uint
+	SwapLogPos uint
+	// index into configured pools, 0 is PoolId and m is ExtraPools[m-1]
+	Pool int
+	// only used with WeightedSwapLogs, nil if the tx has one swap
+	SecondSwapLogPos *uint
+	// signed value of the second swap log's amount field, only used by Simulate
+	SecondAmount *big.Int
+	// signed value of the amount field, only used by Simulate
+	Amount *big.Int
+	// with V3Pools, a v3 swap where Pool indexes V3PoolAddrs and User is the recipient, HookLogPos is unused
+	V3 bool
+	// resulting tick of the swap with TickRange, only used by Simulate
+	Tick int32
+	// gas used reported by the hook log with OutputGasWeightedVolume, only used by Simulate
+	Gas *big.Int
+	// tag of the hook log with RequireTag, only used by Simulate
+	Tag common.Hash
+	// emitters of the swap and hook logs and the swap's pool id, set by FetchBatchInput for CheckPoolHookMembership.
+	// zero values are unknown and not checked
+	SwapContract, HookContract common.Address
+	PoolId                     common.Hash
+}
+
+// Assignment is everything to prove one batch: circuit inputs, and receipts, storage slots and txs keyed by their
+// index in sdk.DataInput, which Define relies on
+type Assignment struct {
+	Circuit  *UniVipHookCircuit
+	Receipts map[int]sdk.ReceiptData
+	Storage  map[int]sdk.StorageData
+	Txs      map[int]sdk.TransactionData
+}
+
+// Assign lays receipts out into user segments and returns the matching assignment. Users are taken from
+// receipts in order of first appearance, cfg.Users is ignored. a user with more than MaxPerUsr receipts gets
+// several adjacent segments, with DeltaAddresses users are sorted instead. With OutputOtherVolume and a non-empty cfg.Users, only listed users get segments and
+// other receipts fill the free positions, counting as other volume
+func (cfg *Config) Assign(receipts []Receipt) (*Assignment, error) {
+	laid, pos, err := cfg.layout(receipts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := laid.NewCircuit()
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Assignment{
+		Circuit:  c,
+		Receipts: make(map[int]sdk.ReceiptData),
+		Storage:  make(map[int]sdk.StorageData),
+		Txs:      make(map[int]sdk.TransactionData),
+	}
+	slots := storageSlots()
+	for idx, r := range pos {
+		data, err := cfg.receiptData(r)
+		if err != nil {
+			return nil, err
+		}
+		a.Receipts[idx] = data
+		if CheckPoolLiquidity && !LiquidityAtStateRef {
+			a.Storage[slots.Liquidity+idx] = sdk.StorageData{
+				BlockNum: new(big.Int).SetUint64(r.BlockNum),
+				Address:  cfg.PoolAddr,
+				Slot:     LiquiditySlot(cfg.PoolId),
+			}
+		}
+	}
+	if CheckPoolLiquidity && LiquidityAtStateRef {
+		a.Storage[slots.Liquidity] = sdk.StorageData{
+			BlockNum: new(big.Int).SetUint64(cfg.stateRefBlock()),
+			Address:  cfg.PoolAddr,
+			Slot:     LiquiditySlot(cfg.PoolId),
+		}
+	}
+	if CheckHookImpl {
+		a.Storage[slots.HookImpl] = sdk.StorageData{
+			BlockNum: new(big.Int).SetUint64(cfg.stateRefBlock()),
+			Address:  cfg.HookAddr,
+			Slot:     common.HexToHash(ImplementationSlot),
+		}
+	}
+	if NumeraireVolume {
+		a.Storage[slots.Numeraire] = sdk.StorageData{
+			BlockNum: new(big.Int).SetUint64(cfg.stateRefBlock()),
+			Address:  cfg.NumeraireOracle,
+			Slot:     cfg.NumeraireSlot,
+		}
+	}
+	if ReputationBoost {
+		for i, u := range laid.Users {
+			if i == 0 || laid.Users[i-1] != u {
+				a.Storage[slots.Reputation+i] = sdk.StorageData{
+					BlockNum: new(big.Int).SetUint64(cfg.stateRefBlock()),
+					Address:  cfg.ReputationRegistry,
+					Slot:     AddressMappingSlot(u, cfg.ReputationMappingSlot),
+				}
+			}
+		}
+	}
+	if RequireOptIn {
+		for i, u := range laid.Users {
+			// like AgeProofTxs, only each user's first slot
+			if i == 0 || laid.Users[i-1] != u {
+				a.Storage[slots.OptIn+i] = sdk.StorageData{
+					BlockNum: new(big.Int).SetUint64(cfg.stateRefBlock()),
+					Address:  cfg.OptInRegistry,
+					Slot:     AddressMappingSlot(u, cfg.OptInMappingSlot),
+				}
+			}
+		}
+	}
+	if EOAUsersOnly {
+		start := transactionSlots().EOAProof
+		for i, u := range laid.Users {
+			if hash, ok := cfg.EOAProofTxs[u]; ok && (i == 0 || laid.Users[i-1] != u) {
+				a.Txs[start+i] = sdk.TransactionData{Hash: hash}
+			}
+		}
+	}
+	if PenalizeFreshUsers {
+		start := transactionSlots().AgeProof
+		for i, u := range laid.Users {
+			// one proof per user is enough, at its first slot
+			if hash, ok := cfg.AgeProofTxs[u]; ok && (i == 0 || laid.Users[i-1] != u) {
+				a.Txs[start+i] = sdk.TransactionData{Hash: hash}
+			}
+		}
+	}
+	return a, nil
+}
+
+// layout returns cfg with Users set to receipt segments as documented in Assign, and receipts by their index
+func (cfg *Config) layout(receipts []Receipt) (*Config, map[int]Receipt, error) {
+	listed := make(map[common.Address]bool)
+	if OutputOtherVolume {
+		for _, u := range cfg.Users {
+			listed[u] = true
+		}
+	}
+	var users []common.Address
+	var others []Receipt
+	byUser := make(map[common.Address][]Receipt)
+	for _, r := range receipts {
+		if len(listed) > 0 && !listed[r.User] {
+			others = append(others, r)
+			continue
+		}
+		if _, ok := byUser[r.User]; !ok {
+			users = append(users, r.User)
+		}
+		byUser[r.User] = append(byUser[r.User], r)
+	}
+
+	for _, u := range users {
+		n := len(byUser[u])
+		if OutputOutOfRangeCount {
+			// like assertUserSwaps, receipts outside the block range aren't the user's swaps
+			n = 0
+			for _, r := range byUser[u] {
+				if cfg.inBlockRange(r.BlockNum) {
+					n++
+				}
+			}
+		}
+		if CapUserSwaps && cfg.MaxUserSwaps != 0 && n > int(cfg.MaxUserSwaps) {
+			return nil, nil, fmt.Errorf("user %s has %d receipts, over MaxUserSwaps %d", u.Hex(), n, cfg.MaxUserSwaps)
+		}
+	}
+	if DeltaAddresses {
+		slices.SortFunc(users, func(a, b common.Address) int { return bytes.Compare(a[:], b[:]) })
+	}
+	laid := *cfg
+	laid.Users = nil
+	pos := make(map[int]Receipt)
+	for _, u := range users {
+		rs := byUser[u]
+		if AssertBlockOrder {
+			slices.SortStableFunc(rs, func(a, b Receipt) int { return cmp.Compare(a.BlockNum, b.BlockNum) })
+		}
+		for start := 0; start < len(rs); start += MaxPerUsr {
+			seg := len(laid.Users)
+			if seg == MaxUsrNum {
+				return nil, nil, fmt.Errorf("receipts need more than MaxUsrNum %d segments", MaxUsrNum)
+			}
+			laid.Users = append(laid.Users, u)
+			for k, r := range rs[start:min(start+MaxPerUsr, len(rs))] {
+				pos[seg*MaxPerUsr+k] = r
+			}
+		}
+	}
+	for idx := 0; idx < len(laid.Users)*MaxPerUsr && len(others) > 0; idx++ {
+		if _, ok := pos[idx]; !ok {
+			pos[idx], others = others[0], others[1:]
+		}
+	}
+	if len(others) > 0 {
+		return nil, nil, fmt.Errorf("no free position for %d receipts of unlisted users", len(others))
+	}
+	return &laid, pos, nil
+}
+
+// AddTo adds receipts, storage slots and transactions to app at their assigned index
+func (a *Assignment) AddTo(app *sdk.BrevisApp) {
+	for idx, r := range a.Receipts {
+		app.AddReceipt(r, idx)
+	}
+	for idx, s := range a.Storage {
+		app.AddStorage(s, idx)
+	}
+	for idx, tx := range a.Txs {
+		app.AddTransaction(tx, idx)
+	}
+}
+
+// v3ReceiptData returns r's fields in the layout v3SwapOK checks: recipient topic twice, amount0
+func (cfg *Config) v3ReceiptData(r Receipt) (sdk.ReceiptData, error) {
+	if !V3Pools || r.Pool < 0 || r.Pool >= len(cfg.V3PoolAddrs) {
+		return sdk.ReceiptData{}, fmt.Errorf("tx %s: unknown v3 pool %d", r.TxHash.Hex(), r.Pool)
+	}
+	recipient := sdk.LogFieldData{IsTopic: true, LogPos: r.SwapLogPos, FieldIndex: V3RecipientTopicIndex}
+	fields := []sdk.LogFieldData{recipient, recipient, {IsTopic: false, LogPos: r.SwapLogPos, FieldIndex: AmountDataIndex}}
+	if TickRange {
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: r.SwapLogPos, FieldIndex: TickDataIndex})
+	}
+	return sdk.ReceiptData{
+		TxHash:   r.TxHash,
+		BlockNum: new(big.Int).SetUint64(r.BlockNum),
+		Fields:   fields,
+	}, nil
+}
+
+// poolAmountIndex returns data index of the amount counted as volume for pool, see Receipt.Pool
+func (cfg *Config) poolAmountIndex(pool int) (uint64, error) {
+	if !CanonicalVolumeToken {
+		return AmountDataIndex, nil
+	}
+	c0, c1 := cfg.Currency0, cfg.Currency1
+	if pool > 0 {
+		c0, c1 = cfg.ExtraPools[pool-1].Currency0, cfg.ExtraPools[pool-1].Currency1
+	}
+	return cfg.amountIndex(c0, c1)
+}
+
+// receiptData returns r's fields in the layout swapReceiptOK checks: TxOrigin topic, poolid, amount, and the optional
+// fourth field
+func (cfg *Config) receiptData(r Receipt) (sdk.ReceiptData, error) {
+	if r.V3 {
+		return cfg.v3ReceiptData(r)
+	}
+	if r.Pool < 0 || r.Pool > len(cfg.ExtraPools) {
+		return sdk.ReceiptData{}, fmt.Errorf("tx %s: unknown pool %d", r.TxHash.Hex(), r.Pool)
+	}
+	amountIdx, err := cfg.poolAmountIndex(r.Pool)
+	if err != nil {
+		return sdk.ReceiptData{}, err
+	}
+	originIdx := uint(OriginTopicIndex)
+	if PerHookConfig {
+		originIdx = cfg.HookLayout.originIndex()
+		if r.Pool > 0 {
+			originIdx = cfg.ExtraPools[r.Pool-1].originIndex()
+		}
+	}
+	userField := sdk.LogFieldData{IsTopic: true, LogPos: r.HookLogPos, FieldIndex: originIdx}
+	if NoHookLog {
+		userField = sdk.LogFieldData{IsTopic: true, LogPos: r.SwapLogPos, FieldIndex: SwapSenderTopicIndex}
+	}
+	fields := []sdk.LogFieldData{
+		userField,
+		{IsTopic: PoolIdIsTopic, LogPos: r.SwapLogPos, FieldIndex: PoolIdFieldIndex},
+		{IsTopic: false, LogPos: r.SwapLogPos, FieldIndex: uint(amountIdx)},
+	}
+	if WeightedSwapLogs && r.SecondSwapLogPos != nil {
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: *r.SecondSwapLogPos, FieldIndex: AmountDataIndex})
+	}
+	if FilterDustSwaps {
+		// the amount not used for volume, for the dust check
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: r.SwapLogPos, FieldIndex: uint(AmountDataIndex + Amount1DataIndex - amountIdx)})
+	}
+	if TickRange {
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: r.SwapLogPos, FieldIndex: TickDataIndex})
+	}
+	if OutputGasWeightedVolume {
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: r.HookLogPos, FieldIndex: HookGasDataIndex})
+	}
+	if RequireTag {
+		fields = append(fields, sdk.LogFieldData{IsTopic: false, LogPos: r.HookLogPos, FieldIndex: HookTagDataIndex})
+	}
+	return sdk.ReceiptData{
+		TxHash:   r.TxHash,
+		BlockNum: new(big.Int).SetUint64(r.BlockNum),
+		Fields:   fields,
+	}, nil
+}
+
+// CheckPoolHookMembership returns an error for the first receipt whose pool, swap contract, pool id or hook contract
+// isn't one cfg configures, which the circuit would reject. it catches receipts fetched from the wrong pool's logs
+// before an expensive compile
+func CheckPoolHookMembership(receipts []Receipt, cfg *Config) error {
+	for _, r := range receipts {
+		if r.V3 {
+			if !V3Pools || r.Pool < 0 || r.Pool >= len(cfg.V3PoolAddrs) {
+				return fmt.Errorf("tx %s: unknown v3 pool %d", r.TxHash.Hex(), r.Pool)
+			}
+			if r.SwapContract != (common.Address{}) && r.SwapContract != cfg.V3PoolAddrs[r.Pool] {
+				return fmt.Errorf("tx %s: swap from %s, not v3 pool %s", r.TxHash.Hex(), r.SwapContract.Hex(), cfg.V3PoolAddrs[r.Pool].Hex())
+			}
+			continue
+		}
+		if r.Pool < 0 || r.Pool > len(cfg.ExtraPools) || r.Pool > 0 && !MultiPool {
+			return fmt.Errorf("tx %s: unknown pool %d", r.TxHash.Hex(), r.Pool)
+		}
+		poolId, hook := cfg.PoolId, cfg.HookAddr
+		if r.Pool > 0 {
+			poolId, hook = cfg.ExtraPools[r.Pool-1].PoolId, cfg.ExtraPools[r.Pool-1].HookAddr
+		}
+		if r.SwapContract != (common.Address{}) && r.SwapContract != cfg.PoolAddr {
+			return fmt.Errorf("tx %s: swap from %s, not pool manager %s", r.TxHash.Hex(), r.SwapContract.Hex(), cfg.PoolAddr.Hex())
+		}
+		if r.PoolId != (common.Hash{}) && r.PoolId != poolId {
+			return fmt.Errorf("tx %s: swap in pool %s, not %s", r.TxHash.Hex(), r.PoolId.Hex(), poolId.Hex())
+		}
+		// with NoHookLog the user field is from the swap log
+		if !NoHookLog && r.HookContract != (common.Address{}) && r.HookContract != hook {
+			return fmt.Errorf("tx %s: hook log from %s, not hook %s", r.TxHash.Hex(), r.HookContract.Hex(), hook.Hex())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package circuit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/brevis-network/brevis-sdk/test"
+)
+
+func TestAssignProves(t *testing.T) {
+	requireDefaults(t)
+	cfg := testConfig()
+	ch := newChain()
+	receipts := []Receipt{
+		ch.swap(cfg, 110, user(1), 5_000),
+		ch.swap(cfg, 120, user(2), -20_000),
+		ch.swap(cfg, 130, user(1), 700),
+	}
+	a, err := cfg.Assign(receipts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, err := buildInput(t, ch, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// compiles DefaultUniCircuit, the shape every assignment shares, and proves a against it
+	test.ProverSucceeded(t, DefaultUniCircuit(), a.Circuit, in)
+}
+
+func TestPoolHookMembershipFlagsUnconfiguredPool(t *testing.T) {
+	cfg := testConfig()
+	ch := newChain()
+	receipts := []Receipt{ch.swap(cfg, 110, user(1), 5_000), ch.swap(cfg, 120, user(2), 5_000)}
+	if err := CheckPoolHookMembership(receipts, cfg); err != nil {
+		t.Fatal(err)
+	}
+	other := testConfig()
+	other.PoolId = testExtraPool.PoolId
+	wrong := ch.swap(other, 130, user(3), 5_000)
+	err := CheckPoolHookMembership(append(receipts, wrong), cfg)
+	if err == nil || !strings.Contains(err.Error(), wrong.TxHash.Hex()) {
+		t.Fatalf("error %v, want one naming tx %s", err, wrong.TxHash.Hex())
+	}
+}